@@ -0,0 +1,152 @@
+package fsdb
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// DefaultBatchThreadNum is the default number of goroutines used by batch
+// operations.
+const DefaultBatchThreadNum = 8
+
+// ReadResult is the result of a single key in ReadMany.
+type ReadResult struct {
+	// Reader is the ReadCloser of the entry when Err is nil.
+	//
+	// It's the caller's responsibility to close it.
+	Reader io.ReadCloser
+
+	// Err is the error of reading the entry,
+	// e.g. a NoSuchKeyError if the key does not exist.
+	Err error
+}
+
+// WriteEntry is a single entry to be written by WriteMany.
+type WriteEntry struct {
+	Key  Key
+	Data io.Reader
+}
+
+// Batcher defines an optional interface for FSDB implementations that can
+// operate on multiple keys at once more efficiently.
+//
+// The results are always in the same order as the input.
+// If the same key appears multiple times in a single call,
+// the order they are handled is undefined.
+type Batcher interface {
+	// ReadMany opens multiple entries.
+	//
+	// It's the caller's responsibility to close all the non-nil readers
+	// returned.
+	ReadMany(ctx context.Context, keys []Key) []ReadResult
+
+	// WriteMany writes multiple entries.
+	//
+	// Every entry is written atomically, but the batch as a whole is not.
+	// It returns the error of every entry, nil on success.
+	WriteMany(ctx context.Context, entries []WriteEntry) []error
+
+	// DeleteMany deletes multiple entries.
+	//
+	// It returns the error of every key, nil on success.
+	DeleteMany(ctx context.Context, keys []Key) []error
+}
+
+// AsBatcher returns db as a Batcher.
+//
+// If db implements Batcher, it's returned directly.
+// Otherwise it's wrapped by NewBatchAdapter with DefaultBatchThreadNum.
+func AsBatcher(db FSDB) Batcher {
+	if b, ok := db.(Batcher); ok {
+		return b
+	}
+	return NewBatchAdapter(db, DefaultBatchThreadNum)
+}
+
+// NewBatchAdapter creates a Batcher calling Read, Write and Delete of db in
+// parallel, with at most threads goroutines.
+//
+// If threads <= 0, DefaultBatchThreadNum will be used.
+func NewBatchAdapter(db FSDB, threads int) Batcher {
+	return &batchAdapter{
+		db:      db,
+		threads: threads,
+	}
+}
+
+type batchAdapter struct {
+	db      FSDB
+	threads int
+}
+
+func (b *batchAdapter) ReadMany(ctx context.Context, keys []Key) []ReadResult {
+	results := make([]ReadResult, len(keys))
+	errs := BatchDo(ctx, len(keys), b.threads, func(i int) (err error) {
+		results[i].Reader, err = b.db.Read(ctx, keys[i])
+		return err
+	})
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	return results
+}
+
+func (b *batchAdapter) WriteMany(
+	ctx context.Context,
+	entries []WriteEntry,
+) []error {
+	return BatchDo(ctx, len(entries), b.threads, func(i int) error {
+		return b.db.Write(ctx, entries[i].Key, entries[i].Data)
+	})
+}
+
+func (b *batchAdapter) DeleteMany(ctx context.Context, keys []Key) []error {
+	return BatchDo(ctx, len(keys), b.threads, func(i int) error {
+		return b.db.Delete(ctx, keys[i])
+	})
+}
+
+// BatchDo calls f for every i in [0, n) with at most threads goroutines,
+// and returns the errors in the order of i.
+//
+// If threads <= 0, DefaultBatchThreadNum will be used.
+//
+// Once ctx is canceled, f is no longer called,
+// and the errors of the remaining i are ctx.Err().
+func BatchDo(ctx context.Context, n, threads int, f func(i int) error) []error {
+	errs := make([]error, n)
+	if threads <= 0 {
+		threads = DefaultBatchThreadNum
+	}
+	if threads > n {
+		threads = n
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for t := 0; t < threads; t++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = f(i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			for ; i < n; i++ {
+				errs[i] = ctx.Err()
+			}
+			break feed
+		case indices <- i:
+		}
+	}
+	close(indices)
+	wg.Wait()
+	return errs
+}
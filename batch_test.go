@@ -0,0 +1,104 @@
+package fsdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fishy/fsdb"
+)
+
+func TestBatchDo(t *testing.T) {
+	const n = 100
+	const threads = 4
+
+	var running, max int32
+	errs := fsdb.BatchDo(context.Background(), n, threads, func(i int) error {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&max)
+			if current <= old || atomic.CompareAndSwapInt32(&max, old, current) {
+				break
+			}
+		}
+		if i%2 == 0 {
+			return &fsdb.NoSuchKeyError{}
+		}
+		return nil
+	})
+	if len(errs) != n {
+		t.Fatalf("Expected %d errors, got %d", n, len(errs))
+	}
+	for i, err := range errs {
+		if (i%2 == 0) != fsdb.IsNoSuchKeyError(err) {
+			t.Errorf("Unexpected error for %d: %v", i, err)
+		}
+	}
+	if max > threads {
+		t.Errorf("Expected at most %d concurrent calls, got %d", threads, max)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, err := range fsdb.BatchDo(ctx, n, threads, func(i int) error {
+		return nil
+	}) {
+		// Some of them might still be called before cancellation is noticed.
+		if err != nil && err != context.Canceled {
+			t.Errorf("Expected nil or context.Canceled for %d, got %v", i, err)
+		}
+	}
+}
+
+func TestBatchAdapter(t *testing.T) {
+	ctx := context.Background()
+	db := &mapDB{data: make(map[string][]byte)}
+	batch := fsdb.AsBatcher(db)
+
+	entries := []fsdb.WriteEntry{
+		{Key: fsdb.Key("foo"), Data: strings.NewReader("foo")},
+		{Key: fsdb.Key("bar"), Data: strings.NewReader("bar")},
+	}
+	for i, err := range batch.WriteMany(ctx, entries) {
+		if err != nil {
+			t.Errorf("WriteMany %d failed: %v", i, err)
+		}
+	}
+
+	keys := []fsdb.Key{fsdb.Key("bar"), fsdb.Key("baz"), fsdb.Key("foo")}
+	results := batch.ReadMany(ctx, keys)
+	expected := []string{"bar", "", "foo"}
+	for i, result := range results {
+		if expected[i] == "" {
+			if !fsdb.IsNoSuchKeyError(result.Err) {
+				t.Errorf("ReadMany %v expected NoSuchKeyError, got %v", keys[i], result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("ReadMany %v failed: %v", keys[i], result.Err)
+			continue
+		}
+		buf, err := ioutil.ReadAll(result.Reader)
+		result.Reader.Close()
+		if err != nil {
+			t.Errorf("ReadMany %v read failed: %v", keys[i], err)
+		}
+		if string(buf) != expected[i] {
+			t.Errorf("ReadMany %v expected %q, got %q", keys[i], expected[i], buf)
+		}
+	}
+
+	errs := batch.DeleteMany(ctx, keys)
+	for i, err := range errs {
+		if (expected[i] == "") != fsdb.IsNoSuchKeyError(err) {
+			t.Errorf("DeleteMany %v unexpected error: %v", keys[i], err)
+		}
+	}
+	if len(db.data) != 0 {
+		t.Errorf("Expected empty db after DeleteMany, got %v", db.data)
+	}
+}
@@ -22,3 +22,12 @@ type Bucket interface {
 	// entry does not exist on the bucket.
 	IsNotExist(err error) bool
 }
+
+// Lister defines an optional interface for Buckets that can list their
+// entries.
+type Lister interface {
+	// List calls nameFunc with the names of all the entries in the bucket.
+	//
+	// nameFunc should return true to continue and false to abort.
+	List(ctx context.Context, nameFunc func(name string) bool) error
+}
@@ -0,0 +1,20 @@
+package bucket_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fishy/fsdb/bucket"
+	"github.com/fishy/fsdb/buckettest"
+)
+
+func TestConformance(t *testing.T) {
+	root, err := ioutil.TempDir("", "bucket_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	buckettest.TestBucket(t, bucket.MockBucket(root))
+}
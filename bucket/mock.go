@@ -10,8 +10,11 @@ import (
 	"github.com/fishy/fsdb/local"
 )
 
-// Make sure *Mock satisfies Bucket interface.
-var _ Bucket = (*Mock)(nil)
+// Make sure *Mock satisfies Bucket and Lister interfaces.
+var (
+	_ Bucket = (*Mock)(nil)
+	_ Lister = (*Mock)(nil)
+)
 
 // MockOperationDelay defines the delays of an operation (function call).
 // It's useful to mimic network latency in local tests.
@@ -52,6 +55,14 @@ func MockBucket(root string) *Mock {
 	}
 }
 
+// MockBucketWithFSDB creates a new mock Bucket backed by the given local FSDB,
+// e.g. an in-memory one from package memory.
+func MockBucketWithFSDB(db fsdb.Local) *Mock {
+	return &Mock{
+		db: db,
+	}
+}
+
 // Read reads the file from fsdb.
 func (m *Mock) Read(ctx context.Context, name string) (io.ReadCloser, error) {
 	var wg sync.WaitGroup
@@ -97,6 +108,17 @@ func (m *Mock) Delete(ctx context.Context, name string) error {
 	return m.db.Delete(ctx, fsdb.Key(name))
 }
 
+// List scans the keys in fsdb.
+func (m *Mock) List(ctx context.Context, nameFunc func(name string) bool) error {
+	return m.db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			return nameFunc(string(key))
+		},
+		fsdb.StopAll,
+	)
+}
+
 // IsNotExist calls fsdb.IsNoSuchKeyError.
 func (m *Mock) IsNotExist(err error) bool {
 	return fsdb.IsNoSuchKeyError(err)
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/memory"
 )
 
 func TestMock(t *testing.T) {
@@ -211,3 +212,23 @@ func scanKeys(t *testing.T, db fsdb.Local) []fsdb.Key {
 	}
 	return keys
 }
+
+func TestMockList(t *testing.T) {
+	ctx := context.Background()
+	mock := MockBucketWithFSDB(memory.Open(memory.NewDefaultOptions()))
+	for _, name := range []string{"foo", "bar"} {
+		if err := mock.Write(ctx, name, strings.NewReader(name)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	names := make(map[string]bool)
+	if err := mock.List(ctx, func(name string) bool {
+		names[name] = true
+		return true
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(names) != 2 || !names["foo"] || !names["bar"] {
+		t.Errorf("List expected foo and bar, got %v", names)
+	}
+}
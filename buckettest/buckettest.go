@@ -0,0 +1,210 @@
+package buckettest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb/bucket"
+)
+
+// Prefix is the prefix of all the object names used by TestBucket.
+const Prefix = "buckettest/"
+
+// LargeObjectSize is the size of the object used by the large object test.
+const LargeObjectSize = 5*1024*1024 + 7
+
+// TestBucket runs the conformance tests of bucket.Bucket interface on b.
+func TestBucket(t *testing.T, b bucket.Bucket) {
+	run := fmt.Sprintf("%s%d/", Prefix, time.Now().UnixNano())
+	for _, c := range []struct {
+		name string
+		test func(t *testing.T, b bucket.Bucket, prefix string)
+	}{
+		{"NotExist", testNotExist},
+		{"ReadWriteDelete", testReadWriteDelete},
+		{"Overwrite", testOverwrite},
+		{"Empty", testEmpty},
+		{"Large", testLarge},
+		{"Canceled", testCanceled},
+	} {
+		test := c.test
+		prefix := run + c.name + "/"
+		t.Run(c.name, func(t *testing.T) {
+			test(t, b, prefix)
+		})
+	}
+}
+
+func testNotExist(t *testing.T, b bucket.Bucket, prefix string) {
+	ctx := context.Background()
+	name := prefix + "foo"
+
+	reader, err := b.Read(ctx, name)
+	if err == nil {
+		reader.Close()
+		t.Fatalf("Read on non-exist object %q should fail", name)
+	}
+	if !b.IsNotExist(err) {
+		t.Errorf("IsNotExist should be true for Read error, got %v", err)
+	}
+
+	err = b.Delete(ctx, name)
+	if err == nil {
+		t.Fatalf("Delete on non-exist object %q should fail", name)
+	}
+	if !b.IsNotExist(err) {
+		t.Errorf("IsNotExist should be true for Delete error, got %v", err)
+	}
+
+	if b.IsNotExist(nil) {
+		t.Error("IsNotExist should be false for nil error")
+	}
+	if b.IsNotExist(context.Canceled) {
+		t.Error("IsNotExist should be false for context.Canceled")
+	}
+}
+
+func testReadWriteDelete(t *testing.T, b bucket.Bucket, prefix string) {
+	name := prefix + "foo"
+	content := []byte("Hello, world!")
+
+	write(t, b, name, content)
+	defer cleanup(b, name)
+	read(t, b, name, content)
+	// Read again
+	read(t, b, name, content)
+	remove(t, b, name)
+	readNotExist(t, b, name)
+}
+
+func testOverwrite(t *testing.T, b bucket.Bucket, prefix string) {
+	name := prefix + "foo"
+
+	write(t, b, name, []byte("long content to be overwritten"))
+	defer cleanup(b, name)
+	write(t, b, name, []byte("short"))
+	read(t, b, name, []byte("short"))
+	write(t, b, name, []byte("longer content after overwritten"))
+	read(t, b, name, []byte("longer content after overwritten"))
+	remove(t, b, name)
+}
+
+func testEmpty(t *testing.T, b bucket.Bucket, prefix string) {
+	name := prefix + "foo"
+
+	write(t, b, name, nil)
+	defer cleanup(b, name)
+	read(t, b, name, nil)
+	remove(t, b, name)
+	readNotExist(t, b, name)
+}
+
+func testLarge(t *testing.T, b bucket.Bucket, prefix string) {
+	name := prefix + "foo"
+	data := make([]byte, LargeObjectSize)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	write(t, b, name, data)
+	defer cleanup(b, name)
+	read(t, b, name, data)
+	remove(t, b, name)
+}
+
+func testCanceled(t *testing.T, b bucket.Bucket, prefix string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	name := prefix + "foo"
+
+	if err := b.Write(ctx, name, strings.NewReader("foo")); err == nil {
+		cleanup(b, name)
+		t.Fatal("Write with canceled context should fail")
+	}
+	readNotExist(t, b, name)
+
+	write(t, b, name, []byte("foo"))
+	defer cleanup(b, name)
+	if reader, err := b.Read(ctx, name); err == nil {
+		// Some implementations only fail on the first read of the data.
+		_, err = ioutil.ReadAll(reader)
+		reader.Close()
+		if err == nil {
+			t.Error("Read with canceled context should fail")
+		}
+	} else if b.IsNotExist(err) {
+		t.Errorf("IsNotExist should be false for canceled Read, got %v", err)
+	}
+	if err := b.Delete(ctx, name); err == nil {
+		t.Fatal("Delete with canceled context should fail")
+	} else if b.IsNotExist(err) {
+		t.Errorf("IsNotExist should be false for canceled Delete, got %v", err)
+	}
+	read(t, b, name, []byte("foo"))
+	remove(t, b, name)
+}
+
+func write(t *testing.T, b bucket.Bucket, name string, data []byte) {
+	t.Helper()
+
+	if err := b.Write(context.Background(), name, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Write %q failed: %v", name, err)
+	}
+}
+
+func read(t *testing.T, b bucket.Bucket, name string, expected []byte) {
+	t.Helper()
+
+	reader, err := b.Read(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Read %q failed: %v", name, err)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read %q content failed: %v", name, err)
+	}
+	if !bytes.Equal(actual, expected) {
+		if len(expected) > 64 || len(actual) > 64 {
+			t.Errorf(
+				"Read %q expected %d bytes, got %d bytes (or different content)",
+				name,
+				len(expected),
+				len(actual),
+			)
+		} else {
+			t.Errorf("Read %q expected %q, got %q", name, expected, actual)
+		}
+	}
+}
+
+func readNotExist(t *testing.T, b bucket.Bucket, name string) {
+	t.Helper()
+
+	reader, err := b.Read(context.Background(), name)
+	if err == nil {
+		reader.Close()
+		t.Errorf("Read %q should fail", name)
+		return
+	}
+	if !b.IsNotExist(err) {
+		t.Errorf("Read %q expected IsNotExist error, got %v", name, err)
+	}
+}
+
+func remove(t *testing.T, b bucket.Bucket, name string) {
+	t.Helper()
+
+	if err := b.Delete(context.Background(), name); err != nil {
+		t.Fatalf("Delete %q failed: %v", name, err)
+	}
+}
+
+// cleanup deletes an object regardless of whether it exists.
+func cleanup(b bucket.Bucket, name string) {
+	b.Delete(context.Background(), name)
+}
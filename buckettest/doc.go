@@ -0,0 +1,13 @@
+// Package buckettest provides conformance tests for bucket.Bucket
+// implementations.
+//
+// Call TestBucket from a test in the package of your implementation,
+// with a bucket dedicated to the test:
+//
+//	func TestConformance(t *testing.T) {
+//		buckettest.TestBucket(t, NewBucket(testBucketName))
+//	}
+//
+// The tests only touch objects with names prefixed by "buckettest/",
+// and delete them after the test.
+package buckettest
@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies FSDB interface.
+var _ FSDB = (*impl)(nil)
+
+// FSDB is the fsdb.FSDB returned by Open.
+type FSDB interface {
+	fsdb.FSDB
+
+	// Stats returns the current statistics of the cache.
+	Stats() Stats
+
+	// Purge removes all the entries from the cache.
+	//
+	// It does not reset the counters in Stats.
+	Purge()
+}
+
+// Stats are the statistics of the cache, for monitoring.
+type Stats struct {
+	// Hits is the number of reads served from the cache.
+	Hits int64
+
+	// Misses is the number of reads served from the underlying FSDB.
+	Misses int64
+
+	// Evictions is the number of entries evicted to make room for new ones.
+	//
+	// Entries invalidated by Write and Delete are not counted.
+	Evictions int64
+
+	// Entries is the number of entries currently cached.
+	Entries int
+
+	// Size is the total size of the data currently cached in bytes.
+	Size int64
+}
+
+type impl struct {
+	db   fsdb.FSDB
+	opts Options
+
+	lock    sync.Mutex
+	lru     *list.List // of *entry, most recently used at front
+	entries map[string]*list.Element
+	fills   map[string]map[*fill]bool
+	stats   Stats
+}
+
+// entry is a single entry cached.
+type entry struct {
+	key  string
+	data []byte
+
+	// generation is the generation reported by the underlying FSDB,
+	// only valid when versioned is true.
+	generation int64
+	versioned  bool
+}
+
+// reader returns a ReadCloser of the cached data,
+// which also implements fsdb.Versioned if the underlying FSDB reported the
+// generation.
+func (e *entry) reader() io.ReadCloser {
+	return withGeneration(
+		ioutil.NopCloser(bytes.NewReader(e.data)),
+		e.generation,
+		e.versioned,
+	)
+}
+
+// fill is an in-flight read from the underlying FSDB on a cache miss.
+type fill struct {
+	// stale is set when the key is written or deleted after the fill started,
+	// in which case the data read must not be cached.
+	stale bool
+}
+
+// Open creates a cache FSDB in front of db.
+//
+// Read serves the data from an in-memory LRU cache when possible.
+// Write and Delete invalidate the cached entry of the key.
+//
+// Changes made to db directly (not through the cache FSDB),
+// including expirations, are not visible until the entry is evicted.
+func Open(db fsdb.FSDB, opts Options) FSDB {
+	return &impl{
+		db:      db,
+		opts:    opts,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		fills:   make(map[string]map[*fill]bool),
+	}
+}
+
+func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	db.lock.Lock()
+	if elem, ok := db.entries[string(key)]; ok {
+		db.lru.MoveToFront(elem)
+		db.stats.Hits++
+		e := elem.Value.(*entry)
+		db.lock.Unlock()
+		return e.reader(), nil
+	}
+	db.stats.Misses++
+	f := &fill{}
+	if db.fills[string(key)] == nil {
+		db.fills[string(key)] = make(map[*fill]bool)
+	}
+	db.fills[string(key)][f] = true
+	db.lock.Unlock()
+
+	reader, err := db.db.Read(ctx, key)
+	if err != nil {
+		db.finishFill(key, f, nil)
+		return nil, err
+	}
+	e := &entry{
+		key: string(key),
+	}
+	if v, ok := reader.(fsdb.Versioned); ok {
+		e.generation = v.Generation()
+		e.versioned = true
+	}
+	// Read one more byte than the limit to tell whether it's too large.
+	maxEntrySize := db.opts.GetMaxEntrySize()
+	e.data, err = ioutil.ReadAll(io.LimitReader(reader, maxEntrySize+1))
+	if err != nil {
+		reader.Close()
+		db.finishFill(key, f, nil)
+		return nil, err
+	}
+	if int64(len(e.data)) > maxEntrySize {
+		db.finishFill(key, f, nil)
+		return withGeneration(
+			&multiReadCloser{
+				Reader: io.MultiReader(bytes.NewReader(e.data), reader),
+				Closer: reader,
+			},
+			e.generation,
+			e.versioned,
+		), nil
+	}
+	reader.Close()
+	db.finishFill(key, f, e)
+	return e.reader(), nil
+}
+
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	db.invalidate(key)
+	defer db.invalidate(key)
+	return db.db.Write(ctx, key, data)
+}
+
+func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
+	db.invalidate(key)
+	defer db.invalidate(key)
+	return db.db.Delete(ctx, key)
+}
+
+func (db *impl) Stats() Stats {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	stats := db.stats
+	stats.Entries = db.lru.Len()
+	return stats
+}
+
+func (db *impl) Purge() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.lru.Init()
+	db.entries = make(map[string]*list.Element)
+	db.stats.Size = 0
+}
+
+// invalidate removes the cached entry of key,
+// and marks all the in-flight fills of key as stale.
+func (db *impl) invalidate(key fsdb.Key) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if elem, ok := db.entries[string(key)]; ok {
+		db.remove(elem)
+	}
+	for f := range db.fills[string(key)] {
+		f.stale = true
+	}
+}
+
+// finishFill finishes an in-flight fill,
+// and caches e if it's not nil and the fill is not stale.
+func (db *impl) finishFill(key fsdb.Key, f *fill, e *entry) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	delete(db.fills[string(key)], f)
+	if len(db.fills[string(key)]) == 0 {
+		delete(db.fills, string(key))
+	}
+	if e == nil || f.stale {
+		return
+	}
+
+	if elem, ok := db.entries[string(key)]; ok {
+		// Filled by another concurrent read.
+		db.remove(elem)
+	}
+	size := int64(len(e.data))
+	maxSize := db.opts.GetMaxSize()
+	if size > maxSize {
+		return
+	}
+	for db.stats.Size+size > maxSize {
+		db.remove(db.lru.Back())
+		db.stats.Evictions++
+	}
+	db.entries[string(key)] = db.lru.PushFront(e)
+	db.stats.Size += size
+}
+
+// remove removes an element from the LRU.
+//
+// It must be called with the lock held.
+func (db *impl) remove(elem *list.Element) {
+	e := db.lru.Remove(elem).(*entry)
+	delete(db.entries, e.key)
+	db.stats.Size -= int64(len(e.data))
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// versionedReadCloser is a ReadCloser implementing fsdb.Versioned.
+type versionedReadCloser struct {
+	io.ReadCloser
+
+	generation int64
+}
+
+func (r *versionedReadCloser) Generation() int64 {
+	return r.generation
+}
+
+// withGeneration wraps reader to implement fsdb.Versioned if versioned is true.
+func withGeneration(
+	reader io.ReadCloser,
+	generation int64,
+	versioned bool,
+) io.ReadCloser {
+	if !versioned {
+		return reader
+	}
+	return &versionedReadCloser{
+		ReadCloser: reader,
+		generation: generation,
+	}
+}
@@ -0,0 +1,220 @@
+package cache_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/cache"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/memory"
+)
+
+func TestConformance(t *testing.T) {
+	fsdbtest.TestFSDB(t, func(t *testing.T) fsdb.FSDB {
+		return cache.Open(
+			memory.Open(memory.NewDefaultOptions()),
+			cache.NewDefaultOptions(),
+		)
+	})
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	var reads int64
+	underlying := memory.Open(memory.NewDefaultOptions().SetHook(
+		func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+			if op == memory.OpRead {
+				atomic.AddInt64(&reads, 1)
+			}
+			return nil
+		},
+	))
+	db := cache.Open(underlying, cache.NewDefaultOptions())
+	key := fsdb.Key("foo")
+
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		checkRead(t, db, key, "foo")
+	}
+	if reads != 1 {
+		t.Errorf("Expected 1 read on the underlying FSDB, got %d", reads)
+	}
+	checkStats(t, db, cache.Stats{Hits: 2, Misses: 1, Entries: 1, Size: 3})
+
+	// Write invalidates.
+	if err := db.Write(ctx, key, strings.NewReader("foobar")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkRead(t, db, key, "foobar")
+	checkRead(t, db, key, "foobar")
+	checkStats(t, db, cache.Stats{Hits: 3, Misses: 2, Entries: 1, Size: 6})
+
+	// Delete invalidates.
+	if err := db.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read after delete expected NoSuchKeyError, got %v", err)
+	}
+	checkStats(t, db, cache.Stats{Hits: 3, Misses: 3})
+
+	// Changes made to the underlying FSDB directly are not visible.
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkRead(t, db, key, "foo")
+	if err := underlying.Write(ctx, key, strings.NewReader("bar")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkRead(t, db, key, "foo")
+	db.Purge()
+	checkRead(t, db, key, "bar")
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	db := cache.Open(
+		memory.Open(memory.NewDefaultOptions()),
+		cache.NewDefaultOptions().SetMaxSize(10).SetMaxEntrySize(5),
+	)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Write(ctx, fsdb.Key(key), strings.NewReader(strings.Repeat(key, 4))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	large := strings.Repeat("x", 100)
+	if err := db.Write(ctx, fsdb.Key("large"), strings.NewReader(large)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	checkRead(t, db, fsdb.Key("a"), "aaaa")
+	checkRead(t, db, fsdb.Key("b"), "bbbb")
+	checkStats(t, db, cache.Stats{Misses: 2, Entries: 2, Size: 8})
+	// Make a the most recently used.
+	checkRead(t, db, fsdb.Key("a"), "aaaa")
+	// Evicts b.
+	checkRead(t, db, fsdb.Key("c"), "cccc")
+	checkStats(t, db, cache.Stats{Hits: 1, Misses: 3, Evictions: 1, Entries: 2, Size: 8})
+	checkRead(t, db, fsdb.Key("a"), "aaaa")
+	checkStats(t, db, cache.Stats{Hits: 2, Misses: 3, Evictions: 1, Entries: 2, Size: 8})
+
+	// Large entries are not cached.
+	checkRead(t, db, fsdb.Key("large"), large)
+	checkRead(t, db, fsdb.Key("large"), large)
+	checkStats(t, db, cache.Stats{Hits: 2, Misses: 5, Evictions: 1, Entries: 2, Size: 8})
+}
+
+func TestStaleFill(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	var block int32
+	underlying := memory.Open(memory.NewDefaultOptions().SetHook(
+		func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+			if op == memory.OpRead && atomic.CompareAndSwapInt32(&block, 1, 0) {
+				close(started)
+				<-resume
+			}
+			return nil
+		},
+	))
+	db := cache.Open(underlying, cache.NewDefaultOptions())
+	if err := db.Write(ctx, key, strings.NewReader("old")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	atomic.StoreInt32(&block, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// The hook runs before the actual read,
+		// so this read gets the new data but must not cache it.
+		reader, err := db.Read(ctx, key)
+		if err != nil {
+			t.Errorf("Read failed: %v", err)
+			return
+		}
+		reader.Close()
+	}()
+	<-started
+	if err := db.Write(ctx, key, strings.NewReader("new")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	close(resume)
+	<-done
+
+	checkStats(t, db, cache.Stats{Misses: 1})
+	checkRead(t, db, key, "new")
+	checkStats(t, db, cache.Stats{Misses: 2, Entries: 1, Size: 3})
+}
+
+func TestVersioned(t *testing.T) {
+	ctx := context.Background()
+	underlying := memory.Open(memory.NewDefaultOptions())
+	db := cache.Open(underlying, cache.NewDefaultOptions().SetMaxEntrySize(3))
+
+	for _, content := range []string{"foo", "foobar"} {
+		key := fsdb.Key(content)
+		if err := db.Write(ctx, key, strings.NewReader(content)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		info, err := underlying.(fsdb.Stater).Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		// The first read is a miss, and the second one is a hit if it fits.
+		for i := 0; i < 2; i++ {
+			reader, err := db.Read(ctx, key)
+			if err != nil {
+				t.Fatalf("Read %v failed: %v", key, err)
+			}
+			v, ok := reader.(fsdb.Versioned)
+			if !ok {
+				t.Errorf("Read %v #%d expected fsdb.Versioned, got %T", key, i, reader)
+			} else if v.Generation() != info.Generation {
+				t.Errorf(
+					"Read %v #%d expected generation %d, got %d",
+					key,
+					i,
+					info.Generation,
+					v.Generation(),
+				)
+			}
+			reader.Close()
+		}
+	}
+	checkStats(t, db, cache.Stats{Hits: 1, Misses: 3, Entries: 1, Size: 3})
+}
+
+func checkRead(t *testing.T, db fsdb.FSDB, key fsdb.Key, expected string) {
+	t.Helper()
+
+	reader, err := db.Read(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Read %v failed: %v", key, err)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read %v content failed: %v", key, err)
+	}
+	if !bytes.Equal(actual, []byte(expected)) {
+		t.Errorf("Read %v expected %q, got %q", key, expected, actual)
+	}
+}
+
+func checkStats(t *testing.T, db cache.FSDB, expected cache.Stats) {
+	t.Helper()
+
+	if actual := db.Stats(); actual != expected {
+		t.Errorf("Stats expected %+v, got %+v", expected, actual)
+	}
+}
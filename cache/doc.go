@@ -0,0 +1,16 @@
+// Package cache provides a decorator for any FSDB implementation that caches
+// the data of hot keys in memory.
+//
+// The cache is an LRU bounded by the total size of the data cached,
+// and entries larger than the maximum entry size are never cached.
+// Write and Delete through the cache FSDB invalidate the cached entry of the
+// key, and Stats reports the hit/miss counters for monitoring.
+// The generation reported by the underlying FSDB (see fsdb.Versioned) is
+// cached along with the data.
+//
+// The cache only lives within the process,
+// so changes made by other processes,
+// or made to the underlying FSDB directly,
+// could be invisible until the cached entry is evicted.
+// Don't use it when that's unacceptable.
+package cache
@@ -0,0 +1,72 @@
+package cache
+
+// Default options values.
+const (
+	DefaultMaxSize      int64 = 64 * 1024 * 1024
+	DefaultMaxEntrySize int64 = 1024 * 1024
+)
+
+// Options defines a read-only view of options used in cache FSDB.
+type Options interface {
+	// GetMaxSize returns the maximum total size of the data cached in bytes.
+	GetMaxSize() int64
+
+	// GetMaxEntrySize returns the maximum size of a single entry to be cached
+	// in bytes.
+	//
+	// Larger entries are always read from the underlying FSDB.
+	GetMaxEntrySize() int64
+}
+
+// OptionsBuilder defines a read write view of options used in cache FSDB.
+type OptionsBuilder interface {
+	Options
+
+	// Build builds the read-only view of the options.
+	Build() Options
+
+	// SetMaxSize sets the maximum total size of the data cached in bytes.
+	SetMaxSize(size int64) OptionsBuilder
+
+	// SetMaxEntrySize sets the maximum size of a single entry to be cached in
+	// bytes.
+	SetMaxEntrySize(size int64) OptionsBuilder
+}
+
+type options struct {
+	maxSize      int64
+	maxEntrySize int64
+}
+
+// NewDefaultOptions creates the default options.
+func NewDefaultOptions() OptionsBuilder {
+	return &options{
+		maxSize:      DefaultMaxSize,
+		maxEntrySize: DefaultMaxEntrySize,
+	}
+}
+
+func (opt *options) GetMaxSize() int64 {
+	return opt.maxSize
+}
+
+func (opt *options) GetMaxEntrySize() int64 {
+	if opt.maxEntrySize > opt.maxSize {
+		return opt.maxSize
+	}
+	return opt.maxEntrySize
+}
+
+func (opt *options) Build() Options {
+	return opt
+}
+
+func (opt *options) SetMaxSize(size int64) OptionsBuilder {
+	opt.maxSize = size
+	return opt
+}
+
+func (opt *options) SetMaxEntrySize(size int64) OptionsBuilder {
+	opt.maxEntrySize = size
+	return opt
+}
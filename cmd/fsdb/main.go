@@ -0,0 +1,204 @@
+// Command fsdb provides maintenance tools for local fsdb stores.
+//
+// Usage:
+//     fsdb <command> [flags]
+//
+// Run
+//     fsdb <command> -help
+// for the flags of a command.
+//
+// The layout of an existing local fsdb (e.g. hash function and directory
+// levels) is read from its manifest, see local.LoadOptions.
+// The layout flags only need to be set for local fsdb stores without a
+// manifest (written by older versions),
+// and the other local options not available as flags are the defaults from
+// local.NewDefaultOptions.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+
+	"github.com/fishy/fsdb/local"
+)
+
+// command is a subcommand of fsdb.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{
+		name:  "scrub",
+		usage: "verify all the entries and report the corrupt ones",
+		run:   scrub,
+	},
+	{
+		name:  "recompress",
+		usage: "rewrite all the entries with a compression codec",
+		run:   recompress,
+	},
+}
+
+// errFound is returned by commands that finished but found problems,
+// to exit with non-zero code without printing the error again.
+var errFound = errors.New("problems found")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, flag.Args()[1:]); err != nil {
+			if err != errFound {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s <command> [flags]\n\n", os.Args[0])
+	fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// localFlags are the flags shared by all the commands to open a local fsdb.
+type localFlags struct {
+	fs *flag.FlagSet
+
+	root     string
+	dataDir  string
+	tempDir  string
+	dirLevel int
+	keysFile string
+}
+
+func (f *localFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.root, "root", "", "root directory of the local fsdb (required)")
+	fs.StringVar(
+		&f.dataDir,
+		"data-dir",
+		local.DefaultDataDir,
+		"data directory within root (from the manifest if exists)",
+	)
+	fs.StringVar(
+		&f.tempDir,
+		"temp-dir",
+		local.DefaultTempDir,
+		"temporary directory within root (from the manifest if exists)",
+	)
+	fs.IntVar(
+		&f.dirLevel,
+		"dir-level",
+		local.DefaultDirLevel,
+		"directory levels (from the manifest if exists)",
+	)
+	fs.StringVar(
+		&f.keysFile,
+		"keys-file",
+		"",
+		`JSON file of the encryption keys: {"current": "id", "keys": {"id": "hex key"}}`,
+	)
+}
+
+// options returns the local options from the manifest under root,
+// with the flags explicitly set applied on top.
+//
+// If there's no manifest, all the flags are applied on top of the defaults.
+func (f *localFlags) options() (local.OptionsBuilder, error) {
+	if f.root == "" {
+		return nil, errors.New("-root is required")
+	}
+	set := make(map[string]bool)
+	opts, err := local.LoadOptions(f.root)
+	switch err {
+	default:
+		return nil, err
+	case nil:
+		f.fs.Visit(func(fl *flag.Flag) {
+			set[fl.Name] = true
+		})
+	case local.ErrNoManifest:
+		opts = local.NewDefaultOptions(f.root)
+		f.fs.VisitAll(func(fl *flag.Flag) {
+			set[fl.Name] = true
+		})
+	}
+	if set["data-dir"] {
+		opts.SetDataDir(f.dataDir)
+	}
+	if set["temp-dir"] {
+		opts.SetTempDir(f.tempDir)
+	}
+	if set["dir-level"] {
+		opts.SetDirLevel(f.dirLevel)
+	}
+	if f.keysFile != "" {
+		keys, err := readKeysFile(f.keysFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetKeyProvider(keys)
+	}
+	return opts, nil
+}
+
+// readKeysFile reads the encryption keys from a JSON file.
+func readKeysFile(path string) (*local.StaticKeys, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("bad keys file %q: %w", path, err)
+	}
+	keys := &local.StaticKeys{
+		Current: file.Current,
+		Keys:    make(map[string][]byte, len(file.Keys)),
+	}
+	for id, key := range file.Keys {
+		if keys.Keys[id], err = hex.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("bad key %q in keys file %q: %w", id, path, err)
+		}
+	}
+	return keys, nil
+}
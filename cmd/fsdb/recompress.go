@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/flate"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func recompress(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("recompress", flag.ExitOnError)
+	var lf localFlags
+	lf.register(fs)
+	codecName := fs.String(
+		"codec",
+		fsdb.CodecGzip,
+		`codec to rewrite the entries into, or "plain" for no compression`,
+	)
+	level := fs.Int(
+		"level",
+		flate.DefaultCompression,
+		"compression level of gzip, zlib and flate codecs",
+	)
+	adaptive := fs.Bool(
+		"adaptive",
+		local.DefaultAdaptiveCompression,
+		"store the entries that don't compress well uncompressed",
+	)
+	minRatio := fs.Float64(
+		"min-ratio",
+		local.DefaultMinCompressionRatio,
+		"minimal compression ratio with -adaptive",
+	)
+	sampleSize := fs.Int(
+		"sample-size",
+		local.DefaultCompressionSampleSize,
+		"size of the sample to decide the compression ratio with -adaptive",
+	)
+	all := fs.Bool(
+		"all",
+		false,
+		"also rewrite the entries already stored with the codec, e.g. for a new -level",
+	)
+	rate := fs.Int64("rate", 0, "max bytes per second to read, 0 means unlimited")
+	verbose := fs.Bool("v", false, "print every entry rewritten")
+	fs.Parse(args)
+
+	opts, err := lf.options()
+	if err != nil {
+		return err
+	}
+	codec, err := newCodec(*codecName, *level)
+	if err != nil {
+		return err
+	}
+	opts.SetCodec(codec).
+		SetAdaptiveCompression(*adaptive).
+		SetMinCompressionRatio(*minRatio).
+		SetCompressionSampleSize(*sampleSize)
+	db := local.Open(opts)
+	stats, err := local.Recompress(ctx, db, local.RecompressOptions{
+		BytesPerSecond: *rate,
+		All:            *all,
+		KeyFunc: func(r local.Recompression) bool {
+			if *verbose {
+				fmt.Printf("rewritten: %q: %d -> %d bytes\n", r.Key, r.OldSize, r.NewSize)
+			}
+			return true
+		},
+	})
+	fmt.Fprintf(
+		os.Stderr,
+		"checked %d entries, rewritten %d (%d -> %d bytes, %d bytes saved), skipped %d\n",
+		stats.Entries,
+		stats.Rewritten,
+		stats.OldBytes,
+		stats.NewBytes,
+		stats.SavedBytes(),
+		stats.Skipped,
+	)
+	return err
+}
+
+// newCodec returns the codec with the given name.
+//
+// level is only used by the built-in codecs.
+func newCodec(name string, level int) (local.Codec, error) {
+	switch name {
+	case fsdb.CodecPlain:
+		return nil, nil
+	case fsdb.CodecGzip:
+		return local.NewGzipCodec(level), nil
+	case fsdb.CodecZlib:
+		return local.NewZlibCodec(level), nil
+	case fsdb.CodecFlate:
+		return local.NewFlateCodec(level), nil
+	}
+	if codec := local.LookupCodec(name); codec != nil {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fishy/fsdb/local"
+)
+
+func scrub(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	var lf localFlags
+	lf.register(fs)
+	rate := fs.Int64("rate", 0, "max bytes per second to read, 0 means unlimited")
+	fs.Parse(args)
+
+	opts, err := lf.options()
+	if err != nil {
+		return err
+	}
+	db := local.Open(opts)
+	stats, err := local.Scrub(ctx, db, local.ScrubOptions{
+		BytesPerSecond: *rate,
+		CorruptFunc: func(c local.Corruption) bool {
+			fmt.Printf("corrupt: %q: %v\n", c.Key, c.Err)
+			return true
+		},
+	})
+	fmt.Fprintf(
+		os.Stderr,
+		"scrubbed %d entries (%d bytes), %d corrupt\n",
+		stats.Entries,
+		stats.Bytes,
+		stats.Corrupt,
+	)
+	if err != nil {
+		return err
+	}
+	if stats.Corrupt > 0 {
+		return errFound
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package fsdb
+
+import (
+	"context"
+	"io"
+)
+
+// ConditionalWriter defines an optional interface for FSDB implementations
+// supporting optimistic concurrency control.
+//
+// Every entry carries a generation, which changes every time the entry is
+// written.
+// The current generation can be get from Stat (if Stater is also implemented),
+// or from the reader returned by Read (if it implements Versioned).
+type ConditionalWriter interface {
+	// WriteIf writes an entry only if its current generation matches
+	// generation.
+	//
+	// If the key does not exist or the generation does not match,
+	// it should return a PreconditionFailedError.
+	WriteIf(ctx context.Context, key Key, data io.Reader, generation int64) error
+
+	// WriteIfNotExists writes an entry only if the key does not exist.
+	//
+	// If the key already exists, it should return a PreconditionFailedError.
+	WriteIfNotExists(ctx context.Context, key Key, data io.Reader) error
+}
+
+// Versioned is implemented by the readers returned by Read of implementations
+// supporting ConditionalWriter.
+type Versioned interface {
+	// Generation returns the generation of the entry being read.
+	Generation() int64
+}
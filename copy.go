@@ -0,0 +1,52 @@
+package fsdb
+
+import (
+	"context"
+	"time"
+)
+
+// CopyEntry copies the entry of key from src to dst.
+//
+// If dst implements OptionsWriter,
+// the metadata (if src implements MetadataFSDB) and the expiration
+// (if src implements Stater) are copied along with the data.
+//
+// If the key does not exist or is expired on src,
+// it returns a NoSuchKeyError.
+func CopyEntry(ctx context.Context, src, dst FSDB, key Key) error {
+	var opts WriteOptions
+	ow, ok := dst.(OptionsWriter)
+	if ok {
+		if mdb, ok := src.(MetadataFSDB); ok {
+			metadata, err := mdb.ReadMetadata(ctx, key)
+			if err != nil {
+				return err
+			}
+			opts.Metadata = metadata
+		}
+		if stater, ok := src.(Stater); ok {
+			info, err := stater.Stat(ctx, key)
+			if err != nil {
+				return err
+			}
+			if info.Expires.IsZero() {
+				opts.TTL = -1
+			} else {
+				opts.TTL = time.Until(info.Expires)
+				if opts.TTL <= 0 {
+					return &NoSuchKeyError{Key: key}
+				}
+			}
+		}
+	}
+
+	reader, err := src.Read(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if ok {
+		return ow.WriteWithOptions(ctx, key, reader, opts)
+	}
+	return dst.Write(ctx, key, reader)
+}
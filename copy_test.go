@@ -0,0 +1,65 @@
+package fsdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/memory"
+)
+
+func TestCopyEntry(t *testing.T) {
+	ctx := context.Background()
+	src := memory.Open(memory.NewDefaultOptions())
+	dst := memory.Open(memory.NewDefaultOptions())
+	key := fsdb.Key("foo")
+
+	if err := fsdb.CopyEntry(ctx, src, dst, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("CopyEntry on non-exist key expected NoSuchKeyError, got %v", err)
+	}
+
+	if err := src.(fsdb.OptionsWriter).WriteWithOptions(
+		ctx,
+		key,
+		strings.NewReader("bar"),
+		fsdb.WriteOptions{
+			Metadata: fsdb.Metadata{"foo": "bar"},
+			TTL:      time.Hour,
+		},
+	); err != nil {
+		t.Fatalf("WriteWithOptions failed: %v", err)
+	}
+	if err := fsdb.CopyEntry(ctx, src, dst, key); err != nil {
+		t.Fatalf("CopyEntry failed: %v", err)
+	}
+
+	reader, err := dst.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read content failed: %v", err)
+	}
+	if string(data) != "bar" {
+		t.Errorf("Read expected %q, got %q", "bar", data)
+	}
+	metadata, err := dst.(fsdb.MetadataFSDB).ReadMetadata(ctx, key)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if metadata["foo"] != "bar" {
+		t.Errorf("Metadata expected to be copied, got %v", metadata)
+	}
+	info, err := dst.(fsdb.Stater).Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Expires.IsZero() || time.Until(info.Expires) > time.Hour {
+		t.Errorf("Expiration expected to be copied, got %v", info.Expires)
+	}
+}
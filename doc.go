@@ -4,4 +4,12 @@
 // The interface FSDB defines basic Read, Write and Delete functions.
 //
 // The interface Local defines extra functions for local implementations.
+//
+// There are also optional interfaces (e.g. Stater) that implementations could
+// choose to implement.
+// Use type assertions to check whether an implementation supports them.
+//
+// Errors returned by the implementations could be wrapped.
+// Use errors.Is with the sentinel errors (e.g. ErrNotFound),
+// or the Is*Error helpers to check them.
 package fsdb
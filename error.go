@@ -1,12 +1,39 @@
 package fsdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 )
 
 // Make sure *NoSuchKeyError satisfies error interface.
 var _ error = (*NoSuchKeyError)(nil)
 
+// Make sure *PreconditionFailedError satisfies error interface.
+var _ error = (*PreconditionFailedError)(nil)
+
+// Make sure *CorruptEntryError satisfies error interface.
+var _ error = (*CorruptEntryError)(nil)
+
+// Make sure *MultiError satisfies error interface.
+var _ error = (*MultiError)(nil)
+
+// ErrNotSupported is the error returned when an optional operation is not
+// supported by the underlying implementation.
+var ErrNotSupported = errors.New("fsdb: operation not supported")
+
+// Sentinel errors to be used with errors.Is.
+//
+// The typed errors in this package and the implementations match them,
+// e.g. errors.Is(err, ErrNotFound) is true for a (wrapped) NoSuchKeyError.
+var (
+	ErrNotFound           = errors.New("fsdb: not found")
+	ErrKeyCollision       = errors.New("fsdb: key collision")
+	ErrCorruptEntry       = errors.New("fsdb: corrupt entry")
+	ErrPreconditionFailed = errors.New("fsdb: precondition failed")
+)
+
 // NoSuchKeyError is an error returned by Read and Delete functions when the key
 // requested does not exists.
 type NoSuchKeyError struct {
@@ -17,8 +44,150 @@ func (err *NoSuchKeyError) Error() string {
 	return fmt.Sprintf("no such key: %q", err.Key)
 }
 
-// IsNoSuchKeyError checks whether a given error is NoSuchKeyError.
+// Is makes errors.Is(err, ErrNotFound) work.
+func (err *NoSuchKeyError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// IsNoSuchKeyError checks whether a given error is NoSuchKeyError,
+// or wraps one.
 func IsNoSuchKeyError(err error) bool {
-	_, ok := err.(*NoSuchKeyError)
-	return ok
+	var target *NoSuchKeyError
+	return errors.As(err, &target)
+}
+
+// PreconditionFailedError is an error returned by conditional writes when the
+// condition is not met.
+type PreconditionFailedError struct {
+	Key Key
+
+	// Generation is the current generation of the entry,
+	// or 0 if the key does not exist.
+	Generation int64
+}
+
+func (err *PreconditionFailedError) Error() string {
+	return fmt.Sprintf(
+		"precondition failed on key %q: current generation is %d",
+		err.Key,
+		err.Generation,
+	)
+}
+
+// Is makes errors.Is(err, ErrPreconditionFailed) work.
+func (err *PreconditionFailedError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+// IsPreconditionFailedError checks whether a given error is
+// PreconditionFailedError, or wraps one.
+func IsPreconditionFailedError(err error) bool {
+	var target *PreconditionFailedError
+	return errors.As(err, &target)
+}
+
+// CorruptEntryError is an error returned when an entry exists but can't be
+// read, e.g. its data file is missing or has a bad header.
+type CorruptEntryError struct {
+	Key Key
+
+	// Reason is a short description of the corruption.
+	Reason string
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (err *CorruptEntryError) Error() string {
+	if err.Err == nil {
+		return fmt.Sprintf("corrupt entry %q: %s", err.Key, err.Reason)
+	}
+	return fmt.Sprintf("corrupt entry %q: %s: %v", err.Key, err.Reason, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *CorruptEntryError) Unwrap() error {
+	return err.Err
+}
+
+// Is makes errors.Is(err, ErrCorruptEntry) work.
+func (err *CorruptEntryError) Is(target error) bool {
+	return target == ErrCorruptEntry
+}
+
+// IsCorruptEntryError checks whether a given error is CorruptEntryError,
+// or wraps one.
+func IsCorruptEntryError(err error) bool {
+	var target *CorruptEntryError
+	return errors.As(err, &target)
+}
+
+// IsCanceledError checks whether a given error is caused by the cancellation
+// or deadline of the context.
+//
+// Operations canceled by the context return ctx.Err(),
+// possibly wrapped.
+func IsCanceledError(err error) bool {
+	return errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// MultiError combines multiple errors into one.
+//
+// errors.Is and errors.As on it match any of the errors combined.
+type MultiError struct {
+	Errors []error
+}
+
+// CombineErrors combines the non-nil errors.
+//
+// It returns nil if all of them are nil,
+// the only error if there's only one non-nil error,
+// or a *MultiError otherwise.
+func CombineErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	}
+	return &MultiError{Errors: nonNil}
+}
+
+func (err *MultiError) Error() string {
+	strs := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		strs[i] = e.Error()
+	}
+	return fmt.Sprintf(
+		"%d errors combined: %s",
+		len(err.Errors),
+		strings.Join(strs, "; "),
+	)
+}
+
+// Is returns true if any of the errors combined matches target.
+func (err *MultiError) Is(target error) bool {
+	for _, e := range err.Errors {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error combined that matches target.
+func (err *MultiError) As(target interface{}) bool {
+	for _, e := range err.Errors {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
 }
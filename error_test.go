@@ -1,7 +1,9 @@
 package fsdb_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/fishy/fsdb"
@@ -37,3 +39,108 @@ func TestTypeCheck(t *testing.T) {
 		t.Errorf("nil should not be an instance of NoSuchKeyError")
 	}
 }
+
+func TestPreconditionFailedError(t *testing.T) {
+	var err error = &fsdb.PreconditionFailedError{
+		Key:        fsdb.Key("foobar"),
+		Generation: 42,
+	}
+	expect := "precondition failed on key \"foobar\": current generation is 42"
+	actual := err.Error()
+	if expect != actual {
+		t.Errorf("(%q).Error() expected %q, got %q", err, expect, actual)
+	}
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("%q should be an instance of PreconditionFailedError", err)
+	}
+	if fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("%q should not be an instance of NoSuchKeyError", err)
+	}
+}
+
+func TestErrorsIsAs(t *testing.T) {
+	key := fsdb.Key("foobar")
+	notFound := fmt.Errorf("wrapped: %w", &fsdb.NoSuchKeyError{Key: key})
+	if !errors.Is(notFound, fsdb.ErrNotFound) {
+		t.Errorf("%q should match ErrNotFound", notFound)
+	}
+	if !fsdb.IsNoSuchKeyError(notFound) {
+		t.Errorf("%q should be an instance of NoSuchKeyError", notFound)
+	}
+	var nske *fsdb.NoSuchKeyError
+	if !errors.As(notFound, &nske) || !nske.Key.Equals(key) {
+		t.Errorf("errors.As on %q failed, got %v", notFound, nske)
+	}
+
+	precondition := fmt.Errorf("wrapped: %w", &fsdb.PreconditionFailedError{
+		Key: key,
+	})
+	if !errors.Is(precondition, fsdb.ErrPreconditionFailed) {
+		t.Errorf("%q should match ErrPreconditionFailed", precondition)
+	}
+	if !fsdb.IsPreconditionFailedError(precondition) {
+		t.Errorf("%q should be an instance of PreconditionFailedError", precondition)
+	}
+
+	cause := errors.New("bad header")
+	corrupt := fmt.Errorf("wrapped: %w", &fsdb.CorruptEntryError{
+		Key:    key,
+		Reason: "foo",
+		Err:    cause,
+	})
+	if !errors.Is(corrupt, fsdb.ErrCorruptEntry) {
+		t.Errorf("%q should match ErrCorruptEntry", corrupt)
+	}
+	if !errors.Is(corrupt, cause) {
+		t.Errorf("%q should match its cause", corrupt)
+	}
+	if !fsdb.IsCorruptEntryError(corrupt) {
+		t.Errorf("%q should be an instance of CorruptEntryError", corrupt)
+	}
+	if errors.Is(corrupt, fsdb.ErrNotFound) {
+		t.Errorf("%q should not match ErrNotFound", corrupt)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := fmt.Errorf("wrapped: %w", ctx.Err())
+	if !fsdb.IsCanceledError(canceled) {
+		t.Errorf("%q should be a canceled error", canceled)
+	}
+	if fsdb.IsCanceledError(notFound) {
+		t.Errorf("%q should not be a canceled error", notFound)
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	if err := fsdb.CombineErrors(nil, nil); err != nil {
+		t.Errorf("CombineErrors(nil, nil) expected nil, got %v", err)
+	}
+
+	single := errors.New("foo")
+	if err := fsdb.CombineErrors(nil, single); err != single {
+		t.Errorf("CombineErrors(nil, %v) expected %v, got %v", single, single, err)
+	}
+
+	key := fsdb.Key("foobar")
+	err := fsdb.CombineErrors(single, &fsdb.NoSuchKeyError{Key: key})
+	if _, ok := err.(*fsdb.MultiError); !ok {
+		t.Fatalf("Expected *MultiError, got %T", err)
+	}
+	expect := `2 errors combined: foo; no such key: "foobar"`
+	if err.Error() != expect {
+		t.Errorf("(%q).Error() expected %q, got %q", err, expect, err.Error())
+	}
+	if !errors.Is(err, single) {
+		t.Errorf("%q should match %q", err, single)
+	}
+	if !errors.Is(err, fsdb.ErrNotFound) {
+		t.Errorf("%q should match ErrNotFound", err)
+	}
+	if !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("%q should contain a NoSuchKeyError", err)
+	}
+	if errors.Is(err, fsdb.ErrCorruptEntry) {
+		t.Errorf("%q should not match ErrCorruptEntry", err)
+	}
+}
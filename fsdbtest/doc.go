@@ -0,0 +1,20 @@
+// Package fsdbtest provides conformance tests for fsdb.FSDB and fsdb.Local
+// implementations.
+//
+// Call TestFSDB or TestLocal from a test in the package of your
+// implementation, with a factory creating a new and empty FSDB for every
+// subtest:
+//
+//	func TestConformance(t *testing.T) {
+//	    fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+//	        root, err := ioutil.TempDir("", "fsdb_")
+//	        if err != nil {
+//	            t.Fatalf("failed to get tmp dir: %v", err)
+//	        }
+//	        t.Cleanup(func() {
+//	            os.RemoveAll(root)
+//	        })
+//	        return local.Open(local.NewDefaultOptions(root))
+//	    })
+//	}
+package fsdbtest
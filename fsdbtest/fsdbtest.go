@@ -0,0 +1,335 @@
+package fsdbtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fishy/fsdb"
+)
+
+// Factory creates a new and empty FSDB for a single subtest.
+//
+// Use t.Cleanup to release the resources used by the FSDB.
+type Factory func(t *testing.T) fsdb.FSDB
+
+// LocalFactory creates a new and empty local FSDB for a single subtest.
+//
+// Use t.Cleanup to release the resources used by the FSDB.
+type LocalFactory func(t *testing.T) fsdb.Local
+
+// TestFSDB runs the conformance tests of fsdb.FSDB interface.
+func TestFSDB(t *testing.T, factory Factory) {
+	for _, c := range []struct {
+		name string
+		test func(t *testing.T, db fsdb.FSDB)
+	}{
+		{"NotExist", testNotExist},
+		{"ReadWriteDelete", testReadWriteDelete},
+		{"Overwrite", testOverwrite},
+		{"Empty", testEmpty},
+		{"BinaryKey", testBinaryKey},
+		{"LargeData", testLargeData},
+		{"Concurrent", testConcurrent},
+		{"Canceled", testCanceled},
+	} {
+		test := c.test
+		t.Run(c.name, func(t *testing.T) {
+			test(t, factory(t))
+		})
+	}
+}
+
+// TestLocal runs the conformance tests of fsdb.Local interface,
+// which includes all the tests of TestFSDB.
+func TestLocal(t *testing.T, factory LocalFactory) {
+	TestFSDB(t, func(t *testing.T) fsdb.FSDB {
+		return factory(t)
+	})
+
+	for _, c := range []struct {
+		name string
+		test func(t *testing.T, db fsdb.Local)
+	}{
+		{"ScanKeys", testScanKeys},
+		{"ScanKeysAbort", testScanKeysAbort},
+		{"ScanKeysCanceled", testScanKeysCanceled},
+	} {
+		test := c.test
+		t.Run(c.name, func(t *testing.T) {
+			test(t, factory(t))
+		})
+	}
+}
+
+func testNotExist(t *testing.T, db fsdb.FSDB) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+
+	reader, err := db.Read(ctx, key)
+	if !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read on non-exist key expected NoSuchKeyError, got %v", err)
+	}
+	if reader != nil {
+		reader.Close()
+		t.Error("Read on non-exist key returned non-nil reader")
+	}
+
+	if err := db.Delete(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Delete on non-exist key expected NoSuchKeyError, got %v", err)
+	}
+}
+
+func testReadWriteDelete(t *testing.T, db fsdb.FSDB) {
+	key := fsdb.Key("foo")
+	content := "Hello, world!"
+
+	write(t, db, key, []byte(content))
+	read(t, db, key, []byte(content))
+	// Read again
+	read(t, db, key, []byte(content))
+	remove(t, db, key)
+	readNotExist(t, db, key)
+}
+
+func testOverwrite(t *testing.T, db fsdb.FSDB) {
+	key := fsdb.Key("foo")
+	other := fsdb.Key("bar")
+
+	write(t, db, key, []byte("long content to be overwritten"))
+	write(t, db, other, []byte("other"))
+	write(t, db, key, []byte("short"))
+	read(t, db, key, []byte("short"))
+	read(t, db, other, []byte("other"))
+	write(t, db, key, []byte("longer content after overwritten"))
+	read(t, db, key, []byte("longer content after overwritten"))
+}
+
+func testEmpty(t *testing.T, db fsdb.FSDB) {
+	key := fsdb.Key("foo")
+
+	write(t, db, key, nil)
+	read(t, db, key, nil)
+
+	// Empty key
+	write(t, db, fsdb.Key{}, []byte("foo"))
+	read(t, db, fsdb.Key{}, []byte("foo"))
+	remove(t, db, fsdb.Key{})
+}
+
+func testBinaryKey(t *testing.T, db fsdb.FSDB) {
+	keys := []fsdb.Key{
+		fsdb.Key{0, 1, 2, 0xff, 0xfe},
+		fsdb.Key("foo/bar/../baz"),
+		fsdb.Key("☺"),
+		fsdb.Key(strings.Repeat("long", 1024)),
+	}
+	for i, key := range keys {
+		write(t, db, key, []byte(fmt.Sprintf("%d", i)))
+	}
+	for i, key := range keys {
+		read(t, db, key, []byte(fmt.Sprintf("%d", i)))
+	}
+}
+
+func testLargeData(t *testing.T, db fsdb.FSDB) {
+	key := fsdb.Key("foo")
+	data := make([]byte, 1024*1024+7)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	write(t, db, key, data)
+	read(t, db, key, data)
+}
+
+func testConcurrent(t *testing.T, db fsdb.FSDB) {
+	const n = 16
+	var wg sync.WaitGroup
+	wg.Add(n)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			key := fsdb.Key(fmt.Sprintf("key-%d", i))
+			if err := db.Write(
+				context.Background(),
+				key,
+				strings.NewReader(key.String()),
+			); err != nil {
+				errs <- fmt.Errorf("Write %v failed: %v", key, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		read(t, db, key, key)
+	}
+}
+
+func testCanceled(t *testing.T, db fsdb.FSDB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	key := fsdb.Key("foo")
+
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err == nil {
+		t.Error("Write with canceled context should fail")
+	}
+	readNotExist(t, db, key)
+
+	write(t, db, key, []byte("foo"))
+	reader, err := db.Read(ctx, key)
+	if err == nil {
+		reader.Close()
+		t.Error("Read with canceled context should fail")
+	}
+	if reader != nil && err != nil {
+		t.Error("Read returned both non-nil reader and non-nil error")
+	}
+	if err := db.Delete(ctx, key); err == nil {
+		t.Error("Delete with canceled context should fail")
+	}
+	read(t, db, key, []byte("foo"))
+}
+
+func testScanKeys(t *testing.T, db fsdb.Local) {
+	const n = 50
+	expected := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		write(t, db, key, key)
+		expected[string(key)] = true
+	}
+	deleted := fsdb.Key("key-0")
+	remove(t, db, deleted)
+	delete(expected, string(deleted))
+
+	visited := make(map[string]int)
+	if err := db.ScanKeys(
+		context.Background(),
+		func(key fsdb.Key) bool {
+			visited[string(key)]++
+			return true
+		},
+		fsdb.StopAll,
+	); err != nil {
+		t.Fatalf("ScanKeys failed: %v", err)
+	}
+	for key, count := range visited {
+		if count > 1 {
+			t.Errorf("ScanKeys visited %q %d times", key, count)
+		}
+		if !expected[key] {
+			t.Errorf("ScanKeys visited unexpected key %q", key)
+		}
+	}
+	for key := range expected {
+		if visited[key] == 0 {
+			t.Errorf("ScanKeys did not visit %q", key)
+		}
+	}
+}
+
+func testScanKeysAbort(t *testing.T, db fsdb.Local) {
+	for i := 0; i < 10; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		write(t, db, key, key)
+	}
+
+	count := 0
+	if err := db.ScanKeys(
+		context.Background(),
+		func(key fsdb.Key) bool {
+			count++
+			return false
+		},
+		fsdb.StopAll,
+	); err != nil {
+		t.Errorf("ScanKeys aborted by keyFunc should not fail, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("ScanKeys should stop after keyFunc returned false, called %d times", count)
+	}
+}
+
+func testScanKeysCanceled(t *testing.T, db fsdb.Local) {
+	write(t, db, fsdb.Key("foo"), []byte("foo"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			t.Errorf("ScanKeys with canceled context visited %v", key)
+			return true
+		},
+		fsdb.StopAll,
+	); err == nil {
+		t.Error("ScanKeys with canceled context should fail")
+	}
+}
+
+func write(t *testing.T, db fsdb.FSDB, key fsdb.Key, data []byte) {
+	t.Helper()
+
+	if err := db.Write(context.Background(), key, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Write %v failed: %v", key, err)
+	}
+}
+
+func read(t *testing.T, db fsdb.FSDB, key fsdb.Key, expected []byte) {
+	t.Helper()
+
+	reader, err := db.Read(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Read %v failed: %v", key, err)
+	}
+	if reader == nil {
+		t.Fatalf("Read %v returned both nil reader and nil error", key)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read %v content failed: %v", key, err)
+	}
+	if !bytes.Equal(actual, expected) {
+		if len(expected) > 64 || len(actual) > 64 {
+			t.Errorf(
+				"Read %v expected %d bytes, got %d bytes (or different content)",
+				key,
+				len(expected),
+				len(actual),
+			)
+		} else {
+			t.Errorf("Read %v expected %q, got %q", key, expected, actual)
+		}
+	}
+}
+
+func readNotExist(t *testing.T, db fsdb.FSDB, key fsdb.Key) {
+	t.Helper()
+
+	reader, err := db.Read(context.Background(), key)
+	if !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read %v expected NoSuchKeyError, got %v", key, err)
+	}
+	if reader != nil {
+		reader.Close()
+	}
+}
+
+func remove(t *testing.T, db fsdb.FSDB, key fsdb.Key) {
+	t.Helper()
+
+	if err := db.Delete(context.Background(), key); err != nil {
+		t.Fatalf("Delete %v failed: %v", key, err)
+	}
+}
@@ -1,7 +1,6 @@
 module github.com/fishy/fsdb
 
 require (
-	github.com/fishy/errbatch v0.0.0-20180528213649-54f5e12eed54
 	github.com/fishy/rowlock v0.0.0-20180528220015-119f0ff86f20
 	github.com/fishy/wrapreader v0.0.0-20180728215622-1cf2fb33e2fc
 )
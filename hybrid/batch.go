@@ -0,0 +1,64 @@
+package hybrid
+
+import (
+	"context"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Batcher interface.
+var _ fsdb.Batcher = (*impl)(nil)
+
+// ReadMany opens multiple entries.
+//
+// Entries not available locally are fetched from the remote bucket
+// concurrently.
+func (db *impl) ReadMany(ctx context.Context, keys []fsdb.Key) []fsdb.ReadResult {
+	results := make([]fsdb.ReadResult, len(keys))
+	errs := fsdb.BatchDo(
+		ctx,
+		len(keys),
+		db.opts.GetBatchThreadNum(),
+		func(i int) (err error) {
+			results[i].Reader, err = db.Read(ctx, keys[i])
+			return err
+		},
+	)
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	return results
+}
+
+// WriteMany writes multiple entries locally.
+//
+// If the row lock is not used and the local FSDB implements fsdb.Batcher,
+// its WriteMany will be used.
+func (db *impl) WriteMany(ctx context.Context, entries []fsdb.WriteEntry) []error {
+	if b, ok := db.local.(fsdb.Batcher); ok && !db.opts.GetUseLock() {
+		errs := b.WriteMany(ctx, entries)
+		for i, err := range errs {
+			errs[i] = tierError(TierLocal, err)
+		}
+		return errs
+	}
+	return fsdb.BatchDo(
+		ctx,
+		len(entries),
+		db.opts.GetBatchThreadNum(),
+		func(i int) error {
+			return db.Write(ctx, entries[i].Key, entries[i].Data)
+		},
+	)
+}
+
+func (db *impl) DeleteMany(ctx context.Context, keys []fsdb.Key) []error {
+	return fsdb.BatchDo(
+		ctx,
+		len(keys),
+		db.opts.GetBatchThreadNum(),
+		func(i int) error {
+			return db.Delete(ctx, keys[i])
+		},
+	)
+}
@@ -0,0 +1,114 @@
+package hybrid
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.ConditionalWriter interface.
+var _ fsdb.ConditionalWriter = (*impl)(nil)
+
+// WriteIf writes an entry only if its current generation matches generation.
+//
+// If the entry exists locally, the generation is the one of the local copy.
+// Otherwise it's the one recorded on the remote object when it was uploaded.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.ConditionalWriter, or the UseLock option is off.
+// Without the row lock the upload loop could change the generation between
+// the check and the write.
+func (db *impl) WriteIf(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	generation int64,
+) error {
+	return db.writeIf(ctx, key, data, false, generation)
+}
+
+// WriteIfNotExists writes an entry only if the key exists neither locally nor
+// on the remote bucket.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.ConditionalWriter, or the UseLock option is off.
+func (db *impl) WriteIfNotExists(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+) error {
+	return db.writeIf(ctx, key, data, true, 0)
+}
+
+func (db *impl) writeIf(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	ifNotExists bool,
+	generation int64,
+) error {
+	local, ok := db.local.(fsdb.ConditionalWriter)
+	if !ok || !db.opts.GetUseLock() {
+		return fsdb.ErrNotSupported
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	current, exists, isLocal, err := db.currentGeneration(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exists == ifNotExists || current != generation {
+		return &fsdb.PreconditionFailedError{
+			Key:        key,
+			Generation: current,
+		}
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if isLocal {
+		return local.WriteIf(ctx, key, data, current)
+	}
+	// Let the local FSDB guard against concurrent local writes without lock.
+	return local.WriteIfNotExists(ctx, key, data)
+}
+
+// currentGeneration returns the current generation of an entry,
+// whether it exists, and whether it exists locally.
+func (db *impl) currentGeneration(
+	ctx context.Context,
+	key fsdb.Key,
+) (generation int64, exists bool, isLocal bool, err error) {
+	info, err := db.statLocal(ctx, key)
+	if err == nil {
+		return info.Generation, true, true, nil
+	}
+	if !fsdb.IsNoSuchKeyError(err) {
+		return 0, false, false, tierError(TierLocal, err)
+	}
+
+	remote, err := db.readRemoteInfo(ctx, key)
+	if db.bucket.IsNotExist(err) {
+		return 0, false, false, nil
+	}
+	if err != nil {
+		return 0, false, false, tierError(TierBucket, err)
+	}
+	if db.expireRemote(ctx, key, remote) {
+		return 0, false, false, nil
+	}
+	return remote.Generation, true, false, nil
+}
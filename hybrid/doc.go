@@ -10,6 +10,34 @@
 //
 // Data stored on the remote bucket will be gzipped using best compression
 // level.
+// The generation, the user metadata and the expiration time of the entry
+// (if supported by the local FSDB) are stored in the extra field of the gzip
+// header, so they survive a round trip through the remote bucket.
+//
+// Reap removes expired entries from both local FSDB and remote bucket.
+// It can only find the entries that only exist on the remote bucket if the
+// bucket implements bucket.Lister.
+// Otherwise, expired entries that only exist on the remote bucket are deleted
+// when they are accessed, or you can use the lifecycle rules of your bucket to
+// remove them.
+//
+// Scrubbing
+//
+// A hybrid FSDB implements local.Scrubber when the local FSDB does,
+// so local.Scrub works on it.
+// Corrupt local copies are repaired by downloading the remote copy again,
+// but only when the remote copy matches the checksum stored with the local
+// copy, so that newer local writes not uploaded yet are never rolled back.
+// Local copies with missing data files are always repaired from the remote
+// copy, as there's nothing left locally to roll back.
+// Repaired local copies get a new generation.
+// Corrupt local copies with no matching remote copy are reported as is.
+//
+// Errors
+//
+// Errors from the local FSDB or the remote bucket are wrapped in TierError to
+// tell which tier failed.
+// When both tiers failed in Delete, an *fsdb.MultiError is returned.
 //
 // Concurrency
 //
@@ -39,6 +67,10 @@
 // The lock is only used partially inside the operations
 // (whole local write operation, remote read from Step 3, upload from Step 3).
 //
+// Conditional writes (fsdb.ConditionalWriter) can't be made safe against the
+// upload loop without the row lock,
+// so they return fsdb.ErrNotSupported when it's turned off.
+//
 // There are no other locks used in the code,
 // except a few atomic numbers in upload loop for logging purpose.
 package hybrid
@@ -0,0 +1,64 @@
+package hybrid
+
+import (
+	"fmt"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *TierError satisfies error interface.
+var _ error = (*TierError)(nil)
+
+// Tier is one of the two storage tiers of a hybrid FSDB.
+type Tier int
+
+// Tier values.
+const (
+	TierLocal Tier = iota + 1
+	TierBucket
+)
+
+func (t Tier) String() string {
+	switch t {
+	default:
+		return fmt.Sprintf("Tier(%d)", int(t))
+	case TierLocal:
+		return "local"
+	case TierBucket:
+		return "bucket"
+	}
+}
+
+// TierError is an error returned by hybrid FSDB to tell which tier failed.
+//
+// When both tiers failed in a single operation (e.g. Delete),
+// the returned error is an *fsdb.MultiError containing two TierErrors.
+// Use errors.As to get the TierError,
+// and errors.Is/errors.As to check the underlying error.
+type TierError struct {
+	Tier Tier
+	Err  error
+}
+
+func (err *TierError) Error() string {
+	return fmt.Sprintf("hybrid %v: %v", err.Tier, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *TierError) Unwrap() error {
+	return err.Err
+}
+
+// tierError wraps err into a TierError.
+//
+// It returns err as-is if it's nil or a NoSuchKeyError,
+// as the key not existing on a tier is not a failure of that tier.
+func tierError(tier Tier, err error) error {
+	if err == nil || fsdb.IsNoSuchKeyError(err) {
+		return err
+	}
+	return &TierError{
+		Tier: tier,
+		Err:  err,
+	}
+}
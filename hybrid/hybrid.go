@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
@@ -11,7 +13,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/fishy/errbatch"
 	"github.com/fishy/rowlock"
 
 	"github.com/fishy/fsdb"
@@ -67,23 +68,41 @@ func Open(
 }
 
 func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	return db.readThrough(ctx, key, func() (io.ReadCloser, error) {
+		return db.local.Read(ctx, key)
+	})
+}
+
+// readThrough calls read to read from the local FSDB.
+//
+// If the key does not exist locally,
+// it reads from the remote bucket and saves the data locally,
+// then calls read again.
+func (db *impl) readThrough(
+	ctx context.Context,
+	key fsdb.Key,
+	read func() (io.ReadCloser, error),
+) (io.ReadCloser, error) {
 	select {
 	default:
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 
-	data, err := db.local.Read(ctx, key)
+	data, err := read()
 	if err == nil {
 		return data, nil
 	}
 	if !fsdb.IsNoSuchKeyError(err) {
-		return nil, err
+		return nil, tierError(TierLocal, err)
 	}
-	remoteData, err := db.readBucket(ctx, key)
+	remoteData, info, err := db.readBucket(ctx, key)
 	if !db.bucket.IsNotExist(err) {
 		if err != nil {
-			return nil, err
+			return nil, tierError(TierBucket, err)
+		}
+		if db.expireRemote(ctx, key, info) {
+			return nil, &fsdb.NoSuchKeyError{Key: key}
 		}
 
 		select {
@@ -98,15 +117,19 @@ func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
 		}
 		// Read from local again, so that in case a new write happened during
 		// downloading, we don't overwrite it with stale remote data.
-		data, err = db.local.Read(ctx, key)
+		data, err = read()
 		if err == nil {
 			return data, nil
 		}
-		if err := db.local.Write(ctx, key, remoteData); err != nil {
-			return nil, err
+		if err := db.writeLocal(ctx, key, remoteData, info); err != nil {
+			return nil, tierError(TierLocal, err)
 		}
 	}
-	return db.local.Read(ctx, key)
+	data, err = read()
+	if err != nil {
+		return nil, tierError(TierLocal, err)
+	}
+	return data, nil
 }
 
 func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
@@ -120,7 +143,7 @@ func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
 		db.locks.Lock(string(key))
 		defer db.locks.Unlock(string(key))
 	}
-	return db.local.Write(ctx, key, data)
+	return tierError(TierLocal, db.local.Write(ctx, key, data))
 }
 
 func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
@@ -132,39 +155,39 @@ func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
 
 	existNeither := true
 
-	var ret errbatch.ErrBatch
+	var localErr, bucketErr error
 	err := db.local.Delete(ctx, key)
 	if !fsdb.IsNoSuchKeyError(err) {
 		existNeither = false
-		ret.Add(err)
+		localErr = tierError(TierLocal, err)
 	}
 	err = db.bucket.Delete(ctx, db.opts.GetRemoteName(key))
 	if !db.bucket.IsNotExist(err) {
 		existNeither = false
-		ret.Add(err)
+		bucketErr = tierError(TierBucket, err)
 	}
 
 	if existNeither {
 		return &fsdb.NoSuchKeyError{Key: key}
 	}
-	return ret.Compile()
+	return fsdb.CombineErrors(localErr, bucketErr)
 }
 
 // readBucket reads the key from remote bucket fully.
 func (db *impl) readBucket(
 	ctx context.Context,
 	key fsdb.Key,
-) (io.Reader, error) {
+) (io.Reader, *remoteInfo, error) {
 	select {
 	default:
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, nil, ctx.Err()
 	}
 
 	started := time.Now()
 	data, err := db.bucket.Read(ctx, db.opts.GetRemoteName(key))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer data.Close()
 	if logger := db.opts.GetLogger(); logger != nil {
@@ -178,65 +201,119 @@ func (db *impl) readBucket(
 	select {
 	default:
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, nil, ctx.Err()
 	}
 
 	gzipReader, err := gzip.NewReader(data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer gzipReader.Close()
 
 	select {
 	default:
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, nil, ctx.Err()
 	}
 
 	buf, err := ioutil.ReadAll(gzipReader)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return bytes.NewReader(buf), decodeExtra(gzipReader.Header.Extra), nil
+}
+
+// writeLocal writes the data downloaded from remote bucket locally.
+//
+// The generation recorded on the remote object is kept if the local FSDB
+// implements fsdb.OptionsWriter,
+// so the generation returned by Stat before the download still works with
+// WriteIf after it.
+func (db *impl) writeLocal(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	info *remoteInfo,
+) error {
+	if ow, ok := db.local.(fsdb.OptionsWriter); ok {
+		return ow.WriteWithOptions(ctx, key, data, fsdb.WriteOptions{
+			Metadata:   info.Metadata,
+			TTL:        info.ttl(),
+			Generation: info.Generation,
+		})
+	}
+	if len(info.Metadata) > 0 {
+		if mdb, ok := db.local.(fsdb.MetadataFSDB); ok {
+			return mdb.WriteWithMetadata(ctx, key, data, info.Metadata)
+		}
 	}
-	return bytes.NewReader(buf), nil
+	return db.local.Write(ctx, key, data)
 }
 
 // readAndCRC reads the key from local fully, and calculates crc32c.
+//
+// It also returns the remote info to be uploaded with the data.
 func (db *impl) readAndCRC(
 	ctx context.Context,
 	key fsdb.Key,
-) (uint32, []byte, error) {
+) (uint32, []byte, *remoteInfo, error) {
 	select {
 	default:
 	case <-ctx.Done():
-		return 0, nil, ctx.Err()
+		return 0, nil, nil, ctx.Err()
 	}
 
 	reader, err := db.local.Read(ctx, key)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
 	defer reader.Close()
+	info := newRemoteInfo()
+	if versioned, ok := reader.(fsdb.Versioned); ok {
+		info.Generation = versioned.Generation()
+	}
+	// If metadata or expiration time is from a newer write,
+	// the generation check in uploadKey will make sure we don't delete the local
+	// copy.
+	if mdb, ok := db.local.(fsdb.MetadataFSDB); ok {
+		if info.Metadata, err = mdb.ReadMetadata(ctx, key); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if stater, ok := db.local.(fsdb.Stater); ok {
+		stat, err := stater.Stat(ctx, key)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if !stat.Expires.IsZero() {
+			info.Expires = stat.Expires.UnixNano()
+			info.Key = key
+		}
+	}
 	buf, err := ioutil.ReadAll(reader)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, nil, err
 	}
+	info.Size = int64(len(buf))
+	checksum := sha256.Sum256(buf)
+	info.Checksum = hex.EncodeToString(checksum[:])
 
 	select {
 	default:
 	case <-ctx.Done():
-		return 0, nil, ctx.Err()
+		return 0, nil, nil, ctx.Err()
 	}
 
-	return crc32.Checksum(buf, crc32cTable), buf, nil
+	return crc32.Checksum(buf, crc32cTable), buf, info, nil
 }
 
 // uploadKey uploads a key to remote bucket, and deletes the local copy.
 func (db *impl) uploadKey(ctx context.Context, key fsdb.Key) error {
-	oldCrc, content, err := db.readAndCRC(ctx, key)
+	oldCrc, content, info, err := db.readAndCRC(ctx, key)
 	if err != nil {
 		return err
 	}
-	reader, err := gzipData(bytes.NewReader(content))
+	reader, err := gzipData(bytes.NewReader(content), info)
 	if err != nil {
 		return err
 	}
@@ -262,8 +339,8 @@ func (db *impl) uploadKey(ctx context.Context, key fsdb.Key) error {
 		db.locks.Lock(string(key))
 		defer db.locks.Unlock(string(key))
 	}
-	// check crc again before deleting
-	newCrc, _, err := db.readAndCRC(ctx, key)
+	// check crc and generation again before deleting
+	newCrc, _, newInfo, err := db.readAndCRC(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -274,7 +351,7 @@ func (db *impl) uploadKey(ctx context.Context, key fsdb.Key) error {
 		return ctx.Err()
 	}
 
-	if newCrc == oldCrc {
+	if newCrc == oldCrc && newInfo.Generation == info.Generation {
 		return db.local.Delete(ctx, key)
 	}
 	return nil
@@ -380,12 +457,17 @@ func (db *impl) startScanLoop(ctx context.Context) {
 	}
 }
 
-func gzipData(data io.Reader) (io.Reader, error) {
+func gzipData(data io.Reader, info *remoteInfo) (io.Reader, error) {
+	extra, err := encodeExtra(info)
+	if err != nil {
+		return nil, err
+	}
 	buf := new(bytes.Buffer)
 	writer, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
 	if err != nil {
 		return nil, err
 	}
+	writer.Header.Extra = extra
 	defer writer.Close()
 	if _, err = io.Copy(writer, data); err != nil {
 		return nil, err
@@ -1,16 +1,26 @@
 package hybrid_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fishy/fsdb"
 	"github.com/fishy/fsdb/bucket"
+	"github.com/fishy/fsdb/fsdbtest"
 	"github.com/fishy/fsdb/hybrid"
 	"github.com/fishy/fsdb/local"
 )
@@ -26,6 +36,19 @@ func (db *dbCollection) Open(ctx context.Context) {
 	db.DB = hybrid.Open(ctx, db.Local, db.Remote, db.Opts)
 }
 
+func TestConformance(t *testing.T) {
+	fsdbtest.TestFSDB(t, func(t *testing.T) fsdb.FSDB {
+		root, db := createHybridDB(t, "conformance: ")
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(func() {
+			cancel()
+			os.RemoveAll(root)
+		})
+		db.Open(ctx)
+		return db.DB
+	})
+}
+
 func TestLocal(t *testing.T) {
 	root, db := createHybridDB(t, "local: ")
 	defer os.RemoveAll(root)
@@ -60,6 +83,220 @@ func TestLocal(t *testing.T) {
 	}
 }
 
+func TestOpenWriter(t *testing.T) {
+	root, db := createHybridDB(t, "writer: ")
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	db.Open(ctx)
+	sw := db.DB.(fsdb.StreamWriter)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+
+	writer, err := sw.OpenWriter(ctx, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if _, err := db.DB.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError after Abort, got %v", err)
+	}
+
+	writer, err = sw.OpenWriter(ctx, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	compareContent(t, db.DB, key, content)
+}
+
+func TestBatch(t *testing.T) {
+	root, db := createHybridDB(t, "batch: ")
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	db.Open(ctx)
+	batch := db.DB.(fsdb.Batcher)
+
+	remoteKey := fsdb.Key("remote")
+	localKey := fsdb.Key("local")
+	missingKey := fsdb.Key("missing")
+	content := "bar"
+
+	// Put remoteKey on remote only.
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	io.WriteString(gw, content)
+	gw.Close()
+	if err := db.Remote.Write(
+		ctx,
+		db.Opts.GetRemoteName(remoteKey),
+		buf,
+	); err != nil {
+		t.Fatalf("Remote write failed: %v", err)
+	}
+
+	for i, err := range batch.WriteMany(ctx, []fsdb.WriteEntry{
+		{Key: localKey, Data: strings.NewReader(content)},
+	}) {
+		if err != nil {
+			t.Errorf("WriteMany %d failed: %v", i, err)
+		}
+	}
+
+	keys := []fsdb.Key{remoteKey, localKey, missingKey}
+	for i, result := range batch.ReadMany(ctx, keys) {
+		if keys[i].Equals(missingKey) {
+			if !fsdb.IsNoSuchKeyError(result.Err) {
+				t.Errorf("ReadMany %v expected NoSuchKeyError, got %v", keys[i], result.Err)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("ReadMany %v failed: %v", keys[i], result.Err)
+			continue
+		}
+		buf, err := ioutil.ReadAll(result.Reader)
+		result.Reader.Close()
+		if err != nil {
+			t.Errorf("ReadMany %v read failed: %v", keys[i], err)
+		}
+		if string(buf) != content {
+			t.Errorf("ReadMany %v expected %q, got %q", keys[i], content, buf)
+		}
+	}
+
+	for i, err := range batch.DeleteMany(ctx, keys[:2]) {
+		if err != nil {
+			t.Errorf("DeleteMany %v failed: %v", keys[i], err)
+		}
+	}
+}
+
+func TestTierError(t *testing.T) {
+	root, db := createHybridDB(t, "tier-error: ")
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	bucketErr := errors.New("bucket unavailable")
+	hdb := hybrid.Open(
+		ctx,
+		db.Local,
+		&failingBucket{Mock: db.Remote, err: bucketErr},
+		db.Opts,
+	)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+
+	_, err := hdb.Read(ctx, key)
+	var tierErr *hybrid.TierError
+	if !errors.As(err, &tierErr) || tierErr.Tier != hybrid.TierBucket {
+		t.Errorf("Read expected bucket TierError, got %v", err)
+	}
+	if !errors.Is(err, bucketErr) {
+		t.Errorf("Read expected %v, got %v", bucketErr, err)
+	}
+
+	if err := hdb.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	compareContent(t, hdb, key, content)
+
+	err = hdb.Delete(ctx, key)
+	tierErr = nil
+	if !errors.As(err, &tierErr) || tierErr.Tier != hybrid.TierBucket {
+		t.Errorf("Delete expected bucket TierError, got %v", err)
+	}
+	if !errors.Is(err, bucketErr) {
+		t.Errorf("Delete expected %v, got %v", bucketErr, err)
+	}
+	expect := "hybrid bucket: bucket unavailable"
+	if err != nil && err.Error() != expect {
+		t.Errorf("Delete error expected %q, got %q", expect, err.Error())
+	}
+	// Local copy should still be deleted.
+	if _, err := db.Local.Read(ctx, key); !errors.Is(err, fsdb.ErrNotFound) {
+		t.Errorf("Expected local copy to be deleted, got %v", err)
+	}
+}
+
+func TestWriteManyTierError(t *testing.T) {
+	root, db := createHybridDB(t, "write-many-tier-error: ")
+	defer os.RemoveAll(root)
+	ctx := context.Background()
+	localErr := errors.New("local unavailable")
+	hdb := hybrid.Open(
+		ctx,
+		&failingLocal{
+			Local:   db.Local,
+			Batcher: db.Local.(fsdb.Batcher),
+			err:     localErr,
+		},
+		db.Remote,
+		db.Opts.SetUseLock(false),
+	)
+	batch := hdb.(fsdb.Batcher)
+
+	for i, err := range batch.WriteMany(ctx, []fsdb.WriteEntry{
+		{Key: fsdb.Key("foo"), Data: strings.NewReader("foo")},
+		{Key: fsdb.Key("bar"), Data: strings.NewReader("bar")},
+	}) {
+		var tierErr *hybrid.TierError
+		if !errors.As(err, &tierErr) || tierErr.Tier != hybrid.TierLocal {
+			t.Errorf("WriteMany %d expected local TierError, got %v", i, err)
+		}
+		if !errors.Is(err, localErr) {
+			t.Errorf("WriteMany %d expected %v, got %v", i, localErr, err)
+		}
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "range: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	rr := db.DB.(fsdb.RangeReader)
+
+	key := fsdb.Key("foo")
+	content := "foobar"
+
+	if err := db.DB.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	compareRange(t, rr, key, 1, 3, content[1:4])
+
+	time.Sleep(longer)
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf(
+			"key should be uploaded to remote and deleted locally, got %v",
+			err,
+		)
+	}
+	compareRange(t, rr, key, 3, -1, content[3:])
+	// Now it should be available locally
+	compareContent(t, db.Local, key, content)
+}
+
 func TestHybrid(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
@@ -128,6 +365,507 @@ func TestHybrid(t *testing.T) {
 	}
 }
 
+func TestStat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "stat: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	stater := db.DB.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+
+	if _, err := stater.Stat(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Stat on empty hybrid db should return NoSuchKeyError, got %v", err)
+	}
+
+	if err := db.DB.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkStat(t, stater, key, int64(len(content)), true, false)
+
+	time.Sleep(longer)
+	checkStat(t, stater, key, int64(len(content)), false, true)
+
+	compareContent(t, db.DB, key, content)
+	checkStat(t, stater, key, int64(len(content)), true, true)
+
+	if err := db.DB.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := stater.Stat(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Stat on deleted key should return NoSuchKeyError, got %v", err)
+	}
+}
+
+func TestStatLocalSkipsRemoteRead(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "stat local: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+	remote := &countingBucket{Mock: db.Remote}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hdb := hybrid.Open(ctx, db.Local, remote, db.Opts)
+	stater := hdb.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	content := strings.Repeat("bar", 1000)
+	if err := hdb.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	time.Sleep(longer)
+	// Downloads the remote copy into local, keeping the remote one.
+	compareContent(t, hdb, key, content)
+
+	atomic.StoreInt64(&remote.read, 0)
+	checkStat(t, stater, key, int64(len(content)), true, true)
+	if n := atomic.LoadInt64(&remote.read); n != 0 {
+		t.Errorf("Stat on local entry read %d bytes from the bucket", n)
+	}
+}
+
+func TestStatRemoteSkipsData(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "stat remote: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+	remote := &countingBucket{Mock: db.Remote}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hdb := hybrid.Open(ctx, db.Local, remote, db.Opts)
+	stater := hdb.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	// Random data doesn't compress, so the remote object is large.
+	content := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(content)
+	if err := hdb.Write(ctx, key, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	time.Sleep(longer)
+
+	atomic.StoreInt64(&remote.read, 0)
+	checkStat(t, stater, key, int64(len(content)), false, true)
+	if n := atomic.LoadInt64(&remote.read); n >= int64(len(content)) {
+		t.Errorf("Stat on remote entry read %d bytes from the bucket", n)
+	}
+	info, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	checksum := sha256.Sum256(content)
+	if expected := hex.EncodeToString(checksum[:]); info.Checksum != expected {
+		t.Errorf("Stat checksum expected %q, got %q", expected, info.Checksum)
+	}
+
+	// Objects uploaded by older versions don't have the size recorded.
+	oldKey := fsdb.Key("old")
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	gw.Write(content)
+	gw.Close()
+	if err := db.Remote.Write(
+		ctx,
+		db.Opts.GetRemoteName(oldKey),
+		buf,
+	); err != nil {
+		t.Fatalf("Remote write failed: %v", err)
+	}
+	atomic.StoreInt64(&remote.read, 0)
+	checkStat(t, stater, oldKey, -1, false, true)
+	if n := atomic.LoadInt64(&remote.read); n >= int64(len(content)) {
+		t.Errorf("Stat on old remote entry read %d bytes from the bucket", n)
+	}
+}
+
+func TestConditionalWrite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "conditional: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	cw := db.DB.(fsdb.ConditionalWriter)
+	stater := db.DB.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+
+	if err := cw.WriteIfNotExists(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("WriteIfNotExists failed: %v", err)
+	}
+	info, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	gen := info.Generation
+
+	time.Sleep(longer)
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf(
+			"key should be uploaded to remote and deleted locally, got %v",
+			err,
+		)
+	}
+
+	err = cw.WriteIfNotExists(ctx, key, strings.NewReader("foo"))
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIfNotExists on remote key expected PreconditionFailedError, got %v", err)
+	}
+	info, err = stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Generation != gen {
+		t.Errorf("Remote generation expected %d, got %d", gen, info.Generation)
+	}
+	err = cw.WriteIf(ctx, key, strings.NewReader("foo"), gen-1)
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIf with wrong generation expected PreconditionFailedError, got %v", err)
+	}
+	content = "foobar"
+	if err := cw.WriteIf(ctx, key, strings.NewReader(content), gen); err != nil {
+		t.Fatalf("WriteIf on remote key failed: %v", err)
+	}
+	compareContent(t, db.Local, key, content)
+	compareContent(t, db.DB, key, content)
+}
+
+func TestConditionalWriteWithoutLock(t *testing.T) {
+	root, db := createHybridDB(t, "conditional without lock: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUseLock(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	cw := db.DB.(fsdb.ConditionalWriter)
+
+	key := fsdb.Key("foo")
+	if err := cw.WriteIfNotExists(
+		ctx,
+		key,
+		strings.NewReader("foo"),
+	); err != fsdb.ErrNotSupported {
+		t.Errorf("WriteIfNotExists without lock expected ErrNotSupported, got %v", err)
+	}
+	if err := cw.WriteIf(
+		ctx,
+		key,
+		strings.NewReader("foo"),
+		0,
+	); err != fsdb.ErrNotSupported {
+		t.Errorf("WriteIf without lock expected ErrNotSupported, got %v", err)
+	}
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected nothing written, got %v", err)
+	}
+}
+
+func TestReadThroughGeneration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "read-through generation: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	cw := db.DB.(fsdb.ConditionalWriter)
+	stater := db.DB.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+
+	if err := db.DB.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	time.Sleep(longer)
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Fatalf(
+			"key should be uploaded to remote and deleted locally, got %v",
+			err,
+		)
+	}
+
+	info, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	gen := info.Generation
+	// Downloads the remote copy into local.
+	compareContent(t, db.DB, key, content)
+	info, err = stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !info.Local || info.Generation != gen {
+		t.Errorf(
+			"Expected local copy with generation %d after Read, got %+v",
+			gen,
+			info,
+		)
+	}
+	content = "foobar"
+	if err := cw.WriteIf(ctx, key, strings.NewReader(content), gen); err != nil {
+		t.Fatalf("WriteIf with generation from before Read failed: %v", err)
+	}
+	compareContent(t, db.DB, key, content)
+}
+
+func TestMetadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+
+	root, db := createHybridDB(t, "metadata: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	mdb := db.DB.(fsdb.MetadataFSDB)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+	metadata := fsdb.Metadata{
+		"content-type": "text/plain",
+	}
+
+	if err := mdb.WriteWithMetadata(
+		ctx,
+		key,
+		strings.NewReader(content),
+		metadata,
+	); err != nil {
+		t.Fatalf("WriteWithMetadata failed: %v", err)
+	}
+	compareMetadata(t, mdb, key, metadata)
+
+	time.Sleep(longer)
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf(
+			"key should be uploaded to remote and deleted locally, got %v",
+			err,
+		)
+	}
+	// From remote header
+	compareMetadata(t, mdb, key, metadata)
+
+	// Download it and check the local copy
+	compareContent(t, db.DB, key, content)
+	compareMetadata(t, db.Local.(fsdb.MetadataFSDB), key, metadata)
+}
+
+func TestTTL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+	ttl := time.Millisecond * 300
+
+	root, db := createHybridDB(t, "ttl: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	expirer := db.DB.(fsdb.Expirer)
+
+	key := fsdb.Key("foo")
+	content := "bar"
+	if err := expirer.WriteWithTTL(
+		ctx,
+		key,
+		strings.NewReader(content),
+		ttl,
+	); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+
+	time.Sleep(longer)
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf(
+			"key should be uploaded to remote and deleted locally, got %v",
+			err,
+		)
+	}
+	info, err := db.DB.(fsdb.Stater).Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Expires.IsZero() {
+		t.Error("Expected non-zero Expires from remote")
+	}
+	// Download it, the expiration time should be kept locally.
+	compareContent(t, db.DB, key, content)
+	info, err = db.Local.(fsdb.Stater).Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Expires.IsZero() {
+		t.Error("Expected non-zero Expires from local")
+	}
+
+	time.Sleep(ttl)
+	if _, err := db.DB.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError after expiration, got %v", err)
+	}
+	if err := expirer.Reap(ctx, nil); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	keys := scanKeys(t, db.Local)
+	if len(keys) != 0 {
+		t.Errorf("Expected no local keys after Reap, got %v", keys)
+	}
+	if _, err := db.Remote.Read(
+		ctx,
+		db.Opts.GetRemoteName(key),
+	); !db.Remote.IsNotExist(err) {
+		t.Errorf("Expected remote object to be deleted, got %v", err)
+	}
+}
+
+func TestReapRemote(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	delay := time.Millisecond * 100
+	longer := time.Millisecond * 150
+	ttl := time.Millisecond * 300
+
+	root, db := createHybridDB(t, "reap remote: ")
+	defer os.RemoveAll(root)
+	db.Opts.SetUploadDelay(delay).SetSkipFunc(hybrid.UploadAll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	expirer := db.DB.(fsdb.Expirer)
+
+	expiring := fsdb.Key("expiring")
+	permanent := fsdb.Key("permanent")
+	if err := expirer.WriteWithTTL(
+		ctx,
+		expiring,
+		strings.NewReader("foo"),
+		ttl,
+	); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+	if err := db.DB.Write(ctx, permanent, strings.NewReader("bar")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	time.Sleep(longer)
+	if keys := scanKeys(t, db.Local); len(keys) != 0 {
+		t.Fatalf("Expected all keys uploaded and deleted locally, got %v", keys)
+	}
+
+	time.Sleep(ttl)
+	var reaped []fsdb.Key
+	if err := expirer.Reap(ctx, func(key fsdb.Key) bool {
+		reaped = append(reaped, key)
+		return true
+	}); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if !reflect.DeepEqual(reaped, []fsdb.Key{expiring}) {
+		t.Errorf("Reap expected to reap %v, got %v", expiring, reaped)
+	}
+	if _, err := db.Remote.Read(
+		ctx,
+		db.Opts.GetRemoteName(expiring),
+	); !db.Remote.IsNotExist(err) {
+		t.Errorf("Expected expired remote object to be deleted, got %v", err)
+	}
+	compareContent(t, db.DB, permanent, "bar")
+}
+
+func TestReapKeepsNewerRemote(t *testing.T) {
+	ttl := time.Millisecond * 50
+
+	root, db := createHybridDB(t, "reap newer: ")
+	defer os.RemoveAll(root)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db.Open(ctx)
+	expirer := db.DB.(fsdb.Expirer)
+
+	key := fsdb.Key("foo")
+	if err := expirer.WriteWithTTL(
+		ctx,
+		key,
+		strings.NewReader("foo"),
+		ttl,
+	); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+	// A newer write without TTL uploaded after the local copy expired.
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	io.WriteString(gw, "bar")
+	gw.Close()
+	if err := db.Remote.Write(ctx, db.Opts.GetRemoteName(key), buf); err != nil {
+		t.Fatalf("Remote write failed: %v", err)
+	}
+
+	time.Sleep(ttl)
+	if err := expirer.Reap(ctx, nil); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if _, err := db.Local.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected expired local copy to be reaped, got %v", err)
+	}
+	compareContent(t, db.DB, key, "bar")
+}
+
 func TestSkip(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
@@ -374,3 +1112,127 @@ func scanKeys(t *testing.T, db fsdb.Local) []fsdb.Key {
 	}
 	return keys
 }
+
+func checkStat(
+	t *testing.T,
+	stater fsdb.Stater,
+	key fsdb.Key,
+	size int64,
+	local, remote bool,
+) {
+	t.Helper()
+
+	info, err := stater.Stat(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != size {
+		t.Errorf("Stat size expected %d, got %d", size, info.Size)
+	}
+	if info.Local != local || info.Remote != remote {
+		t.Errorf(
+			"Stat expected local %v remote %v, got %+v",
+			local,
+			remote,
+			info,
+		)
+	}
+}
+
+func compareRange(
+	t *testing.T,
+	rr fsdb.RangeReader,
+	key fsdb.Key,
+	offset, length int64,
+	content string,
+) {
+	t.Helper()
+
+	reader, err := rr.ReadRange(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("ReadRange failed: %v", err)
+	}
+	defer reader.Close()
+	buf, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read range content failed: %v", err)
+	}
+	if content != string(buf) {
+		t.Errorf("read range content failed, expected %q, got %q", content, buf)
+	}
+}
+
+func compareMetadata(
+	t *testing.T,
+	mdb fsdb.MetadataFSDB,
+	key fsdb.Key,
+	metadata fsdb.Metadata,
+) {
+	t.Helper()
+
+	actual, err := mdb.ReadMetadata(context.Background(), key)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(actual, metadata) {
+		t.Errorf("ReadMetadata expected %v, got %v", metadata, actual)
+	}
+}
+
+// countingBucket counts the bytes read from the bucket.
+type countingBucket struct {
+	*bucket.Mock
+
+	read int64
+}
+
+func (b *countingBucket) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, err := b.Mock.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReadCloser{ReadCloser: reader, n: &b.read}, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+
+	n *int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// failingBucket fails all the read and delete operations with err.
+type failingBucket struct {
+	*bucket.Mock
+
+	err error
+}
+
+func (b *failingBucket) Read(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, b.err
+}
+
+func (b *failingBucket) Delete(ctx context.Context, name string) error {
+	return b.err
+}
+
+// failingLocal fails all the WriteMany operations with err.
+type failingLocal struct {
+	fsdb.Local
+	fsdb.Batcher
+
+	err error
+}
+
+func (l *failingLocal) WriteMany(ctx context.Context, entries []fsdb.WriteEntry) []error {
+	errs := make([]error, len(entries))
+	for i := range errs {
+		errs[i] = l.err
+	}
+	return errs
+}
@@ -0,0 +1,86 @@
+package hybrid
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.MetadataFSDB interface.
+var _ fsdb.MetadataFSDB = (*impl)(nil)
+
+// WriteWithMetadata writes an entry with metadata locally.
+//
+// The metadata will be uploaded to the remote bucket along with the data,
+// in the extra field of the gzip header.
+// As a result the encoded metadata must be smaller than 64KiB,
+// or the upload will fail.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.MetadataFSDB.
+func (db *impl) WriteWithMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	metadata fsdb.Metadata,
+) error {
+	mdb, ok := db.local.(fsdb.MetadataFSDB)
+	if !ok {
+		return fsdb.ErrNotSupported
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if db.opts.GetUseLock() {
+		db.locks.Lock(string(key))
+		defer db.locks.Unlock(string(key))
+	}
+	return mdb.WriteWithMetadata(ctx, key, data, metadata)
+}
+
+// ReadMetadata returns the metadata of an entry.
+//
+// It reads from the local copy first.
+// If the entry does not exist locally,
+// it reads the metadata from the header of the remote object,
+// without saving the entry locally.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.MetadataFSDB.
+func (db *impl) ReadMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+) (fsdb.Metadata, error) {
+	mdb, ok := db.local.(fsdb.MetadataFSDB)
+	if !ok {
+		return nil, fsdb.ErrNotSupported
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	metadata, err := mdb.ReadMetadata(ctx, key)
+	if !fsdb.IsNoSuchKeyError(err) {
+		return metadata, tierError(TierLocal, err)
+	}
+
+	info, err := db.readRemoteInfo(ctx, key)
+	if db.bucket.IsNotExist(err) {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	if err != nil {
+		return nil, tierError(TierBucket, err)
+	}
+	if db.expireRemote(ctx, key, info) {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	return info.Metadata, nil
+}
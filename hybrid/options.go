@@ -14,6 +14,7 @@ const (
 	DefaultUploadDelay     time.Duration = time.Minute * 5
 	DefaultUploadThreadNum               = 5
 	DefaultUseLock                       = true
+	DefaultBatchThreadNum                = fsdb.DefaultBatchThreadNum
 )
 
 // DefaultNameFunc is the default name function used.
@@ -49,11 +50,18 @@ type Options interface {
 	// but it also means heavier disk I/O load.
 	GetUploadThreadNum() int
 
+	// GetBatchThreadNum returns the number of threads used in batch operations,
+	// which is also the maximum number of concurrent remote fetches in ReadMany.
+	GetBatchThreadNum() int
+
 	// GetUseLock returns whether we should use a row lock.
 	//
 	// Uses a row lock guarantees that we do not overwrite newer data with stale
 	// data, but it also degrades all operations.
 	//
+	// Conditional writes (WriteIf and WriteIfNotExists) require the row lock,
+	// and return fsdb.ErrNotSupported when it's off.
+	//
 	// Refer to the package documentation for more details.
 	GetUseLock() bool
 
@@ -87,6 +95,9 @@ type OptionsBuilder interface {
 	// SetUploadThreadNum sets the number of threads used in upload scan loops.
 	SetUploadThreadNum(threads int) OptionsBuilder
 
+	// SetBatchThreadNum sets the number of threads used in batch operations.
+	SetBatchThreadNum(threads int) OptionsBuilder
+
 	// SetUseLock sets whether to use a row lock.
 	SetUseLock(lock bool) OptionsBuilder
 
@@ -100,6 +111,7 @@ type OptionsBuilder interface {
 type options struct {
 	delay    time.Duration
 	threads  int
+	batch    int
 	logger   *log.Logger
 	lock     bool
 	nameFunc func(fsdb.Key) string
@@ -111,6 +123,7 @@ func NewDefaultOptions() OptionsBuilder {
 	return &options{
 		delay:    DefaultUploadDelay,
 		threads:  DefaultUploadThreadNum,
+		batch:    DefaultBatchThreadNum,
 		logger:   nil,
 		lock:     DefaultUseLock,
 		nameFunc: DefaultNameFunc,
@@ -126,6 +139,10 @@ func (opt *options) GetUploadThreadNum() int {
 	return opt.threads
 }
 
+func (opt *options) GetBatchThreadNum() int {
+	return opt.batch
+}
+
 func (opt *options) GetUseLock() bool {
 	return opt.lock
 }
@@ -156,6 +173,11 @@ func (opt *options) SetUploadThreadNum(threads int) OptionsBuilder {
 	return opt
 }
 
+func (opt *options) SetBatchThreadNum(threads int) OptionsBuilder {
+	opt.batch = threads
+	return opt
+}
+
 func (opt *options) SetUseLock(lock bool) OptionsBuilder {
 	opt.lock = lock
 	return opt
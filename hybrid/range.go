@@ -0,0 +1,30 @@
+package hybrid
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.RangeReader interface.
+var _ fsdb.RangeReader = (*impl)(nil)
+
+// ReadRange reads a byte window of an entry.
+//
+// The range is always served from the local copy.
+// If the entry does not exist locally,
+// it will be downloaded from the remote bucket and saved locally first,
+// same as Read.
+func (db *impl) ReadRange(
+	ctx context.Context,
+	key fsdb.Key,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fsdb.ErrNegativeOffset
+	}
+	return db.readThrough(ctx, key, func() (io.ReadCloser, error) {
+		return fsdb.ReadRange(ctx, db.local, key, offset, length)
+	})
+}
@@ -0,0 +1,167 @@
+package hybrid
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// The subfield ID used in the extra field of the gzip header of remote objects,
+// as defined in RFC 1952.
+const (
+	extraSI1 = 'F'
+	extraSI2 = 'S'
+)
+
+// Length of the subfield header: SI1, SI2 and 2 bytes of LEN.
+const extraSubfieldHeaderLen = 4
+
+var errExtraTooLong = errors.New("hybrid: remote info too long for gzip header")
+
+// remoteInfo is the entry info stored in the extra field of the gzip header of
+// remote objects.
+//
+// Objects uploaded by older versions don't have it.
+type remoteInfo struct {
+	Generation int64         `json:"generation,omitempty"`
+	Metadata   fsdb.Metadata `json:"metadata,omitempty"`
+
+	// Expires is the expiration time in unix nanoseconds,
+	// or 0 if the entry never expires.
+	Expires int64 `json:"expires,omitempty"`
+
+	// Key is the key of the entry.
+	//
+	// It's only recorded for the entries that expire,
+	// so Reap can find them from the remote names.
+	Key fsdb.Key `json:"key,omitempty"`
+
+	// Size is the size of the uncompressed data,
+	// or -1 for objects uploaded by older versions.
+	Size int64 `json:"size"`
+
+	// Checksum is the hex encoded SHA-256 checksum of the uncompressed data,
+	// or empty for objects uploaded by older versions.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// newRemoteInfo creates an empty remoteInfo with unknown size.
+func newRemoteInfo() *remoteInfo {
+	return &remoteInfo{
+		Size: -1,
+	}
+}
+
+// expired returns true if the entry is expired.
+func (info *remoteInfo) expired() bool {
+	return info.Expires != 0 && time.Now().UnixNano() >= info.Expires
+}
+
+// ttl returns the remaining time-to-live of the entry,
+// or -1 if it never expires.
+func (info *remoteInfo) ttl() time.Duration {
+	if info.Expires == 0 {
+		return -1
+	}
+	ttl := time.Unix(0, info.Expires).Sub(time.Now())
+	if ttl <= 0 {
+		// Already expired, but 0 means default TTL so use the smallest positive
+		// value instead.
+		ttl = 1
+	}
+	return ttl
+}
+
+// expireRemote deletes the remote object if info is expired.
+//
+// It returns true if the remote object is expired.
+func (db *impl) expireRemote(
+	ctx context.Context,
+	key fsdb.Key,
+	info *remoteInfo,
+) bool {
+	if !info.expired() {
+		return false
+	}
+	err := db.bucket.Delete(ctx, db.opts.GetRemoteName(key))
+	if err != nil && !db.bucket.IsNotExist(err) {
+		if logger := db.opts.GetLogger(); logger != nil {
+			logger.Printf("failed to delete expired %v from bucket: %v", key, err)
+		}
+	}
+	return true
+}
+
+// encodeExtra encodes info into a gzip header extra field.
+func encodeExtra(info *remoteInfo) ([]byte, error) {
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > math.MaxUint16-extraSubfieldHeaderLen {
+		return nil, errExtraTooLong
+	}
+	extra := make([]byte, extraSubfieldHeaderLen, extraSubfieldHeaderLen+len(payload))
+	extra[0] = extraSI1
+	extra[1] = extraSI2
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(payload)))
+	return append(extra, payload...), nil
+}
+
+// decodeExtra decodes the remote info from a gzip header extra field.
+//
+// It returns an empty remoteInfo if the extra field does not contain one.
+func decodeExtra(extra []byte) *remoteInfo {
+	info := newRemoteInfo()
+	for len(extra) >= extraSubfieldHeaderLen {
+		length := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < extraSubfieldHeaderLen+length {
+			break
+		}
+		payload := extra[extraSubfieldHeaderLen : extraSubfieldHeaderLen+length]
+		if extra[0] == extraSI1 && extra[1] == extraSI2 {
+			if err := json.Unmarshal(payload, info); err != nil {
+				return newRemoteInfo()
+			}
+			return info
+		}
+		extra = extra[extraSubfieldHeaderLen+length:]
+	}
+	return info
+}
+
+// readRemoteInfo reads the remote info from the header of the remote object,
+// without downloading the whole object.
+//
+// It returns the error from the bucket as-is,
+// so bucket.IsNotExist can be used on it.
+func (db *impl) readRemoteInfo(
+	ctx context.Context,
+	key fsdb.Key,
+) (*remoteInfo, error) {
+	return db.readRemoteInfoByName(ctx, db.opts.GetRemoteName(key))
+}
+
+// readRemoteInfoByName is readRemoteInfo with the remote name.
+func (db *impl) readRemoteInfoByName(
+	ctx context.Context,
+	name string,
+) (*remoteInfo, error) {
+	data, err := db.bucket.Read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	reader, err := gzip.NewReader(data)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return decodeExtra(reader.Header.Extra), nil
+}
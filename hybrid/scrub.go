@@ -0,0 +1,140 @@
+package hybrid
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+// Make sure *impl satisfies local.Scrubber interface.
+var _ local.Scrubber = (*impl)(nil)
+
+// Scrub verifies the local entries via local.Scrub.
+//
+// A corrupt local copy is repaired by downloading the remote copy again,
+// if the remote copy exists and matches the checksum stored with the local
+// copy.
+// Otherwise opts.Repair is called, if it's not nil.
+//
+// If the local FSDB does not implement local.Scrubber,
+// it returns fsdb.ErrNotSupported.
+func (db *impl) Scrub(
+	ctx context.Context,
+	opts local.ScrubOptions,
+) (local.ScrubStats, error) {
+	repair := opts.Repair
+	opts.Repair = func(ctx context.Context, key fsdb.Key) (bool, error) {
+		repaired, err := db.repairLocal(ctx, key)
+		if repaired || err != nil || repair == nil {
+			return repaired, err
+		}
+		return repair(ctx, key)
+	}
+	return local.Scrub(ctx, db.local, opts)
+}
+
+// repairLocal replaces the corrupt local copy of key with the remote copy.
+//
+// When the local data is still there,
+// it only does that when the remote copy has the same checksum as the one
+// stored with the local copy,
+// so a newer local write not uploaded yet is never rolled back.
+// When the local data is missing there's nothing left to roll back,
+// so the remote copy is always used.
+//
+// The broken local entry is deleted before writing the remote copy,
+// and the repaired entry gets a new generation.
+func (db *impl) repairLocal(ctx context.Context, key fsdb.Key) (bool, error) {
+	stater, ok := db.local.(fsdb.Stater)
+	if !ok {
+		return false, nil
+	}
+	stat, err := statCorrupt(ctx, stater, key)
+	if err != nil {
+		return false, tierError(TierLocal, err)
+	}
+	if stat != nil && stat.Checksum == "" {
+		return false, nil
+	}
+
+	remoteData, info, err := db.readBucket(ctx, key)
+	if db.bucket.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, tierError(TierBucket, err)
+	}
+	buf, err := ioutil.ReadAll(remoteData)
+	if err != nil {
+		return false, tierError(TierBucket, err)
+	}
+	sum := sha256.Sum256(buf)
+	checksum := hex.EncodeToString(sum[:])
+	if info.Checksum != "" && info.Checksum != checksum {
+		// The remote copy is corrupt, too.
+		return false, nil
+	}
+	if stat != nil && checksum != stat.Checksum {
+		return false, nil
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	if db.opts.GetUseLock() {
+		db.locks.Lock(string(key))
+		defer db.locks.Unlock(string(key))
+	}
+	// Check the local copy again, so that in case a new write happened during
+	// downloading, we don't overwrite it with stale remote data.
+	current, err := statCorrupt(ctx, stater, key)
+	if err != nil {
+		return false, tierError(TierLocal, err)
+	}
+	if (current == nil) != (stat == nil) {
+		return false, nil
+	}
+	if current != nil &&
+		(current.Generation != stat.Generation ||
+			current.Checksum != stat.Checksum) {
+		return false, nil
+	}
+	if err := db.local.Delete(ctx, key); err != nil && !fsdb.IsNoSuchKeyError(err) {
+		return false, tierError(TierLocal, err)
+	}
+	// Don't reuse the remote generation,
+	// it could be older than the one of the broken local copy.
+	fresh := *info
+	fresh.Generation = 0
+	if err := db.writeLocal(ctx, key, bytes.NewReader(buf), &fresh); err != nil {
+		return false, tierError(TierLocal, err)
+	}
+	if logger := db.opts.GetLogger(); logger != nil {
+		logger.Printf("repaired corrupt local copy of %v from bucket", key)
+	}
+	return true, nil
+}
+
+// statCorrupt returns the local stat of a corrupt entry.
+//
+// It returns nil info and nil error if Stat reports the entry as corrupt,
+// e.g. when its data file is missing.
+func statCorrupt(
+	ctx context.Context,
+	stater fsdb.Stater,
+	key fsdb.Key,
+) (*fsdb.EntryInfo, error) {
+	info, err := stater.Stat(ctx, key)
+	if fsdb.IsCorruptEntryError(err) {
+		return nil, nil
+	}
+	return info, err
+}
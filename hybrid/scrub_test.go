@@ -0,0 +1,159 @@
+package hybrid_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/hybrid"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestScrub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, db := createHybridDB(t, "scrub: ")
+	defer os.RemoveAll(root)
+	db.Open(ctx)
+
+	content := strings.Repeat("scrub me ", 100)
+	remote := fsdb.Key("remote")
+	localOnly := fsdb.Key("local")
+	uploadViaAnother(ctx, t, db, remote, content)
+	// Read through to save a local copy.
+	compareContent(t, db.DB, remote, content)
+	if err := db.DB.Write(ctx, localOnly, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	opts := local.NewDefaultOptions(root + "local")
+	for _, key := range []fsdb.Key{remote, localOnly} {
+		path := opts.GetDirForKey(key) + local.DataFilename
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		data[0] ^= 1
+		if err := ioutil.WriteFile(path, data, local.FileModeForFiles); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	repaired := make(map[string]bool)
+	stats, err := local.Scrub(ctx, db.DB, local.ScrubOptions{
+		CorruptFunc: func(c local.Corruption) bool {
+			if c.RepairErr != nil {
+				t.Errorf("%v: repair failed: %v", c.Key, c.RepairErr)
+			}
+			repaired[string(c.Key)] = c.Repaired
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if stats.Corrupt != 2 || stats.Repaired != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if !repaired[string(remote)] || repaired[string(localOnly)] {
+		t.Errorf("Expected only %v to be repaired, got %v", remote, repaired)
+	}
+	compareContent(t, db.Local, remote, content)
+}
+
+func TestScrubMissingData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, db := createHybridDB(t, "scrub-missing: ")
+	defer os.RemoveAll(root)
+	db.Open(ctx)
+	stater := db.Local.(fsdb.Stater)
+
+	content := strings.Repeat("scrub me ", 100)
+	key := fsdb.Key("remote")
+	uploadViaAnother(ctx, t, db, key, content)
+	// Read through to save a local copy.
+	compareContent(t, db.DB, key, content)
+	before, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	dir := local.NewDefaultOptions(root + "local").GetDirForKey(key)
+	files, err := filepath.Glob(dir + local.DataFilename + "*")
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("No data files found in %s", dir)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			t.Fatalf("Remove failed: %v", err)
+		}
+	}
+
+	stats, err := local.Scrub(ctx, db.DB, local.ScrubOptions{
+		CorruptFunc: func(c local.Corruption) bool {
+			if c.RepairErr != nil {
+				t.Errorf("%v: repair failed: %v", c.Key, c.RepairErr)
+			}
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if stats.Corrupt != 1 || stats.Repaired != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	compareContent(t, db.Local, key, content)
+	after, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if after.Generation == before.Generation {
+		t.Errorf("Expected a new generation after repair, got %d", after.Generation)
+	}
+}
+
+// uploadViaAnother uploads key to the bucket of db via another hybrid db
+// sharing the same bucket, so db itself doesn't have a local copy.
+func uploadViaAnother(
+	ctx context.Context,
+	t *testing.T,
+	db dbCollection,
+	key fsdb.Key,
+	content string,
+) {
+	t.Helper()
+
+	root, uploader := createHybridDB(t, "scrub-uploader: ")
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+	})
+	uploader.Remote = db.Remote
+	uploader.Opts.SetUploadDelay(time.Millisecond * 10).SetSkipFunc(hybrid.UploadAll)
+	uploader.Open(ctx)
+
+	if err := uploader.DB.Write(ctx, key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if _, err := uploader.Local.Read(ctx, key); fsdb.IsNoSuchKeyError(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("upload timed out")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
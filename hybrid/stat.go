@@ -0,0 +1,134 @@
+package hybrid
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Stater interface.
+var _ fsdb.Stater = (*impl)(nil)
+
+// Stat returns the metadata of an entry.
+//
+// If the entry exists locally, the metadata is from the local copy,
+// and the remote object is only opened to tell whether it exists,
+// without reading it.
+// Otherwise only the header of the remote object is read,
+// and the size, checksum and generation are the ones recorded when it was
+// uploaded.
+// The stored size of remote copies is unknown (-1),
+// and so is the size of objects uploaded by older versions.
+func (db *impl) Stat(ctx context.Context, key fsdb.Key) (*fsdb.EntryInfo, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	info, err := db.statLocal(ctx, key)
+	if err != nil && !fsdb.IsNoSuchKeyError(err) {
+		return nil, tierError(TierLocal, err)
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	remoteData, err := db.bucket.Read(ctx, db.opts.GetRemoteName(key))
+	if db.bucket.IsNotExist(err) {
+		if info == nil {
+			return nil, &fsdb.NoSuchKeyError{Key: key}
+		}
+		return info, nil
+	}
+	if err != nil {
+		return nil, tierError(TierBucket, err)
+	}
+	if info != nil {
+		remoteData.Close()
+		info.Remote = true
+		return info, nil
+	}
+	defer remoteData.Close()
+
+	info, err = statRemote(key, remoteData, func(remote *remoteInfo) bool {
+		return !db.expireRemote(ctx, key, remote)
+	})
+	if err != nil {
+		return nil, tierError(TierBucket, err)
+	}
+	if info == nil {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	return info, nil
+}
+
+// statLocal returns the metadata of the local copy.
+//
+// If the local FSDB does not implement fsdb.Stater,
+// it reads the local copy fully to get the size.
+func (db *impl) statLocal(
+	ctx context.Context,
+	key fsdb.Key,
+) (*fsdb.EntryInfo, error) {
+	if stater, ok := db.local.(fsdb.Stater); ok {
+		return stater.Stat(ctx, key)
+	}
+
+	reader, err := db.local.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	size, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return nil, err
+	}
+	return &fsdb.EntryInfo{
+		Key:        key,
+		Size:       size,
+		StoredSize: size,
+		Local:      true,
+	}, nil
+}
+
+// statRemote returns the metadata of the remote copy from the header of the
+// remote object, without reading the data.
+//
+// check is called with the remote info from the header,
+// and statRemote returns nil info if it returns false.
+func statRemote(
+	key fsdb.Key,
+	data io.Reader,
+	check func(remote *remoteInfo) bool,
+) (*fsdb.EntryInfo, error) {
+	gzipReader, err := gzip.NewReader(data)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	remote := decodeExtra(gzipReader.Header.Extra)
+	if !check(remote) {
+		return nil, nil
+	}
+	info := &fsdb.EntryInfo{
+		Key:        key,
+		Size:       remote.Size,
+		StoredSize: -1,
+		Codec:      fsdb.CodecGzip,
+		Checksum:   remote.Checksum,
+		Generation: remote.Generation,
+		Remote:     true,
+	}
+	if remote.Expires != 0 {
+		info.Expires = time.Unix(0, remote.Expires)
+	}
+	return info, nil
+}
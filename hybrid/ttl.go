@@ -0,0 +1,221 @@
+package hybrid
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/bucket"
+)
+
+// Make sure *impl satisfies fsdb.Expirer and fsdb.OptionsWriter interfaces.
+var (
+	_ fsdb.Expirer       = (*impl)(nil)
+	_ fsdb.OptionsWriter = (*impl)(nil)
+)
+
+// WriteWithTTL writes an entry that expires after ttl locally.
+//
+// The expiration time will be uploaded to the remote bucket along with the
+// data.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.Expirer.
+func (db *impl) WriteWithTTL(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	ttl time.Duration,
+) error {
+	e, ok := db.local.(fsdb.Expirer)
+	if !ok {
+		return fsdb.ErrNotSupported
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if db.opts.GetUseLock() {
+		db.locks.Lock(string(key))
+		defer db.locks.Unlock(string(key))
+	}
+	return e.WriteWithTTL(ctx, key, data, ttl)
+}
+
+// WriteWithOptions writes an entry with extra options locally.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.OptionsWriter.
+func (db *impl) WriteWithOptions(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	opts fsdb.WriteOptions,
+) error {
+	ow, ok := db.local.(fsdb.OptionsWriter)
+	if !ok {
+		return fsdb.ErrNotSupported
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if db.opts.GetUseLock() {
+		db.locks.Lock(string(key))
+		defer db.locks.Unlock(string(key))
+	}
+	return ow.WriteWithOptions(ctx, key, data, opts)
+}
+
+// Reap removes all the expired local entries,
+// and deletes their remote copies if they are expired, too.
+// Remote copies not expired, e.g. uploaded by a newer write without TTL,
+// are kept.
+//
+// If the bucket implements bucket.Lister,
+// it also deletes the expired remote objects,
+// using the expiration time recorded in them.
+// Otherwise the entries that only exist on the remote bucket can't be found by
+// Reap, and they are deleted when they are accessed after expiration.
+// Remote objects uploaded by older versions are never deleted by Reap.
+//
+// It returns fsdb.ErrNotSupported if the local FSDB does not implement
+// fsdb.Expirer.
+func (db *impl) Reap(ctx context.Context, keyFunc fsdb.KeyFunc) error {
+	e, ok := db.local.(fsdb.Expirer)
+	if !ok {
+		return fsdb.ErrNotSupported
+	}
+
+	logger := db.opts.GetLogger()
+	aborted := false
+	err := e.Reap(ctx, func(key fsdb.Key) bool {
+		if _, err := db.reapRemoteKey(ctx, key); err != nil && logger != nil {
+			logger.Printf("failed to delete expired %v from bucket: %v", key, err)
+		}
+		if keyFunc != nil && !keyFunc(key) {
+			aborted = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		err = tierError(TierLocal, err)
+	}
+	if aborted {
+		return err
+	}
+	return fsdb.CombineErrors(err, db.reapRemote(ctx, keyFunc))
+}
+
+// reapRemote deletes the expired remote objects,
+// if the bucket implements bucket.Lister.
+//
+// Errors on individual objects don't stop the reap,
+// the first one is returned after the listing finishes.
+func (db *impl) reapRemote(ctx context.Context, keyFunc fsdb.KeyFunc) error {
+	lister, ok := db.bucket.(bucket.Lister)
+	if !ok {
+		return nil
+	}
+
+	var reapErr error
+	if err := lister.List(ctx, func(name string) bool {
+		key, err := db.reapRemoteObject(ctx, name)
+		if err != nil {
+			// Keep reaping other objects, but report the first error.
+			if reapErr == nil {
+				reapErr = err
+			}
+			return true
+		}
+		if key != nil && keyFunc != nil {
+			return keyFunc(key)
+		}
+		return true
+	}); err != nil {
+		return tierError(TierBucket, err)
+	}
+	return reapErr
+}
+
+// reapRemoteObject deletes the remote object of name if it's expired,
+// and returns the key of the deleted entry.
+//
+// It returns nil key if the object is not deleted.
+func (db *impl) reapRemoteObject(ctx context.Context, name string) (fsdb.Key, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	info, err := db.readRemoteInfoByName(ctx, name)
+	if db.bucket.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, tierError(TierBucket, err)
+	}
+	key := info.Key
+	if !info.expired() || key == nil || db.opts.GetRemoteName(key) != name {
+		return nil, nil
+	}
+	deleted, err := db.reapRemoteKey(ctx, key)
+	if err != nil || !deleted {
+		return nil, err
+	}
+	return key, nil
+}
+
+// reapRemoteKey deletes the remote object of key if it's expired,
+// and there's no local copy.
+//
+// The checks and the delete are done with the row lock held,
+// so an object uploaded by a newer write is never deleted.
+//
+// It returns true if the remote object is deleted.
+func (db *impl) reapRemoteKey(ctx context.Context, key fsdb.Key) (bool, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	// A local copy is newer than the remote one,
+	// and will be uploaded over it.
+	_, err := db.statLocal(ctx, key)
+	if err == nil {
+		return false, nil
+	}
+	if !fsdb.IsNoSuchKeyError(err) {
+		return false, tierError(TierLocal, err)
+	}
+	info, err := db.readRemoteInfo(ctx, key)
+	if db.bucket.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, tierError(TierBucket, err)
+	}
+	if !info.expired() {
+		return false, nil
+	}
+	if err := db.bucket.Delete(ctx, db.opts.GetRemoteName(key)); err != nil {
+		if db.bucket.IsNotExist(err) {
+			return false, nil
+		}
+		return false, tierError(TierBucket, err)
+	}
+	return true, nil
+}
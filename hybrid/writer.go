@@ -0,0 +1,53 @@
+package hybrid
+
+import (
+	"context"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.StreamWriter interface.
+var _ fsdb.StreamWriter = (*impl)(nil)
+
+// OpenWriter opens an EntryWriter on the local FSDB.
+//
+// If the local FSDB does not implement fsdb.StreamWriter,
+// it falls back to fsdb.OpenWriter.
+// The optional row lock is only held during Commit.
+func (db *impl) OpenWriter(
+	ctx context.Context,
+	key fsdb.Key,
+) (fsdb.EntryWriter, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	writer, err := fsdb.OpenWriter(ctx, db.local, key)
+	if err != nil {
+		return nil, err
+	}
+	if !db.opts.GetUseLock() {
+		return writer, nil
+	}
+	return &lockedEntryWriter{
+		EntryWriter: writer,
+		db:          db,
+		key:         key,
+	}, nil
+}
+
+// lockedEntryWriter holds the row lock of the key during Commit.
+type lockedEntryWriter struct {
+	fsdb.EntryWriter
+
+	db  *impl
+	key fsdb.Key
+}
+
+func (w *lockedEntryWriter) Commit() error {
+	w.db.locks.Lock(string(w.key))
+	defer w.db.locks.Unlock(string(w.key))
+	return w.EntryWriter.Commit()
+}
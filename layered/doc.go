@@ -0,0 +1,35 @@
+// Package layered provides an FSDB implementation composed of multiple FSDB
+// tiers, e.g. memory -> local SSD -> local HDD -> hybrid.
+//
+// Tiers are ordered from the top (fastest) to the bottom (slowest).
+// Reads are served from the top most tier having the entry,
+// and entries read from lower tiers can be promoted into the tiers above.
+// Writes go to the first tier,
+// and are propagated to the lower tiers either before Write returns
+// (WriteThrough) or by a background flush loop (WriteBack).
+// Deletes fan out to all the tiers concurrently by default,
+// or go through the tiers one by one from the bottom up when
+// DeleteConcurrently option is false.
+//
+// Concurrency
+//
+// Writes, deletes, promotions and flushes on the same key are serialized by
+// an in-process row lock,
+// so that stale data from a lower tier never overwrites newer data in the
+// tiers above.
+//
+// When deleting concurrently, if deleting from one of the tiers fails,
+// Delete returns a TierError but the entry might already be deleted from
+// the tiers above, so a later read could promote the stale copy back.
+// Deleting sequentially stops at the first failed tier from the bottom,
+// leaving the tiers above it untouched.
+//
+// With WriteThrough policy, if writing into a lower tier fails,
+// Write returns a TierError but the tiers above already have the new data.
+//
+// With WriteBack policy,
+// entries pending flush are only tracked in memory,
+// so they are never written to the lower tiers if the process exits before
+// the next flush.
+// Call Flush before shutting down to avoid that.
+package layered
@@ -0,0 +1,43 @@
+package layered
+
+import (
+	"fmt"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *TierError satisfies error interface.
+var _ error = (*TierError)(nil)
+
+// TierError is an error returned by layered FSDB to tell which tier failed.
+//
+// When multiple tiers failed in a single operation (e.g. Delete),
+// the returned error is an *fsdb.MultiError containing multiple TierErrors.
+type TierError struct {
+	// Tier is the index of the tier in the tiers passed to Open.
+	Tier int
+	Err  error
+}
+
+func (err *TierError) Error() string {
+	return fmt.Sprintf("layered tier %d: %v", err.Tier, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *TierError) Unwrap() error {
+	return err.Err
+}
+
+// tierError wraps err into a TierError.
+//
+// It returns err as-is if it's nil or a NoSuchKeyError,
+// as the key not existing on a tier is not a failure of that tier.
+func tierError(tier int, err error) error {
+	if err == nil || fsdb.IsNoSuchKeyError(err) {
+		return err
+	}
+	return &TierError{
+		Tier: tier,
+		Err:  err,
+	}
+}
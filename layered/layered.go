@@ -0,0 +1,330 @@
+package layered
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fishy/rowlock"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies FSDB interface.
+var _ FSDB = (*impl)(nil)
+
+// ErrNoTiers is the error returned by Open when no tiers are given.
+var ErrNoTiers = errors.New("layered: no tiers")
+
+// FSDB is the fsdb.FSDB returned by Open.
+type FSDB interface {
+	fsdb.FSDB
+
+	// Flush writes all the entries pending from WriteBack policy to the lower
+	// tiers.
+	//
+	// It's called by the background flush loop,
+	// and it's safe to call it manually (e.g. before shutting down).
+	// Entries failed to flush are kept pending,
+	// and the first error is returned.
+	Flush(ctx context.Context) error
+}
+
+type impl struct {
+	tiers []fsdb.FSDB
+	opts  Options
+	locks *rowlock.RowLock
+
+	dirtyLock sync.Mutex
+	dirty     map[string]fsdb.Key
+}
+
+// Open creates a layered FSDB from tiers,
+// ordered from the top (fastest) to the bottom (slowest).
+//
+// The context passed in will be used to control the background flush loop,
+// which is only started with WriteBack policy.
+//
+// Read reads from the tiers in order,
+// and returns the first one found.
+// If the promote option is on,
+// the entry found in a lower tier is copied into all the tiers above it.
+//
+// Write writes according to the write policy.
+// With WriteThrough, it writes to the first tier,
+// then copies the data into the lower tiers in order.
+// With WriteBack, it only writes to the first tier,
+// and the data will be copied into the lower tiers by the flush loop.
+//
+// Delete deletes from all the tiers concurrently,
+// and returns combined errors, if any.
+// It only returns a NoSuchKeyError when the key does not exist in any tier.
+func Open(ctx context.Context, tiers []fsdb.FSDB, opts Options) (FSDB, error) {
+	if len(tiers) == 0 {
+		return nil, ErrNoTiers
+	}
+	db := &impl{
+		tiers: tiers,
+		opts:  opts,
+		locks: rowlock.NewRowLock(rowlock.MutexNewLocker),
+		dirty: make(map[string]fsdb.Key),
+	}
+	if opts.GetWritePolicy() == WriteBack && len(tiers) > 1 {
+		go db.startFlushLoop(ctx)
+	}
+	return db, nil
+}
+
+func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for i, tier := range db.tiers {
+		reader, err := tier.Read(ctx, key)
+		if fsdb.IsNoSuchKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, tierError(i, err)
+		}
+		if i == 0 || !db.opts.GetPromote() {
+			return reader, nil
+		}
+		reader.Close()
+		return db.promote(ctx, key, i)
+	}
+	return nil, &fsdb.NoSuchKeyError{Key: key}
+}
+
+// promote copies the entry of key from tier found into all the tiers above
+// it, and returns the reader from the top most tier having it.
+func (db *impl) promote(
+	ctx context.Context,
+	key fsdb.Key,
+	found int,
+) (io.ReadCloser, error) {
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	// Check again, so that in case a new write happened during reading, we
+	// don't overwrite it with stale data.
+	for i := 0; i < found; i++ {
+		reader, err := db.tiers[i].Read(ctx, key)
+		if err == nil {
+			return reader, nil
+		}
+		if !fsdb.IsNoSuchKeyError(err) {
+			return nil, tierError(i, err)
+		}
+	}
+
+	src := found
+	for i := found - 1; i >= 0; i-- {
+		if err := db.copy(ctx, key, src, i); err != nil {
+			if fsdb.IsNoSuchKeyError(err) {
+				// Deleted during promotion.
+				return nil, err
+			}
+			if logger := db.opts.GetLogger(); logger != nil {
+				logger.Printf("failed to promote %v: %v", key, err)
+			}
+			break
+		}
+		src = i
+	}
+	reader, err := db.tiers[src].Read(ctx, key)
+	if err != nil {
+		return nil, tierError(src, err)
+	}
+	return reader, nil
+}
+
+// copy copies the entry of key from tier src to tier dst.
+func (db *impl) copy(ctx context.Context, key fsdb.Key, src, dst int) error {
+	reader, err := db.tiers[src].Read(ctx, key)
+	if err != nil {
+		return tierError(src, err)
+	}
+	defer reader.Close()
+	return tierError(dst, db.tiers[dst].Write(ctx, key, reader))
+}
+
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	if err := db.tiers[0].Write(ctx, key, data); err != nil {
+		return tierError(0, err)
+	}
+	if db.opts.GetWritePolicy() == WriteBack {
+		db.markDirty(key)
+		return nil
+	}
+	for i := 1; i < len(db.tiers); i++ {
+		if err := db.copy(ctx, key, 0, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	db.dirtyLock.Lock()
+	delete(db.dirty, string(key))
+	db.dirtyLock.Unlock()
+
+	if !db.opts.GetDeleteConcurrently() {
+		return db.deleteSequentially(ctx, key)
+	}
+
+	errs := fsdb.BatchDo(ctx, len(db.tiers), len(db.tiers), func(i int) error {
+		return db.tiers[i].Delete(ctx, key)
+	})
+	existNone := true
+	for i, err := range errs {
+		if !fsdb.IsNoSuchKeyError(err) {
+			existNone = false
+			errs[i] = tierError(i, err)
+		} else {
+			errs[i] = nil
+		}
+	}
+	if existNone {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	return fsdb.CombineErrors(errs...)
+}
+
+// deleteSequentially deletes key from the last tier up to the first tier.
+//
+// Going from the bottom up makes sure that if it stops at a failed tier,
+// all the tiers above it still have the entry, so a read will never promote
+// a copy that was supposed to be deleted.
+//
+// Caller should hold the lock of key.
+func (db *impl) deleteSequentially(ctx context.Context, key fsdb.Key) error {
+	existNone := true
+	for i := len(db.tiers) - 1; i >= 0; i-- {
+		select {
+		default:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		err := db.tiers[i].Delete(ctx, key)
+		if fsdb.IsNoSuchKeyError(err) {
+			continue
+		}
+		existNone = false
+		if err != nil {
+			return tierError(i, err)
+		}
+	}
+	if existNone {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	return nil
+}
+
+func (db *impl) Flush(ctx context.Context) error {
+	db.dirtyLock.Lock()
+	keys := make([]fsdb.Key, 0, len(db.dirty))
+	for _, key := range db.dirty {
+		keys = append(keys, key)
+	}
+	db.dirtyLock.Unlock()
+
+	errs := fsdb.BatchDo(
+		ctx,
+		len(keys),
+		db.opts.GetFlushThreadNum(),
+		func(i int) error {
+			return db.flush(ctx, keys[i])
+		},
+	)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markDirty marks key as pending flush.
+func (db *impl) markDirty(key fsdb.Key) {
+	db.dirtyLock.Lock()
+	defer db.dirtyLock.Unlock()
+	db.dirty[string(key)] = key
+}
+
+// flush copies the entry of key from the first tier into all the other tiers.
+func (db *impl) flush(ctx context.Context, key fsdb.Key) error {
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	db.dirtyLock.Lock()
+	_, ok := db.dirty[string(key)]
+	db.dirtyLock.Unlock()
+	if !ok {
+		// Already flushed or deleted.
+		return nil
+	}
+
+	for i := 1; i < len(db.tiers); i++ {
+		err := db.copy(ctx, key, 0, i)
+		if fsdb.IsNoSuchKeyError(err) {
+			// It's gone from the first tier (e.g. expired),
+			// nothing to flush.
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	db.dirtyLock.Lock()
+	delete(db.dirty, string(key))
+	db.dirtyLock.Unlock()
+	return nil
+}
+
+func (db *impl) startFlushLoop(ctx context.Context) {
+	logger := db.opts.GetLogger()
+	ticker := time.NewTicker(db.opts.GetFlushDelay())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			started := time.Now()
+			err := db.Flush(ctx)
+			if logger != nil {
+				if err != nil {
+					logger.Printf("flush failed: %v", err)
+				}
+				logger.Printf("flush took %v", time.Now().Sub(started))
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package layered_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/layered"
+	"github.com/fishy/fsdb/memory"
+)
+
+func TestConformance(t *testing.T) {
+	for _, policy := range []layered.WritePolicy{
+		layered.WriteThrough,
+		layered.WriteBack,
+	} {
+		policy := policy
+		t.Run(policy.String(), func(t *testing.T) {
+			fsdbtest.TestFSDB(t, func(t *testing.T) fsdb.FSDB {
+				db, _ := openLayered(t, 3, layered.NewDefaultOptions().SetWritePolicy(policy))
+				return db
+			})
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := layered.Open(
+		context.Background(),
+		nil,
+		layered.NewDefaultOptions(),
+	); err != layered.ErrNoTiers {
+		t.Errorf("Open without tiers expected ErrNoTiers, got %v", err)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	db, tiers := openLayered(t, 3, layered.NewDefaultOptions())
+	key := fsdb.Key("foo")
+
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	for i, tier := range tiers {
+		checkRead(t, tier, key, "foo", i)
+	}
+
+	if err := db.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for i, tier := range tiers {
+		checkNotExist(t, tier, key, i)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	ctx := context.Background()
+	db, tiers := openLayered(
+		t,
+		3,
+		layered.NewDefaultOptions().SetWritePolicy(layered.WriteBack),
+	)
+	key := fsdb.Key("foo")
+	deleted := fsdb.Key("bar")
+
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := db.Write(ctx, deleted, strings.NewReader("bar")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkRead(t, tiers[0], key, "foo", 0)
+	for i := 1; i < len(tiers); i++ {
+		checkNotExist(t, tiers[i], key, i)
+	}
+	if err := db.Delete(ctx, deleted); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if err := db.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	for i, tier := range tiers {
+		checkRead(t, tier, key, "foo", i)
+		checkNotExist(t, tier, deleted, i)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+
+	for _, promote := range []bool{true, false} {
+		db, tiers := openLayered(
+			t,
+			3,
+			layered.NewDefaultOptions().SetPromote(promote),
+		)
+		if err := tiers[2].Write(ctx, key, strings.NewReader("foo")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		checkRead(t, db, key, "foo", -1)
+		for i := 0; i < 2; i++ {
+			if promote {
+				checkRead(t, tiers[i], key, "foo", i)
+			} else {
+				checkNotExist(t, tiers[i], key, i)
+			}
+		}
+	}
+}
+
+func TestTierError(t *testing.T) {
+	ctx := context.Background()
+	injected := errors.New("injected")
+	key := fsdb.Key("foo")
+	tiers := []fsdb.FSDB{
+		memory.Open(memory.NewDefaultOptions()),
+		memory.Open(memory.NewDefaultOptions().SetHook(
+			func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+				if op == memory.OpWrite || op == memory.OpDelete {
+					return injected
+				}
+				return nil
+			},
+		)),
+		memory.Open(memory.NewDefaultOptions()),
+	}
+	db, err := layered.Open(ctx, tiers, layered.NewDefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	err = db.Write(ctx, key, strings.NewReader("foo"))
+	var te *layered.TierError
+	if !errors.As(err, &te) || te.Tier != 1 {
+		t.Errorf("Write expected TierError on tier 1, got %v", err)
+	}
+	if !errors.Is(err, injected) {
+		t.Errorf("Write expected to wrap injected error, got %v", err)
+	}
+
+	err = db.Delete(ctx, key)
+	if fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Delete should not return NoSuchKeyError, got %v", err)
+	}
+	if !errors.As(err, &te) || te.Tier != 1 {
+		t.Errorf("Delete expected TierError on tier 1, got %v", err)
+	}
+}
+
+func TestDeleteConcurrently(t *testing.T) {
+	for _, concurrent := range []bool{true, false} {
+		concurrent := concurrent
+		t.Run(fmt.Sprintf("%v", concurrent), func(t *testing.T) {
+			ctx := context.Background()
+			db, tiers := openLayered(
+				t,
+				3,
+				layered.NewDefaultOptions().SetDeleteConcurrently(concurrent),
+			)
+			key := fsdb.Key("foo")
+
+			if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			// Remove the entry from the middle tier only.
+			if err := tiers[1].Delete(ctx, key); err != nil {
+				t.Fatalf("Delete on tier 1 failed: %v", err)
+			}
+			if err := db.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			for i, tier := range tiers {
+				checkNotExist(t, tier, key, i)
+			}
+			if err := db.Delete(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+				t.Errorf("Delete again expected NoSuchKeyError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteSequentiallyError(t *testing.T) {
+	ctx := context.Background()
+	injected := errors.New("injected")
+	key := fsdb.Key("foo")
+	tiers := []fsdb.FSDB{
+		memory.Open(memory.NewDefaultOptions()),
+		memory.Open(memory.NewDefaultOptions().SetHook(
+			func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+				if op == memory.OpDelete {
+					return injected
+				}
+				return nil
+			},
+		)),
+		memory.Open(memory.NewDefaultOptions()),
+	}
+	db, err := layered.Open(
+		ctx,
+		tiers,
+		layered.NewDefaultOptions().SetDeleteConcurrently(false),
+	)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	err = db.Delete(ctx, key)
+	var te *layered.TierError
+	if !errors.As(err, &te) || te.Tier != 1 {
+		t.Errorf("Delete expected TierError on tier 1, got %v", err)
+	}
+	if !errors.Is(err, injected) {
+		t.Errorf("Delete expected to wrap injected error, got %v", err)
+	}
+	checkRead(t, tiers[0], key, "foo", 0)
+	checkRead(t, tiers[1], key, "foo", 1)
+	checkNotExist(t, tiers[2], key, 2)
+	checkRead(t, db, key, "foo", -1)
+}
+
+func openLayered(
+	t *testing.T,
+	n int,
+	opts layered.Options,
+) (layered.FSDB, []fsdb.FSDB) {
+	t.Helper()
+
+	tiers := make([]fsdb.FSDB, n)
+	for i := range tiers {
+		tiers[i] = memory.Open(memory.NewDefaultOptions())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	db, err := layered.Open(ctx, tiers, opts)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return db, tiers
+}
+
+// checkRead checks the content of key in db.
+//
+// tier is only used in the error messages, -1 means the layered FSDB.
+func checkRead(t *testing.T, db fsdb.FSDB, key fsdb.Key, expected string, tier int) {
+	t.Helper()
+
+	reader, err := db.Read(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Read %v on tier %d failed: %v", key, tier, err)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read %v content on tier %d failed: %v", key, tier, err)
+	}
+	if string(actual) != expected {
+		t.Errorf("Read %v on tier %d expected %q, got %q", key, tier, expected, actual)
+	}
+}
+
+func checkNotExist(t *testing.T, db fsdb.FSDB, key fsdb.Key, tier int) {
+	t.Helper()
+
+	if _, err := db.Read(context.Background(), key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read %v on tier %d expected NoSuchKeyError, got %v", key, tier, err)
+	}
+}
@@ -0,0 +1,175 @@
+package layered
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// WritePolicy defines how writes are propagated to the tiers.
+type WritePolicy int
+
+// WritePolicy values.
+const (
+	// WriteThrough writes to all the tiers before Write returns.
+	WriteThrough WritePolicy = iota + 1
+
+	// WriteBack only writes to the first tier before Write returns,
+	// the other tiers are written by a background flush loop later.
+	WriteBack
+)
+
+func (p WritePolicy) String() string {
+	switch p {
+	default:
+		return fmt.Sprintf("WritePolicy(%d)", int(p))
+	case WriteThrough:
+		return "write-through"
+	case WriteBack:
+		return "write-back"
+	}
+}
+
+// Default options values.
+const (
+	DefaultWritePolicy                      = WriteThrough
+	DefaultPromote                          = true
+	DefaultFlushDelay         time.Duration = time.Minute
+	DefaultFlushThreadNum                   = 5
+	DefaultDeleteConcurrently               = true
+)
+
+// Options defines a read-only view of options used in layered FSDB.
+type Options interface {
+	// GetWritePolicy returns the write policy.
+	GetWritePolicy() WritePolicy
+
+	// GetPromote returns whether entries read from a lower tier should be
+	// copied into all the tiers above it.
+	GetPromote() bool
+
+	// GetFlushDelay returns the delay between two flush loops.
+	//
+	// It's only used with WriteBack policy.
+	GetFlushDelay() time.Duration
+
+	// GetFlushThreadNum returns the number of threads used in flush loops.
+	GetFlushThreadNum() int
+
+	// GetDeleteConcurrently returns whether deletes should be sent to all the
+	// tiers concurrently.
+	//
+	// If it returns false, deletes go through the tiers one by one, from the
+	// last tier up to the first tier, and stop at the first failed tier.
+	GetDeleteConcurrently() bool
+
+	// GetLogger returns the logger to be used in layered FSDB.
+	//
+	// If it returns nil, nothing will be logged.
+	GetLogger() *log.Logger
+}
+
+// OptionsBuilder defines a read write view of options used in layered FSDB.
+type OptionsBuilder interface {
+	Options
+
+	// Build builds the read-only view of the options.
+	Build() Options
+
+	// SetWritePolicy sets the write policy.
+	SetWritePolicy(policy WritePolicy) OptionsBuilder
+
+	// SetPromote sets whether to promote entries read from lower tiers.
+	SetPromote(promote bool) OptionsBuilder
+
+	// SetFlushDelay sets the delay between two flush loops.
+	SetFlushDelay(delay time.Duration) OptionsBuilder
+
+	// SetFlushThreadNum sets the number of threads used in flush loops.
+	SetFlushThreadNum(threads int) OptionsBuilder
+
+	// SetDeleteConcurrently sets whether to delete from the tiers concurrently.
+	SetDeleteConcurrently(concurrent bool) OptionsBuilder
+
+	// SetLogger sets the logger used in layered FSDB.
+	SetLogger(logger *log.Logger) OptionsBuilder
+}
+
+type options struct {
+	policy           WritePolicy
+	promote          bool
+	delay            time.Duration
+	threads          int
+	concurrentDelete bool
+	logger           *log.Logger
+}
+
+// NewDefaultOptions creates the default options.
+func NewDefaultOptions() OptionsBuilder {
+	return &options{
+		policy:           DefaultWritePolicy,
+		promote:          DefaultPromote,
+		delay:            DefaultFlushDelay,
+		threads:          DefaultFlushThreadNum,
+		concurrentDelete: DefaultDeleteConcurrently,
+		logger:           nil,
+	}
+}
+
+func (opt *options) GetWritePolicy() WritePolicy {
+	return opt.policy
+}
+
+func (opt *options) GetPromote() bool {
+	return opt.promote
+}
+
+func (opt *options) GetFlushDelay() time.Duration {
+	return opt.delay
+}
+
+func (opt *options) GetFlushThreadNum() int {
+	return opt.threads
+}
+
+func (opt *options) GetDeleteConcurrently() bool {
+	return opt.concurrentDelete
+}
+
+func (opt *options) GetLogger() *log.Logger {
+	return opt.logger
+}
+
+func (opt *options) Build() Options {
+	return opt
+}
+
+func (opt *options) SetWritePolicy(policy WritePolicy) OptionsBuilder {
+	opt.policy = policy
+	return opt
+}
+
+func (opt *options) SetPromote(promote bool) OptionsBuilder {
+	opt.promote = promote
+	return opt
+}
+
+func (opt *options) SetFlushDelay(delay time.Duration) OptionsBuilder {
+	opt.delay = delay
+	return opt
+}
+
+func (opt *options) SetFlushThreadNum(threads int) OptionsBuilder {
+	opt.threads = threads
+	return opt
+}
+
+func (opt *options) SetDeleteConcurrently(concurrent bool) OptionsBuilder {
+	opt.concurrentDelete = concurrent
+	return opt
+}
+
+func (opt *options) SetLogger(logger *log.Logger) OptionsBuilder {
+	opt.logger = logger
+	return opt
+}
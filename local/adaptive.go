@@ -0,0 +1,153 @@
+package local
+
+import (
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// adaptiveWriter buffers a sample of the data in memory,
+// and only creates the data file once the sample is full (or on Close),
+// with the codec if the sample compresses well enough,
+// or uncompressed otherwise.
+type adaptiveWriter struct {
+	db      *impl
+	key     fsdb.Key
+	tmpdir  string
+	codec   Codec
+	encrypt bool
+
+	sample     []byte
+	sampleSize int
+
+	decided bool
+	name    string
+	writer  io.WriteCloser
+	err     error
+}
+
+func (db *impl) newAdaptiveWriter(
+	key fsdb.Key,
+	tmpdir string,
+	codec Codec,
+	encrypt bool,
+) *adaptiveWriter {
+	sampleSize := db.opts.GetCompressionSampleSize()
+	if sampleSize <= 0 {
+		sampleSize = DefaultCompressionSampleSize
+	}
+	return &adaptiveWriter{
+		db:         db,
+		key:        key,
+		tmpdir:     tmpdir,
+		codec:      codec,
+		encrypt:    encrypt,
+		sampleSize: sampleSize,
+	}
+}
+
+func (w *adaptiveWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.err != nil {
+			return 0, w.err
+		}
+		return w.writer.Write(p)
+	}
+
+	n := w.sampleSize - len(w.sample)
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > 0 {
+		w.sample = append(w.sample, p[:n]...)
+	}
+	if len(w.sample) < w.sampleSize {
+		return n, nil
+	}
+	if err := w.decide(); err != nil {
+		return n, err
+	}
+	m, err := w.writer.Write(p[n:])
+	return n + m, err
+}
+
+func (w *adaptiveWriter) Close() error {
+	if !w.decided {
+		w.decide()
+	}
+	if w.writer == nil {
+		return w.err
+	}
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+	return w.err
+}
+
+// filename returns the name of the data file.
+//
+// It's only final after Close.
+func (w *adaptiveWriter) filename() string {
+	return w.name
+}
+
+// decide creates the data file based on the compression ratio of the sample,
+// and writes the sample into it.
+func (w *adaptiveWriter) decide() error {
+	w.decided = true
+	codec := w.codec
+	ratio, err := compressionRatio(codec, w.sample)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if ratio < w.db.opts.GetMinCompressionRatio() {
+		codec = nil
+	}
+	w.name, w.writer, w.err = w.db.createDataAs(w.key, w.tmpdir, codec, w.encrypt)
+	if w.err != nil {
+		return w.err
+	}
+	sample := w.sample
+	w.sample = nil
+	if _, w.err = w.writer.Write(sample); w.err != nil {
+		return w.err
+	}
+	return nil
+}
+
+// compressionRatio compresses sample by codec,
+// and returns the uncompressed size divided by the compressed size.
+//
+// It returns 0 for empty sample.
+func compressionRatio(codec Codec, sample []byte) (float64, error) {
+	if len(sample) == 0 {
+		return 0, nil
+	}
+	counter := new(countingWriter)
+	writer, err := codec.NewWriter(counter)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(sample); err != nil {
+		writer.Close()
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	if counter.n == 0 {
+		return 0, nil
+	}
+	return float64(len(sample)) / float64(counter.n), nil
+}
+
+// countingWriter discards the data written, only counts the bytes.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
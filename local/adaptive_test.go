@@ -0,0 +1,158 @@
+package local_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestAdaptiveCompression(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	const sampleSize = 1024
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 3*sampleSize)
+	r.Read(random)
+	// Compressible after the sample, but the sample decides.
+	randomHead := string(random[:sampleSize]) + strings.Repeat(lorem, 10)
+	// Incompressible after the sample, but the sample decides.
+	loremHead := strings.Repeat(lorem, 3)[:sampleSize] + string(random)
+
+	for _, c := range []struct {
+		label   string
+		content string
+		codec   string
+	}{
+		{"empty", "", fsdb.CodecPlain},
+		// Too short for gzip header and trailer to pay off.
+		{"tiny-lorem", lorem[:20], fsdb.CodecPlain},
+		{"short-lorem", lorem, fsdb.CodecGzip},
+		{"short-random", string(random[:100]), fsdb.CodecPlain},
+		{"lorem", strings.Repeat(lorem, 10), fsdb.CodecGzip},
+		{"random", string(random), fsdb.CodecPlain},
+		{"random-head", randomHead, fsdb.CodecPlain},
+		{"lorem-head", loremHead, fsdb.CodecGzip},
+	} {
+		for _, encrypt := range []bool{false, true} {
+			label := c.label
+			if encrypt {
+				label += "-encrypted"
+			}
+			t.Run(label, func(t *testing.T) {
+				opts := local.NewDefaultOptions(root).
+					SetUseGzip(true).
+					SetAdaptiveCompression(true).
+					SetCompressionSampleSize(sampleSize).
+					SetUseEncryption(encrypt).
+					SetKeyProvider(testKeys("key1"))
+				db := local.Open(opts)
+				key := fsdb.Key(label)
+
+				testWrite(t, db, key, c.content)
+				testRead(t, db, key, c.content)
+				info, err := db.(fsdb.Stater).Stat(ctx, key)
+				if err != nil {
+					t.Fatalf("Stat failed: %v", err)
+				}
+				if info.Codec != c.codec {
+					t.Errorf("Codec expected %q, got %q", c.codec, info.Codec)
+				}
+				if info.Encrypted != encrypt {
+					t.Errorf("Encrypted expected %v, got %v", encrypt, info.Encrypted)
+				}
+				if info.Size != int64(len(c.content)) {
+					t.Errorf("Size expected %d, got %d", len(c.content), info.Size)
+				}
+
+				// Same result via streaming writes in small pieces.
+				key = append(key, "-stream"...)
+				writer, err := fsdb.OpenWriter(ctx, db, key)
+				if err != nil {
+					t.Fatalf("OpenWriter failed: %v", err)
+				}
+				defer writer.Abort()
+				for s := c.content; len(s) > 0; {
+					n := 100
+					if n > len(s) {
+						n = len(s)
+					}
+					if _, err := io.WriteString(writer, s[:n]); err != nil {
+						t.Fatalf("Write failed: %v", err)
+					}
+					s = s[n:]
+				}
+				if err := writer.Commit(); err != nil {
+					t.Fatalf("Commit failed: %v", err)
+				}
+				testRead(t, db, key, c.content)
+				info, err = db.(fsdb.Stater).Stat(ctx, key)
+				if err != nil {
+					t.Fatalf("Stat failed: %v", err)
+				}
+				if info.Codec != c.codec {
+					t.Errorf("Streaming codec expected %q, got %q", c.codec, info.Codec)
+				}
+			})
+		}
+	}
+}
+
+func TestAdaptiveCompressionRatio(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	opts := local.NewDefaultOptions(root).
+		SetCodec(local.NewZlibCodec(-1)).
+		SetAdaptiveCompression(true)
+	db := local.Open(opts)
+	content := strings.Repeat(lorem, 10)
+	key := fsdb.Key("foo")
+
+	for _, c := range []struct {
+		ratio float64
+		codec string
+	}{
+		{local.DefaultMinCompressionRatio, fsdb.CodecZlib},
+		// Nothing compresses that well.
+		{1000, fsdb.CodecPlain},
+		{1, fsdb.CodecZlib},
+	} {
+		opts.SetMinCompressionRatio(c.ratio)
+		testWrite(t, db, key, content)
+		testRead(t, db, key, content)
+		info, err := db.(fsdb.Stater).Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Codec != c.codec {
+			t.Errorf("Ratio %v: codec expected %q, got %q", c.ratio, c.codec, info.Codec)
+		}
+	}
+
+	// Without codec adaptive compression does nothing.
+	opts.SetCodec(nil).SetMinCompressionRatio(1)
+	testWrite(t, db, key, content)
+	info, err := db.(fsdb.Stater).Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Codec != fsdb.CodecPlain {
+		t.Errorf("Codec expected %q, got %q", fsdb.CodecPlain, info.Codec)
+	}
+}
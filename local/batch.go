@@ -0,0 +1,70 @@
+package local
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Batcher interface.
+var _ fsdb.Batcher = (*impl)(nil)
+
+func (db *impl) ReadMany(ctx context.Context, keys []fsdb.Key) []fsdb.ReadResult {
+	results := make([]fsdb.ReadResult, len(keys))
+	errs := fsdb.BatchDo(
+		ctx,
+		len(keys),
+		db.opts.GetBatchThreadNum(),
+		func(i int) (err error) {
+			results[i].Reader, err = db.Read(ctx, keys[i])
+			return err
+		},
+	)
+	for i, err := range errs {
+		results[i].Err = err
+	}
+	return results
+}
+
+// WriteMany writes multiple entries.
+//
+// All the entries share a single temporary directory,
+// each of them uses a subdirectory under it.
+func (db *impl) WriteMany(ctx context.Context, entries []fsdb.WriteEntry) []error {
+	tmpdir, err := db.getTempDir()
+	if err != nil {
+		errs := make([]error, len(entries))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer os.RemoveAll(tmpdir)
+
+	return fsdb.BatchDo(
+		ctx,
+		len(entries),
+		db.opts.GetBatchThreadNum(),
+		func(i int) error {
+			subdir := tmpdir + strconv.Itoa(i) + PathSeparator
+			if err := os.Mkdir(subdir, tempDirMode); err != nil {
+				return err
+			}
+			defer os.RemoveAll(subdir)
+			return db.writeIn(ctx, entries[i].Key, entries[i].Data, nil, subdir)
+		},
+	)
+}
+
+func (db *impl) DeleteMany(ctx context.Context, keys []fsdb.Key) []error {
+	return fsdb.BatchDo(
+		ctx,
+		len(keys),
+		db.opts.GetBatchThreadNum(),
+		func(i int) error {
+			return db.Delete(ctx, keys[i])
+		},
+	)
+}
@@ -0,0 +1,248 @@
+package local
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *ChecksumMismatchError satisfies error interface.
+var _ error = (*ChecksumMismatchError)(nil)
+
+// Make sure *checksumReader satisfies fsdb.Versioned interface.
+var _ fsdb.Versioned = (*checksumReader)(nil)
+
+// ChecksumMismatchError is the underlying error of the fsdb.CorruptEntryError
+// returned by Read when the data read does not match the checksum stored with
+// the entry.
+type ChecksumMismatchError struct {
+	// Expected is the hex encoded checksum stored with the entry.
+	Expected string
+
+	// Actual is the hex encoded checksum of the data read.
+	Actual string
+}
+
+func (err *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"checksum mismatch: expected %s, got %s",
+		err.Expected,
+		err.Actual,
+	)
+}
+
+// IsChecksumMismatchError checks whether a given error is
+// ChecksumMismatchError, or wraps one.
+func IsChecksumMismatchError(err error) bool {
+	var target *ChecksumMismatchError
+	return errors.As(err, &target)
+}
+
+// checksumWriter calculates the checksum and the size of the data written
+// through it.
+type checksumWriter struct {
+	io.WriteCloser
+
+	hash hash.Hash
+	n    int64
+}
+
+func newChecksumWriter(w io.WriteCloser) *checksumWriter {
+	return &checksumWriter{
+		WriteCloser: w,
+		hash:        sha256.New(),
+	}
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.hash.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// checksum returns the hex encoded checksum of the data written so far.
+func (w *checksumWriter) checksum() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// size returns the size of the data written so far.
+func (w *checksumWriter) size() int64 {
+	return w.n
+}
+
+// checksumVerifier verifies the checksum of the data read sequentially from
+// the beginning.
+//
+// All its functions are safe to be called on nil verifier,
+// which verifies nothing.
+type checksumVerifier struct {
+	key      fsdb.Key
+	expected string
+	hash     hash.Hash
+
+	// raced is called on mismatch to rule out concurrent overwrites.
+	raced func(actual string) bool
+
+	// valid is false after seeking away from the beginning.
+	valid bool
+	done  bool
+	err   error
+}
+
+// newChecksumVerifier returns the verifier for the data file with the given
+// name opened as file, or nil if the entry has no checksum.
+func newChecksumVerifier(
+	key fsdb.Key,
+	dir, name string,
+	file *os.File,
+	info *entryInfo,
+) *checksumVerifier {
+	if info.Checksum == "" {
+		return nil
+	}
+	return &checksumVerifier{
+		key:      key,
+		expected: info.Checksum,
+		hash:     sha256.New(),
+		valid:    true,
+		raced: func(actual string) bool {
+			return overwritten(dir, name, file, actual)
+		},
+	}
+}
+
+func (v *checksumVerifier) update(p []byte) {
+	if v != nil && v.valid && !v.done {
+		v.hash.Write(p)
+	}
+}
+
+// reset is called after seeking to offset.
+func (v *checksumVerifier) reset(offset int64) {
+	if v == nil {
+		return
+	}
+	v.hash.Reset()
+	v.valid = offset == 0
+	v.done = false
+	v.err = nil
+}
+
+// verify is called when the reader reaches EOF.
+//
+// It returns a CorruptEntryError wrapping ChecksumMismatchError on mismatch.
+func (v *checksumVerifier) verify() error {
+	if v == nil || !v.valid || v.done {
+		return v.result()
+	}
+	v.done = true
+	actual := hex.EncodeToString(v.hash.Sum(nil))
+	if actual != v.expected && !v.raced(actual) {
+		v.err = &fsdb.CorruptEntryError{
+			Key:    v.key,
+			Reason: "checksum mismatch",
+			Err: &ChecksumMismatchError{
+				Expected: v.expected,
+				Actual:   actual,
+			},
+		}
+	}
+	return v.err
+}
+
+func (v *checksumVerifier) result() error {
+	if v == nil {
+		return nil
+	}
+	return v.err
+}
+
+// withChecksum wraps the reader returned by readData to verify the checksum of
+// the entry when it reaches EOF.
+func (db *impl) withChecksum(
+	key fsdb.Key,
+	dir, name string,
+	reader io.ReadCloser,
+	info *entryInfo,
+) io.ReadCloser {
+	if !db.opts.GetVerifyChecksum() {
+		return reader
+	}
+	var file *os.File
+	switch r := reader.(type) {
+	case *seekableFile:
+		file = r.File
+	case *dataReader:
+		file = r.file
+	}
+	v := newChecksumVerifier(key, dir, name, file, info)
+	if v == nil {
+		return reader
+	}
+	if f, ok := reader.(*seekableFile); ok {
+		f.verifier = v
+		return f
+	}
+	return &checksumReader{
+		ReadCloser: reader,
+		verifier:   v,
+	}
+}
+
+// overwritten checks whether the data file opened was written after the info
+// used to verify it was read, which happens when Read races with a write.
+//
+// It returns true if the opened data file is no longer the current one,
+// or the current info has the matching checksum.
+func overwritten(dir, name string, file *os.File, actual string) bool {
+	// Wait for the in-flight commit, if any, to finish.
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if file == nil {
+		return false
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Lstat(dir + name)
+	if err != nil || !os.SameFile(opened, current) {
+		return true
+	}
+	info, err := loadInfo(dir)
+	if err != nil {
+		return false
+	}
+	return info.Checksum == actual
+}
+
+// checksumReader verifies the checksum when the underlying reader reaches EOF.
+type checksumReader struct {
+	io.ReadCloser
+
+	verifier *checksumVerifier
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.verifier.update(p[:n])
+	if err == io.EOF {
+		if verr := r.verifier.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (r *checksumReader) Generation() int64 {
+	return r.ReadCloser.(fsdb.Versioned).Generation()
+}
@@ -0,0 +1,163 @@
+package local_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestChecksum(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+	key := fsdb.Key("foo")
+	path := opts.GetDirForKey(key) + local.DataFilename
+
+	testWrite(t, db, key, lorem)
+	testRead(t, db, key, lorem)
+
+	// Flip a bit.
+	corrupted := []byte(lorem)
+	corrupted[10] ^= 1
+	if err := ioutil.WriteFile(path, corrupted, local.FileModeForFiles); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	readAll := func(reader io.Reader) ([]byte, error) {
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, reader)
+		return buf.Bytes(), err
+	}
+
+	reader, err := db.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	_, err = readAll(reader)
+	reader.Close()
+	if !fsdb.IsCorruptEntryError(err) || !local.IsChecksumMismatchError(err) {
+		t.Errorf("Read corrupted data expected checksum mismatch, got %v", err)
+	}
+
+	seekable, err := local.ReadSeekable(ctx, db, key)
+	if err != nil {
+		t.Fatalf("ReadSeekable failed: %v", err)
+	}
+	if _, err := readAll(seekable); !local.IsChecksumMismatchError(err) {
+		t.Errorf("ReadSeekable corrupted data expected checksum mismatch, got %v", err)
+	}
+	// Seeking away from the beginning disables the verification.
+	if _, err := seekable.Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if _, err := readAll(seekable); err != nil {
+		t.Errorf("Read after Seek expected no error, got %v", err)
+	}
+	// Seeking back to the beginning enables it again.
+	if _, err := seekable.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if _, err := ioutil.ReadAll(seekable); !local.IsChecksumMismatchError(err) {
+		t.Errorf("Read after Seek(0) expected checksum mismatch, got %v", err)
+	}
+	seekable.Close()
+
+	// Ranges are not verified.
+	reader, err = db.(fsdb.RangeReader).ReadRange(ctx, key, 0, -1)
+	if err != nil {
+		t.Fatalf("ReadRange failed: %v", err)
+	}
+	if _, err := readAll(reader); err != nil {
+		t.Errorf("ReadRange expected no error, got %v", err)
+	}
+	reader.Close()
+
+	// Disabling verification returns the corrupted data.
+	opts.SetVerifyChecksum(false)
+	testRead(t, db, key, string(corrupted))
+	opts.SetVerifyChecksum(true)
+
+	// Entries without checksums, e.g. written by older versions.
+	testWrite(t, db, key, lorem)
+	if err := os.Remove(opts.GetDirForKey(key) + local.InfoFilename); err != nil {
+		t.Fatalf("Remove info file failed: %v", err)
+	}
+	if err := ioutil.WriteFile(path, corrupted, local.FileModeForFiles); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	testRead(t, db, key, string(corrupted))
+
+	// Checksums are stored by all the write paths and formats.
+	opts.SetUseGzip(true)
+	testWrite(t, db, key, lorem)
+	testRead(t, db, key, lorem)
+	w, err := db.(fsdb.StreamWriter).OpenWriter(ctx, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	defer w.Abort()
+	if _, err := io.Copy(w, strings.NewReader(lorem)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+	opts.SetUseEncryption(true).SetKeyProvider(testKeys("key1"))
+	testWrite(t, db, key, lorem)
+	testRead(t, db, key, lorem)
+}
+
+func TestChecksumRace(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+
+	const n = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			content := fmt.Sprintf("%s%d", lorem, i)
+			if err := db.Write(ctx, key, strings.NewReader(content)); err != nil {
+				t.Errorf("Write failed: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			reader, err := db.Read(ctx, key)
+			if err != nil {
+				t.Errorf("Read failed: %v", err)
+				continue
+			}
+			if _, err := ioutil.ReadAll(reader); err != nil {
+				t.Errorf("Read failed: %v", err)
+			}
+			reader.Close()
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,289 @@
+package local
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *gzipCodec satisfies SizedCodec interface.
+var _ SizedCodec = (*gzipCodec)(nil)
+
+// Make sure *zlibCodec satisfies Codec interface.
+var _ Codec = (*zlibCodec)(nil)
+
+// Make sure *flateCodec satisfies Codec interface.
+var _ Codec = (*flateCodec)(nil)
+
+// Codec defines a compression codec of the data files.
+//
+// Codecs are identified by their data filename suffixes,
+// so data files written by any registered codec can be read,
+// regardless of the codec used for new data files.
+type Codec interface {
+	// Name returns the name of the codec,
+	// which is used in fsdb.EntryInfo.Codec.
+	Name() string
+
+	// Suffix returns the suffix of the data filenames,
+	// e.g. ".gz" for gzip.
+	//
+	// It must start with "." and must not be ".enc".
+	Suffix() string
+
+	// NewWriter returns a WriteCloser compressing the data into w.
+	//
+	// Closing the WriteCloser must flush all the data into w,
+	// but must not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a ReadCloser decompressing the data from r.
+	//
+	// The ReadCloser should report corruption of the data as errors,
+	// preferably at EOF at the latest.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// SizedCodec is an optional interface for Codecs that store the decompressed
+// size in a fixed size trailer,
+// so Stat doesn't need to decompress the whole data file.
+type SizedCodec interface {
+	Codec
+
+	// TrailerSize returns the size of the trailer in bytes.
+	TrailerSize() int
+
+	// DecompressedSize returns the decompressed size from the trailer.
+	DecompressedSize(trailer []byte) int64
+}
+
+// encryptedSuffix is the suffix of encrypted data filenames,
+// after the suffix of the codec.
+const encryptedSuffix = ".enc"
+
+var codecs = struct {
+	sync.RWMutex
+
+	bySuffix map[string]Codec
+
+	// filenames are all the possible data filenames with the registered
+	// codecs.
+	//
+	// It's replaced instead of modified on RegisterCodec.
+	filenames []string
+}{
+	bySuffix:  make(map[string]Codec),
+	filenames: []string{DataFilename, DataFilename + encryptedSuffix},
+}
+
+func init() {
+	RegisterCodec(NewGzipCodec(gzip.DefaultCompression))
+	RegisterCodec(NewZlibCodec(zlib.DefaultCompression))
+	RegisterCodec(NewFlateCodec(flate.DefaultCompression))
+}
+
+// RegisterCodec registers a codec, so data files written by it can be read.
+//
+// It's only needed for reading.
+// Codecs set via Options.SetCodec don't need to be registered to write,
+// but they need to be registered to be read back.
+//
+// It panics if the suffix is invalid,
+// or there's already a codec registered with the same suffix or name.
+// It's usually called in init functions.
+func RegisterCodec(codec Codec) {
+	suffix := codec.Suffix()
+	if !strings.HasPrefix(suffix, ".") ||
+		len(suffix) < 2 ||
+		suffix == encryptedSuffix ||
+		strings.Contains(suffix, PathSeparator) {
+		panic(fmt.Sprintf("local: invalid codec suffix %q", suffix))
+	}
+
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, c := range codecs.bySuffix {
+		if c.Suffix() == suffix || c.Name() == codec.Name() {
+			panic(fmt.Sprintf(
+				"local: codec %q (%q) registered twice",
+				codec.Name(),
+				suffix,
+			))
+		}
+	}
+	codecs.bySuffix[suffix] = codec
+	codecs.filenames = append(
+		append([]string(nil), codecs.filenames...),
+		dataFilename(codec, false),
+		dataFilename(codec, true),
+	)
+}
+
+// allDataFilenames returns all the possible data filenames with the registered
+// codecs.
+//
+// The slice returned must not be modified.
+func allDataFilenames() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.filenames
+}
+
+// Codecs returns all the registered codecs, sorted by name.
+func Codecs() []Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	list := make([]Codec, 0, len(codecs.bySuffix))
+	for _, c := range codecs.bySuffix {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// LookupCodec returns the registered codec with the given name,
+// or nil if there's none.
+func LookupCodec(name string) Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	for _, c := range codecs.bySuffix {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// codecForSuffix returns the registered codec with the given suffix,
+// or nil if there's none.
+func codecForSuffix(suffix string) Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.bySuffix[suffix]
+}
+
+// unknownCodec returns the error for a data file of an unregistered codec.
+func unknownCodec(key fsdb.Key, name string) error {
+	return &fsdb.CorruptEntryError{
+		Key:    key,
+		Reason: fmt.Sprintf("no registered codec for data file %q", name),
+	}
+}
+
+// codecName returns the name of codec, or fsdb.CodecPlain for nil codec.
+func codecName(codec Codec) string {
+	if codec == nil {
+		return fsdb.CodecPlain
+	}
+	return codec.Name()
+}
+
+// gzipCodec is the Codec of gzip.
+type gzipCodec struct {
+	level int
+}
+
+// NewGzipCodec creates a gzip Codec with the given compression level.
+//
+// Its data filename suffix is ".gz".
+func NewGzipCodec(level int) Codec {
+	return &gzipCodec{level: level}
+}
+
+func (c *gzipCodec) Name() string {
+	return fsdb.CodecGzip
+}
+
+func (c *gzipCodec) Suffix() string {
+	return ".gz"
+}
+
+func (c *gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, c.level)
+}
+
+func (c *gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// TrailerSize returns the size of the gzip trailer: CRC-32 and ISIZE.
+func (c *gzipCodec) TrailerSize() int {
+	return 8
+}
+
+// DecompressedSize returns ISIZE from the gzip trailer.
+//
+// The gzip trailer only stores the size modulo 2^32,
+// so the result is only accurate for data smaller than 4GiB.
+func (c *gzipCodec) DecompressedSize(trailer []byte) int64 {
+	return int64(binary.LittleEndian.Uint32(trailer[4:]))
+}
+
+// zlibCodec is the Codec of zlib.
+type zlibCodec struct {
+	level int
+}
+
+// NewZlibCodec creates a zlib Codec with the given compression level.
+//
+// Its data filename suffix is ".zz".
+func NewZlibCodec(level int) Codec {
+	return &zlibCodec{level: level}
+}
+
+func (c *zlibCodec) Name() string {
+	return fsdb.CodecZlib
+}
+
+func (c *zlibCodec) Suffix() string {
+	return ".zz"
+}
+
+func (c *zlibCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, c.level)
+}
+
+func (c *zlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// flateCodec is the Codec of raw DEFLATE.
+type flateCodec struct {
+	level int
+}
+
+// NewFlateCodec creates a raw DEFLATE Codec with the given compression level.
+//
+// Raw DEFLATE has no checksum of its own,
+// so corruption is only caught by the checksum of the entry.
+//
+// Its data filename suffix is ".deflate".
+func NewFlateCodec(level int) Codec {
+	return &flateCodec{level: level}
+}
+
+func (c *flateCodec) Name() string {
+	return fsdb.CodecFlate
+}
+
+func (c *flateCodec) Suffix() string {
+	return ".deflate"
+}
+
+func (c *flateCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, c.level)
+}
+
+func (c *flateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
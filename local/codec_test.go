@@ -0,0 +1,292 @@
+package local_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/local"
+)
+
+// xorCodec is a toy Codec for tests, which xors every byte with mask.
+type xorCodec struct {
+	name   string
+	suffix string
+	mask   byte
+}
+
+func (c xorCodec) Name() string {
+	return c.name
+}
+
+func (c xorCodec) Suffix() string {
+	return c.suffix
+}
+
+func (c xorCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xorWriter{w: w, mask: c.mask}, nil
+}
+
+func (c xorCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(xorReader{r: r, mask: c.mask}), nil
+}
+
+type xorWriter struct {
+	w    io.Writer
+	mask byte
+}
+
+func (w xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = b ^ w.mask
+	}
+	return w.w.Write(buf)
+}
+
+func (w xorWriter) Close() error {
+	return nil
+}
+
+type xorReader struct {
+	r    io.Reader
+	mask byte
+}
+
+func (r xorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= r.mask
+	}
+	return n, err
+}
+
+var registeredXor = xorCodec{name: "xor", suffix: ".xor", mask: 0x5a}
+
+func init() {
+	local.RegisterCodec(registeredXor)
+}
+
+func TestCodecConformance(t *testing.T) {
+	for _, codec := range local.Codecs() {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) {
+			fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+				root, err := ioutil.TempDir("", "fsdb_")
+				if err != nil {
+					t.Fatalf("failed to get tmp dir: %v", err)
+				}
+				t.Cleanup(func() {
+					os.RemoveAll(root)
+				})
+				return local.Open(local.NewDefaultOptions(root).SetCodec(codec))
+			})
+		})
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root).SetKeyProvider(testKeys("key1"))
+	db := local.Open(opts)
+
+	var names []string
+	for _, codec := range local.Codecs() {
+		names = append(names, codec.Name())
+	}
+	expected := []string{fsdb.CodecFlate, fsdb.CodecGzip, "xor", fsdb.CodecZlib}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Codecs expected %v, got %v", expected, names)
+	}
+	if local.LookupCodec(fsdb.CodecZlib) == nil {
+		t.Errorf("LookupCodec(%q) returned nil", fsdb.CodecZlib)
+	}
+	if local.LookupCodec("zstd") != nil {
+		t.Errorf("LookupCodec(%q) should return nil", "zstd")
+	}
+
+	content := strings.Repeat(lorem, 10)
+	var keys []fsdb.Key
+	for _, codec := range append(local.Codecs(), nil) {
+		for _, encrypt := range []bool{false, true} {
+			opts.SetCodec(codec).SetUseEncryption(encrypt)
+			name := local.DataFilename
+			codecName := fsdb.CodecPlain
+			if codec != nil {
+				name += codec.Suffix()
+				codecName = codec.Name()
+			}
+			if encrypt {
+				name += ".enc"
+			}
+			key := fsdb.Key(name)
+			keys = append(keys, key)
+			testWrite(t, db, key, content)
+			if _, err := os.Lstat(opts.GetDirForKey(key) + name); err != nil {
+				t.Errorf("Expected data file %q, got %v", name, err)
+			}
+
+			info, err := db.(fsdb.Stater).Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("%s: Stat failed: %v", name, err)
+			}
+			if info.Codec != codecName || info.Encrypted != encrypt {
+				t.Errorf("%s: unexpected Stat: %+v", name, info)
+			}
+			if info.Size != int64(len(content)) {
+				t.Errorf("%s: Stat size expected %d, got %d", name, len(content), info.Size)
+			}
+
+			reader, err := db.(fsdb.RangeReader).ReadRange(ctx, key, 10, 20)
+			if err != nil {
+				t.Fatalf("%s: ReadRange failed: %v", name, err)
+			}
+			actual, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("%s: ReadRange failed: %v", name, err)
+			}
+			if string(actual) != content[10:30] {
+				t.Errorf("%s: ReadRange expected %q, got %q", name, content[10:30], actual)
+			}
+		}
+	}
+
+	// All of them are readable regardless of the current codec.
+	for _, codec := range append(local.Codecs(), nil) {
+		opts.SetCodec(codec)
+		for _, key := range keys {
+			testRead(t, db, key, content)
+		}
+	}
+
+	// Overwriting with a different codec leaves no stale data files.
+	opts.SetCodec(nil).SetUseEncryption(false)
+	key := fsdb.Key(local.GzipDataFilename)
+	testWrite(t, db, key, lorem)
+	files, err := ioutil.ReadDir(opts.GetDirForKey(key))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), local.DataFilename) &&
+			file.Name() != local.DataFilename {
+			t.Errorf("Stale data file %q left", file.Name())
+		}
+	}
+	testRead(t, db, key, lorem)
+}
+
+func TestUnregisteredCodec(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	codec := xorCodec{name: "unregistered", suffix: ".unregistered", mask: 0xa5}
+	db := local.Open(local.NewDefaultOptions(root).SetCodec(codec))
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+	testRead(t, db, key, lorem)
+
+	other := local.Open(local.NewDefaultOptions(root))
+	if _, err := other.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) &&
+		!fsdb.IsCorruptEntryError(err) {
+		t.Errorf("Read of unregistered codec expected error, got %v", err)
+	}
+	// Overwriting it uncompressed doesn't leave the old data file behind.
+	opts := local.NewDefaultOptions(root).
+		SetCodec(codec).
+		SetAdaptiveCompression(true)
+	db = local.Open(opts)
+	testWrite(t, db, key, lorem)
+	testRead(t, db, key, lorem)
+	dir := opts.GetDirForKey(key)
+	if _, err := os.Lstat(dir + local.DataFilename); err != nil {
+		t.Errorf("Expected uncompressed data file: %v", err)
+	}
+	if _, err := os.Lstat(dir + local.DataFilename + codec.suffix); !os.IsNotExist(err) {
+		t.Errorf("Expected the old data file to be removed, got %v", err)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	expectPanic := func(label string, codec local.Codec) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: RegisterCodec should panic", label)
+			}
+		}()
+		local.RegisterCodec(codec)
+	}
+	expectPanic("duplicate", registeredXor)
+	expectPanic("duplicate-name", xorCodec{name: "xor", suffix: ".xor2"})
+	expectPanic("duplicate-suffix", xorCodec{name: "gz", suffix: ".gz"})
+	expectPanic("no-dot", xorCodec{name: "nodot", suffix: "nodot"})
+	expectPanic("dot-only", xorCodec{name: "dot", suffix: "."})
+	expectPanic("enc", xorCodec{name: "enc", suffix: ".enc"})
+}
+
+func TestCodecOptions(t *testing.T) {
+	opts := local.NewDefaultOptions("/foobar")
+	if opts.GetCodec() != nil || opts.GetUseGzip() {
+		t.Errorf("Default codec should be nil, got %v", opts.GetCodec())
+	}
+
+	opts.SetUseGzip(true)
+	if codec := opts.GetCodec(); codec == nil || codec.Name() != fsdb.CodecGzip {
+		t.Errorf("SetUseGzip(true) should set gzip codec, got %v", codec)
+	}
+
+	// SetGzipLevel replaces the gzip codec.
+	opts.SetGzipLevel(gzip.BestCompression)
+	var buf bytes.Buffer
+	w, err := opts.GetCodec().NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	io.WriteString(w, strings.Repeat(lorem, 10))
+	w.Close()
+	best := buf.Len()
+	opts.SetGzipLevel(gzip.NoCompression)
+	buf.Reset()
+	w, _ = opts.GetCodec().NewWriter(&buf)
+	io.WriteString(w, strings.Repeat(lorem, 10))
+	w.Close()
+	if buf.Len() <= best {
+		t.Errorf(
+			"NoCompression size %d should be larger than BestCompression size %d",
+			buf.Len(),
+			best,
+		)
+	}
+
+	opts.SetCodec(local.NewZlibCodec(gzip.DefaultCompression))
+	if opts.GetUseGzip() {
+		t.Error("GetUseGzip should be false for zlib codec")
+	}
+	// SetGzipLevel doesn't change non-gzip codec.
+	opts.SetGzipLevel(gzip.BestSpeed)
+	if name := opts.GetCodec().Name(); name != fsdb.CodecZlib {
+		t.Errorf("SetGzipLevel should not change zlib codec, got %q", name)
+	}
+	opts.SetUseGzip(false)
+	if opts.GetCodec() != nil {
+		t.Errorf("SetUseGzip(false) should unset codec, got %v", opts.GetCodec())
+	}
+}
@@ -10,26 +10,69 @@
 //     6cb1b0e50d74419e2244eaa7328235f71b48c7e1c33b23f6f9517d14
 // and the files will be stored under:
 //     <fsdb-root>/
+//       manifest // Manifest file: hash function, directory levels, etc.
 //       data/
 //         6c/
 //           b1/
 //             b0/
 //               e50d74419e2244eaa7328235f71b48c7e1c33b23f6f9517d14/
-//                 key     // Key file
-//                 data    // Data file if no compression
-//                 data.gz // Data file if gzip enabled
+//                 key         // Key file
+//                 info        // Info file: generation, metadata, checksum, etc.
+//                 data        // Data file if no compression
+//                 data.gz     // Data file if gzip codec is used
+//                 data.enc    // Data file if encryption enabled
+//                 data.gz.enc // Data file if both enabled
+// Other codecs use their own suffixes in place of ".gz",
+// e.g. data.zz for zlib and data.deflate for raw DEFLATE.
 //
 // There could also be temporary files for unfinished write operations under
 //     <fsdb-root>/_tmp/fsdb_<tmpdir>/
 //
 // Both hash function and directory levels are configurable.
 //
+// Manifest
+//
+// The layout related options (data and temporary directories,
+// hash function, and directory levels) are recorded in the manifest file
+// under the root directory on the first write to a new FSDB system.
+// Open validates the options against it,
+// so opening an existing FSDB system with wrong options fails all the
+// operations with ManifestMismatchError,
+// instead of reporting every key as not exist.
+// OpenExisting and LoadOptions reconstruct the options from the manifest,
+// as long as the hash function is registered via RegisterHashFunc
+// (the ones from the standard library are registered by default).
+//
+// FSDB systems created by older versions don't have manifests,
+// use WriteManifest to add one.
+//
+// Layout Migration
+//
+// To change the hash function, directory levels, or data directory of an
+// existing FSDB system,
+// open it with the new options and the old ones set via
+// OptionsBuilder.SetMigrateFrom.
+// Entries still in the old layout are moved into the new layout by renaming
+// their directories the first time they are accessed,
+// so reads and writes work on either layout during the migration.
+// Migrate moves all the remaining entries,
+// and can be run again to resume after being interrupted.
+// ScanKeys during the migration covers both layouts,
+// and could report the same key twice.
+// The migration is recorded in the manifest until Migrate finishes,
+// so OpenExisting resumes it with the right options,
+// and opening with either layout alone fails.
+//
 // Atomicity
 //
-// There's no extra locks in the implementation.
 // The atomicity relies on the atomicity guaranteed by your filesystem on
 // operations like move (rename), delete, open, etc.
 //
+// The only lock in the implementation is an in-process striped lock,
+// which is only held while moving files from the temporary directory into the
+// entry directory, and while deleting an entry directory.
+// It makes conditional writes atomic within the same process.
+//
 // Read Before Overwriting Finishes on the Same Key
 //
 // If you issue a read operation before an overwrite operation (write operation
@@ -44,23 +87,150 @@
 //     2. Write key-value data onto temporary directory
 //     3. Move new key-value data from temporary directory to actual directory
 //     4. Delete old value(s), if any
+//     5. Move new info file from temporary directory to actual directory
 //
 // Read operations issued before Step 3 will get the old data.
 // Read operations issued after Step 3 will get the new data.
+// The generation reported by Stat and Read could still be the old one before
+// Step 5, so a conditional write based on it fails instead of overwriting data
+// it never saw.
 //
 // Two Write Operations on the Same Key
 //
 // If you issue a write operation before another write operation on the same key
 // finishes, the one that finishes first will be overwritten by the other.
 //
+// To avoid that, use WriteIf or WriteIfNotExists from fsdb.ConditionalWriter.
+// Every write gives the entry a new generation, based on the current time.
+// The generation can be get from Stat, or from the reader returned by Read via
+// fsdb.Versioned interface.
+// For entries written by older versions without info files,
+// the modification time of the key file is used as the generation.
+//
 // Compression
 //
-// This implementation supports optional gzip compression with configurable
-// compression levels.
+// This implementation supports optional compression via Codec,
+// set by Options.SetCodec.
+// gzip, zlib and raw DEFLATE codecs are built-in with configurable
+// compression levels,
+// and Options.SetUseGzip and Options.SetGzipLevel are shortcuts for the gzip
+// codec.
+// Other codecs can be added via RegisterCodec.
 //
+// The codec of a data file is identified by its filename suffix.
 // If you changed the compression option on a non-empty local fsdb,
-// the old data is still readable and the new data will be stored per new
-// compression option.
+// the old data is still readable as long as its codec is registered,
+// and the new data will be stored per new compression option.
+//
+// With Options.SetAdaptiveCompression,
+// a sample from the beginning of the data is compressed in memory first,
+// and the data is stored uncompressed if the sample doesn't compress well,
+// e.g. JPEG images or MP4 videos that are already compressed.
+// The choice is recorded by the data filename,
+// so Read and Stat work the same way either way,
+// and Stat reports fsdb.CodecPlain for the entries stored uncompressed.
+//
+// Existing entries are not converted when the compression options change.
+// Recompress rewrites them per the current options at a configurable rate,
+// skipping the entries written concurrently,
+// and reports the bytes saved.
+// The fsdb command under cmd/fsdb provides a recompress command for the same.
+//
+// Encryption
+//
+// Data files can be encrypted with AES-GCM by Options.SetUseEncryption,
+// using the keys from the KeyProvider set by Options.SetKeyProvider.
+// When a codec is also used, the data is compressed before encrypted.
+//
+// The data is encrypted in chunks of 64KiB,
+// so entries are streamed instead of loaded into memory.
+// Every chunk is authenticated against its position, the entry key,
+// and whether it's the last chunk,
+// so reordered, truncated, or moved data files are reported as
+// fsdb.CorruptEntryError.
+// Every data file is encrypted with its own AES key,
+// derived by HKDF-SHA256 from the key provided and a random salt stored in
+// its header,
+// so a key could be used for any number of data files.
+//
+// Every encrypted data file records the ID of the key used in its header.
+// To rotate keys, change the current key ID of the KeyProvider while still
+// providing the old keys, then run ReEncrypt to rewrite the existing entries
+// with the new key.
+// ReEncrypt also encrypts the existing plaintext entries,
+// or decrypts all the entries when encryption is disabled.
+//
+// Like compression, changing the encryption option on a non-empty local fsdb
+// is safe: encrypted and plaintext entries can coexist,
+// and encrypted entries are readable as long as the KeyProvider provides their
+// keys.
+//
+// Only the local data files are encrypted.
+// The info and key files are not,
+// and hybrid uploads the decrypted data to the bucket.
+//
+// Checksums
+//
+// Every write stores the SHA-256 checksum of the data in the info file,
+// and Read verifies it when the reader reaches EOF,
+// returning an fsdb.CorruptEntryError wrapping ChecksumMismatchError on
+// mismatch instead of io.EOF.
+// The checksum is of the data before compression and encryption,
+// so it also catches bugs in those layers.
+//
+// Only reads from the beginning to EOF are verified:
+// seeking away from the beginning, ReadAt and ReadRange are not.
+// Entries written by older versions without checksums are not verified.
+// Use Options.SetVerifyChecksum to disable the verification for latency
+// sensitive reads.
+//
+// Scrubbing
+//
+// Checksums are only verified when entries are read,
+// so entries rarely read could rot unnoticed.
+// Scrub reads all the entries fully at a configurable rate,
+// verifying their checksums, encryption, and compression along the way,
+// and reports the corrupt ones via a callback with an optional chance to
+// repair them.
+// The fsdb command under cmd/fsdb provides a scrub command for the same.
+//
+// Streaming Writes
+//
+// OpenWriter from fsdb.StreamWriter interface returns an fsdb.EntryWriter,
+// which writes into the temporary directory and only runs Step 3 to 5 on
+// Commit.
+// Readers never see partial data,
+// and Abort removes the temporary directory without touching the entry.
+//
+// Batch Operations
+//
+// ReadMany, WriteMany and DeleteMany from fsdb.Batcher interface run on a
+// bounded number of goroutines (Options.SetBatchThreadNum).
+// WriteMany uses a single temporary directory for all its entries.
+//
+// Watching
+//
+// Watch from fsdb.Watcher interface reports writes and deletes made by all the
+// FSDB instances on the same root within the same process.
+// On Linux, WatchInotify also catches the changes made by other processes.
+//
+// Expiration
+//
+// Entries can be written with a time-to-live via fsdb.Expirer and
+// fsdb.OptionsWriter interfaces, or a default one can be set via
+// Options.SetTTL.
+// The expiration time is stored in the info file.
+// Expired entries are treated as non-existent by all the operations,
+// but their files are only removed by Delete, Reap, or overwriting.
+// Use fsdb.StartReaper to run Reap periodically in the background.
+//
+// Seeking
+//
+// For uncompressed and unencrypted entries,
+// the ReadCloser returned by Read also implements SeekableReadCloser,
+// which can be used by seek-based consumers like http.ServeContent directly.
+// Use ReadSeekable to get it without type assertions.
+// Compressed or encrypted entries are not seekable.
 //
 // Run
 //     go test -bench .
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fishy/fsdb"
 	"github.com/fishy/fsdb/local"
@@ -54,3 +56,26 @@ func Example() {
 	// Darth Vader
 	// Joined force
 }
+
+func ExampleReadSeekable() {
+	root, _ := ioutil.TempDir("", "fsdb_")
+	defer os.RemoveAll(root)
+
+	db := local.Open(local.NewDefaultOptions(root))
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		key := fsdb.Key(r.URL.Path)
+		reader, err := local.ReadSeekable(r.Context(), db, key)
+		if fsdb.IsNoSuchKeyError(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			// TODO: handle error, e.g. fallback to db.Read for compressed entries
+			return
+		}
+		defer reader.Close()
+		// Supports HTTP range requests without extra copies.
+		http.ServeContent(w, r, "", time.Time{}, reader)
+	})
+}
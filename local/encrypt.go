@@ -0,0 +1,528 @@
+package local
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *UnknownKeyIDError satisfies error interface.
+var _ error = (*UnknownKeyIDError)(nil)
+
+// Make sure *StaticKeys satisfies KeyProvider interface.
+var _ KeyProvider = (*StaticKeys)(nil)
+
+// ErrNoKeyProvider is the error returned when an entry needs to be encrypted
+// or decrypted but there's no KeyProvider set in the options.
+var ErrNoKeyProvider = errors.New("local: no key provider for encryption")
+
+// Encryption format constants.
+const (
+	encryptionMagic = "FSDBAES1"
+
+	// encryptionChunkSize is the size of the plaintext in every chunk,
+	// except the last one which is always smaller (could be empty).
+	encryptionChunkSize = 64 * 1024
+
+	saltSize        = 32
+	noncePrefixSize = 7
+	maxKeyIDSize    = 255
+
+	// hkdfInfo is the HKDF info used to derive the key of every data file.
+	hkdfInfo = "fsdb local data file"
+)
+
+// KeyProvider provides the AES keys used to encrypt the data files.
+//
+// Every encrypted data file records the ID of the key used,
+// so keys can be rotated by changing the current key ID,
+// as long as the old keys are still provided for reading.
+// Use ReEncrypt to rewrite the existing entries with the current key.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key to encrypt new data files.
+	//
+	// The ID must be no longer than 255 bytes.
+	CurrentKeyID() string
+
+	// Key returns the key of the given ID,
+	// which must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256.
+	//
+	// If the ID is unknown, it should return an UnknownKeyIDError.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with a fixed set of keys.
+type StaticKeys struct {
+	// Current is the ID of the key to encrypt new data files.
+	Current string
+
+	// Keys are the keys by IDs.
+	Keys map[string][]byte
+}
+
+// CurrentKeyID returns keys.Current.
+func (keys *StaticKeys) CurrentKeyID() string {
+	return keys.Current
+}
+
+// Key returns the key from keys.Keys.
+func (keys *StaticKeys) Key(id string) ([]byte, error) {
+	if key, ok := keys.Keys[id]; ok {
+		return key, nil
+	}
+	return nil, &UnknownKeyIDError{KeyID: id}
+}
+
+// UnknownKeyIDError is an error returned by KeyProvider when the key ID is
+// unknown.
+type UnknownKeyIDError struct {
+	KeyID string
+}
+
+func (err *UnknownKeyIDError) Error() string {
+	return fmt.Sprintf("unknown encryption key id %q", err.KeyID)
+}
+
+// IsUnknownKeyIDError checks whether a given error is UnknownKeyIDError,
+// or wraps one.
+func IsUnknownKeyIDError(err error) bool {
+	var target *UnknownKeyIDError
+	return errors.As(err, &target)
+}
+
+// newGCM creates the AES-GCM AEAD of the data file with the given salt,
+// encrypted with the key of the given ID.
+//
+// The actual AES key is derived from the key of the given ID and the salt,
+// so every data file has its own key,
+// and the random nonce prefixes don't need to be unique across data files.
+func (db *impl) newGCM(keyID string, salt []byte) (cipher.AEAD, error) {
+	provider := db.opts.GetKeyProvider()
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+	key, err := provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	// Check the size of the key before deriving a key of the same size.
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(hkdf(key, salt, []byte(hkdfInfo), len(key)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdf derives a key of size n from secret with HKDF-SHA256 (RFC 5869).
+//
+// n must be no larger than 255 times of the SHA-256 size.
+//
+// It's the same as golang.org/x/crypto/hkdf,
+// which is not used to avoid the extra dependency.
+// The output is pinned by the test vectors from RFC 5869 in hkdf_test.go.
+func hkdf(secret, salt, info []byte, n int) []byte {
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	expander := hmac.New(sha256.New, prk)
+	var t []byte
+	out := make([]byte, 0, n+sha256.Size)
+	for i := byte(1); len(out) < n; i++ {
+		expander.Reset()
+		expander.Write(t)
+		expander.Write(info)
+		expander.Write([]byte{i})
+		t = expander.Sum(t[:0])
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// encryptionHeader is the header of an encrypted data file:
+//
+//     magic (8 bytes) | key ID length (1 byte) | key ID | salt (32 bytes) |
+//     nonce prefix (7 bytes)
+type encryptionHeader struct {
+	keyID       string
+	salt        [saltSize]byte
+	noncePrefix [noncePrefixSize]byte
+}
+
+func (h *encryptionHeader) bytes() []byte {
+	buf := make([]byte, 0, h.size())
+	buf = append(buf, encryptionMagic...)
+	buf = append(buf, byte(len(h.keyID)))
+	buf = append(buf, h.keyID...)
+	buf = append(buf, h.salt[:]...)
+	return append(buf, h.noncePrefix[:]...)
+}
+
+func (h *encryptionHeader) size() int {
+	return len(encryptionMagic) + 1 + len(h.keyID) + saltSize + noncePrefixSize
+}
+
+// readEncryptionHeader reads the header of an encrypted data file.
+//
+// It returns a CorruptEntryError if the header is bad.
+func readEncryptionHeader(key fsdb.Key, r io.Reader) (*encryptionHeader, error) {
+	corrupt := func(err error) error {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return &fsdb.CorruptEntryError{
+				Key:    key,
+				Reason: "bad encryption header",
+				Err:    err,
+			}
+		}
+		return err
+	}
+
+	buf := make([]byte, len(encryptionMagic)+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, corrupt(err)
+	}
+	if string(buf[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: "bad encryption header",
+		}
+	}
+	keyID := make([]byte, buf[len(encryptionMagic)])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return nil, corrupt(err)
+	}
+	h := &encryptionHeader{
+		keyID: string(keyID),
+	}
+	if _, err := io.ReadFull(r, h.salt[:]); err != nil {
+		return nil, corrupt(err)
+	}
+	if _, err := io.ReadFull(r, h.noncePrefix[:]); err != nil {
+		return nil, corrupt(err)
+	}
+	return h, nil
+}
+
+// readKeyID reads the key ID from the header of an encrypted data file.
+func readKeyID(key fsdb.Key, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h, err := readEncryptionHeader(key, file)
+	if err != nil {
+		return "", err
+	}
+	return h.keyID, nil
+}
+
+// chunkCipher seals and opens the chunks of an encrypted data file.
+type chunkCipher struct {
+	aead   cipher.AEAD
+	header *encryptionHeader
+	aad    []byte
+	nonce  []byte
+}
+
+// newChunkCipher creates a chunkCipher.
+//
+// The additional data of every chunk is the header plus the key of the entry,
+// so chunks can't be moved between entries.
+func (db *impl) newChunkCipher(
+	key fsdb.Key,
+	header *encryptionHeader,
+) (*chunkCipher, error) {
+	aead, err := db.newGCM(header.keyID, header.salt[:])
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCipher{
+		aead:   aead,
+		header: header,
+		aad:    append(header.bytes(), key...),
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+// setNonce sets the nonce of the i-th chunk:
+//
+//     nonce prefix (7 bytes) | i (4 bytes, big endian) | last chunk flag (1 byte)
+func (c *chunkCipher) setNonce(i uint32, last bool) {
+	copy(c.nonce, c.header.noncePrefix[:])
+	binary.BigEndian.PutUint32(c.nonce[noncePrefixSize:], i)
+	c.nonce[noncePrefixSize+4] = 0
+	if last {
+		c.nonce[noncePrefixSize+4] = 1
+	}
+}
+
+func (c *chunkCipher) seal(dst, plaintext []byte, i uint32, last bool) []byte {
+	c.setNonce(i, last)
+	return c.aead.Seal(dst, c.nonce, plaintext, c.aad)
+}
+
+func (c *chunkCipher) open(dst, ciphertext []byte, i uint32, last bool) ([]byte, error) {
+	c.setNonce(i, last)
+	return c.aead.Open(dst, c.nonce, ciphertext, c.aad)
+}
+
+// encryptWriter encrypts the data written into chunks.
+type encryptWriter struct {
+	file   io.WriteCloser
+	cipher *chunkCipher
+	buf    []byte
+	out    []byte
+	chunk  uint32
+}
+
+// newEncryptWriter writes the header into file,
+// and returns a WriteCloser encrypting the data into file with the current
+// key.
+//
+// Closing the WriteCloser also closes file.
+func (db *impl) newEncryptWriter(
+	key fsdb.Key,
+	file io.WriteCloser,
+) (io.WriteCloser, error) {
+	provider := db.opts.GetKeyProvider()
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+	header := &encryptionHeader{
+		keyID: provider.CurrentKeyID(),
+	}
+	if len(header.keyID) > maxKeyIDSize {
+		return nil, fmt.Errorf("local: encryption key id too long: %q", header.keyID)
+	}
+	if _, err := rand.Read(header.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(header.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+	c, err := db.newChunkCipher(key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(header.bytes()); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		file:   file,
+		cipher: c,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		// A full chunk is never the last one.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) flush(last bool) error {
+	if w.chunk == ^uint32(0) {
+		return errors.New("local: too much data to encrypt")
+	}
+	w.out = w.cipher.seal(w.out[:0], w.buf, w.chunk, last)
+	w.chunk++
+	w.buf = w.buf[:0]
+	_, err := w.file.Write(w.out)
+	return err
+}
+
+func (w *encryptWriter) Close() error {
+	if err := w.flush(true); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// decryptReader decrypts the chunks of an encrypted data file.
+type decryptReader struct {
+	key    fsdb.Key
+	reader io.Reader
+	cipher *chunkCipher
+	buf    []byte
+	plain  []byte
+	chunk  uint32
+	done   bool
+}
+
+// newDecryptReader reads the header from r,
+// and returns a Reader decrypting the rest of r.
+func (db *impl) newDecryptReader(key fsdb.Key, r io.Reader) (io.Reader, error) {
+	header, err := readEncryptionHeader(key, r)
+	if err != nil {
+		return nil, err
+	}
+	c, err := db.newChunkCipher(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		key:    key,
+		reader: r,
+		cipher: c,
+		buf:    make([]byte, encryptionChunkSize+c.aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk.
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.reader, r.buf)
+	last := false
+	switch {
+	case err == nil:
+		// A full chunk is never the last one.
+	case errors.Is(err, io.ErrUnexpectedEOF) && n >= r.cipher.aead.Overhead():
+		last = true
+	case err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF):
+		return &fsdb.CorruptEntryError{
+			Key:    r.key,
+			Reason: "encrypted data truncated",
+		}
+	default:
+		return err
+	}
+	plain, err := r.cipher.open(r.buf[:0], r.buf[:n], r.chunk, last)
+	if err != nil {
+		return &fsdb.CorruptEntryError{
+			Key:    r.key,
+			Reason: "decryption failed",
+			Err:    err,
+		}
+	}
+	r.plain = plain
+	r.chunk++
+	r.done = last
+	return nil
+}
+
+// readEncryptedTail reads the last n bytes of the plaintext of an encrypted
+// data file without decrypting the whole file.
+//
+// It's used to read the trailer of SizedCodec.
+// It returns a CorruptEntryError if the plaintext is shorter than n.
+func (db *impl) readEncryptedTail(
+	key fsdb.Key,
+	file *os.File,
+	fileSize int64,
+	n int,
+) ([]byte, error) {
+	header, err := readEncryptionHeader(key, file)
+	if err != nil {
+		return nil, err
+	}
+	c, err := db.newChunkCipher(key, header)
+	if err != nil {
+		return nil, err
+	}
+	sealed := int64(encryptionChunkSize + c.aead.Overhead())
+	body := fileSize - int64(header.size())
+	chunks := body/sealed + 1
+	if body%sealed < int64(c.aead.Overhead()) {
+		return nil, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: "encrypted data truncated",
+		}
+	}
+
+	var plain []byte
+	for i := chunks - 1; i >= 0 && len(plain) < n; i-- {
+		offset := int64(header.size()) + i*sealed
+		size := sealed
+		if i == chunks-1 {
+			size = body - i*sealed
+		}
+		buf := make([]byte, size)
+		if _, err := file.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		chunk, err := c.open(buf[:0], buf, uint32(i), i == chunks-1)
+		if err != nil {
+			return nil, &fsdb.CorruptEntryError{
+				Key:    key,
+				Reason: "decryption failed",
+				Err:    err,
+			}
+		}
+		plain = append(chunk, plain...)
+	}
+	if len(plain) < n {
+		return nil, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: fmt.Sprintf("encrypted data is too short: %d bytes", len(plain)),
+		}
+	}
+	return plain[len(plain)-n:], nil
+}
+
+// encryptedSize returns the size of the plaintext of an encrypted data file
+// from its size.
+func encryptedSize(
+	key fsdb.Key,
+	path string,
+	fileSize int64,
+) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	header, err := readEncryptionHeader(key, file)
+	if err != nil {
+		return 0, err
+	}
+	// AES-GCM overhead is always 16 bytes.
+	const overhead = 16
+	sealed := int64(encryptionChunkSize + overhead)
+	body := fileSize - int64(header.size())
+	chunks := body/sealed + 1
+	if body%sealed < overhead {
+		return 0, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: "encrypted data truncated",
+		}
+	}
+	return body - chunks*overhead, nil
+}
@@ -0,0 +1,407 @@
+package local_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/local"
+	"github.com/fishy/fsdb/memory"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func testKeys(current string) *local.StaticKeys {
+	return &local.StaticKeys{
+		Current: current,
+		Keys: map[string][]byte{
+			"key1": testKey1,
+			"key2": testKey2,
+		},
+	}
+}
+
+func TestEncryptionConformance(t *testing.T) {
+	for _, gzip := range []bool{false, true} {
+		gzip := gzip
+		t.Run(fmt.Sprintf("gzip=%v", gzip), func(t *testing.T) {
+			fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+				root, err := ioutil.TempDir("", "fsdb_")
+				if err != nil {
+					t.Fatalf("failed to get tmp dir: %v", err)
+				}
+				t.Cleanup(func() {
+					os.RemoveAll(root)
+				})
+				return local.Open(
+					local.NewDefaultOptions(root).
+						SetUseGzip(gzip).
+						SetUseEncryption(true).
+						SetKeyProvider(testKeys("key1")),
+				)
+			})
+		})
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	r := rand.New(rand.NewSource(1))
+	contents := map[string]string{
+		"empty": "",
+		"lorem": lorem,
+	}
+	// Sizes around the chunk boundaries.
+	for _, size := range []int{64 * 1024, 64*1024 + 1, 200 * 1024} {
+		buf := make([]byte, size)
+		r.Read(buf)
+		contents[fmt.Sprintf("random-%d", size)] = string(buf)
+	}
+
+	for _, useGzip := range []bool{false, true} {
+		opts := local.NewDefaultOptions(root).
+			SetUseGzip(useGzip).
+			SetUseEncryption(true).
+			SetKeyProvider(testKeys("key1"))
+		db := local.Open(opts)
+		expectedName := local.EncryptedDataFilename
+		if useGzip {
+			expectedName = local.EncryptedGzipDataFilename
+		}
+
+		for label, content := range contents {
+			key := fsdb.Key(label)
+			testWrite(t, db, key, content)
+			testRead(t, db, key, content)
+
+			stored, err := ioutil.ReadFile(opts.GetDirForKey(key) + expectedName)
+			if err != nil {
+				t.Fatalf("%s (gzip: %v): read data file failed: %v", label, useGzip, err)
+			}
+			if len(content) > 0 && bytes.Contains(stored, []byte(content)) {
+				t.Errorf("%s (gzip: %v): data file contains plaintext", label, useGzip)
+			}
+
+			info, err := db.(fsdb.Stater).Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("%s (gzip: %v): Stat failed: %v", label, useGzip, err)
+			}
+			if info.Size != int64(len(content)) {
+				t.Errorf(
+					"%s (gzip: %v): Stat size expected %d, got %d",
+					label,
+					useGzip,
+					len(content),
+					info.Size,
+				)
+			}
+			if info.StoredSize != int64(len(stored)) {
+				t.Errorf(
+					"%s (gzip: %v): Stat stored size expected %d, got %d",
+					label,
+					useGzip,
+					len(stored),
+					info.StoredSize,
+				)
+			}
+			if !info.Encrypted {
+				t.Errorf("%s (gzip: %v): Stat should report encrypted", label, useGzip)
+			}
+
+			if len(content) > 10 {
+				reader, err := db.(fsdb.RangeReader).ReadRange(ctx, key, 5, 5)
+				if err != nil {
+					t.Fatalf("%s (gzip: %v): ReadRange failed: %v", label, useGzip, err)
+				}
+				actual, err := ioutil.ReadAll(reader)
+				reader.Close()
+				if err != nil {
+					t.Fatalf("%s (gzip: %v): ReadRange failed: %v", label, useGzip, err)
+				}
+				if string(actual) != content[5:10] {
+					t.Errorf(
+						"%s (gzip: %v): ReadRange expected %q, got %q",
+						label,
+						useGzip,
+						content[5:10],
+						actual,
+					)
+				}
+			}
+
+			if _, err := local.ReadSeekable(ctx, db, key); err != local.ErrNotSeekable {
+				t.Errorf(
+					"%s (gzip: %v): ReadSeekable expected %v, got %v",
+					label,
+					useGzip,
+					local.ErrNotSeekable,
+					err,
+				)
+			}
+			testDelete(t, db, key)
+		}
+	}
+}
+
+func TestChangeEncryption(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	plainDB := local.Open(local.NewDefaultOptions(root))
+	encDB := local.Open(
+		local.NewDefaultOptions(root).
+			SetUseEncryption(true).
+			SetKeyProvider(testKeys("key1")),
+	)
+	plain := fsdb.Key("plain")
+	encrypted := fsdb.Key("encrypted")
+	testWrite(t, plainDB, plain, lorem)
+	testWrite(t, encDB, encrypted, lorem)
+
+	testRead(t, encDB, plain, lorem)
+	testRead(t, encDB, encrypted, lorem)
+
+	// Without key provider, encrypted entries can't be read.
+	if _, err := plainDB.Read(ctx, encrypted); !errors.Is(err, local.ErrNoKeyProvider) {
+		t.Errorf("Read without key provider expected ErrNoKeyProvider, got %v", err)
+	}
+	testRead(t, plainDB, plain, lorem)
+
+	// With key provider, encrypted entries can be read even if encryption is
+	// disabled.
+	readerDB := local.Open(
+		local.NewDefaultOptions(root).SetKeyProvider(testKeys("key1")),
+	)
+	testRead(t, readerDB, encrypted, lorem)
+
+	// Overwrite encrypted entry with plaintext.
+	testWrite(t, plainDB, encrypted, "")
+	testRead(t, plainDB, encrypted, "")
+	testRead(t, encDB, encrypted, "")
+
+	// Unknown key.
+	testWrite(t, encDB, encrypted, lorem)
+	unknownDB := local.Open(
+		local.NewDefaultOptions(root).SetKeyProvider(&local.StaticKeys{}),
+	)
+	_, err = unknownDB.Read(ctx, encrypted)
+	if !local.IsUnknownKeyIDError(err) {
+		t.Errorf("Read with unknown key expected UnknownKeyIDError, got %v", err)
+	}
+
+	// Write without key provider.
+	noKeyDB := local.Open(local.NewDefaultOptions(root).SetUseEncryption(true))
+	err = noKeyDB.Write(ctx, plain, strings.NewReader(lorem))
+	if !errors.Is(err, local.ErrNoKeyProvider) {
+		t.Errorf("Write without key provider expected ErrNoKeyProvider, got %v", err)
+	}
+	testRead(t, plainDB, plain, lorem)
+}
+
+func TestEncryptionCorrupt(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root).
+		SetUseEncryption(true).
+		SetKeyProvider(testKeys("key1"))
+	db := local.Open(opts)
+	key := fsdb.Key("foo")
+	path := opts.GetDirForKey(key) + local.EncryptedDataFilename
+
+	read := func(key fsdb.Key) error {
+		reader, err := db.Read(ctx, key)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		_, err = ioutil.ReadAll(reader)
+		return err
+	}
+
+	cases := []struct {
+		label  string
+		mangle func(data []byte) []byte
+	}{
+		{
+			label: "flip",
+			mangle: func(data []byte) []byte {
+				data[len(data)-20] ^= 1
+				return data
+			},
+		},
+		{
+			label: "truncate",
+			mangle: func(data []byte) []byte {
+				return data[:len(data)-10]
+			},
+		},
+		{
+			label: "drop-last-chunk",
+			mangle: func(data []byte) []byte {
+				// The last chunk of 64KiB data is an empty one.
+				return data[:len(data)-16]
+			},
+		},
+		{
+			label: "header",
+			mangle: func(data []byte) []byte {
+				return data[:5]
+			},
+		},
+	}
+	for _, c := range cases {
+		data := make([]byte, 64*1024)
+		rand.Read(data)
+		testWrite(t, db, key, string(data))
+		stored, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if err := ioutil.WriteFile(
+			path,
+			c.mangle(stored),
+			local.FileModeForFiles,
+		); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if err := read(key); !fsdb.IsCorruptEntryError(err) {
+			t.Errorf("%s: expected CorruptEntryError, got %v", c.label, err)
+		}
+	}
+
+	// Data files moved between entries can't be decrypted.
+	other := fsdb.Key("bar")
+	testWrite(t, db, key, lorem)
+	testWrite(t, db, other, lorem)
+	if err := os.Rename(
+		path,
+		opts.GetDirForKey(other)+local.EncryptedDataFilename,
+	); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := read(other); !fsdb.IsCorruptEntryError(err) {
+		t.Errorf("Moved data file expected CorruptEntryError, got %v", err)
+	}
+}
+
+func TestReEncrypt(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	opts := local.NewDefaultOptions(root).
+		SetUseEncryption(true).
+		SetKeyProvider(testKeys("key1"))
+	db := local.Open(opts)
+	plainDB := local.Open(local.NewDefaultOptions(root).SetUseGzip(true))
+	keys := []fsdb.Key{
+		fsdb.Key("foo"),
+		fsdb.Key("bar"),
+		fsdb.Key("plain"),
+	}
+	testWrite(t, db, keys[0], lorem)
+	testWrite(t, db, keys[1], "")
+	testWrite(t, plainDB, keys[2], lorem)
+	generations := make(map[string]int64)
+	for _, key := range keys {
+		info, err := db.(fsdb.Stater).Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		generations[string(key)] = info.Generation
+	}
+
+	reEncrypt := func(expected int) {
+		t.Helper()
+		var count int
+		if err := local.ReEncrypt(ctx, db, func(key fsdb.Key) bool {
+			count++
+			return true
+		}); err != nil {
+			t.Fatalf("ReEncrypt failed: %v", err)
+		}
+		if count != expected {
+			t.Errorf("ReEncrypt expected to rewrite %d entries, got %d", expected, count)
+		}
+		for _, key := range keys {
+			info, err := db.(fsdb.Stater).Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat failed: %v", err)
+			}
+			if info.Generation != generations[string(key)] {
+				t.Errorf(
+					"ReEncrypt changed generation of %v from %d to %d",
+					key,
+					generations[string(key)],
+					info.Generation,
+				)
+			}
+			if info.Encrypted != opts.GetUseEncryption() {
+				t.Errorf("Unexpected info after ReEncrypt: %+v", info)
+			}
+		}
+	}
+
+	// Only the plaintext entry needs to be rewritten.
+	reEncrypt(1)
+	info, err := db.(fsdb.Stater).Stat(ctx, keys[2])
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Codec != fsdb.CodecGzip {
+		t.Errorf("ReEncrypt should keep compression, got %+v", info)
+	}
+
+	// Rotate.
+	opts.SetKeyProvider(testKeys("key2"))
+	reEncrypt(len(keys))
+	reEncrypt(0)
+	opts.SetKeyProvider(&local.StaticKeys{
+		Current: "key2",
+		Keys: map[string][]byte{
+			"key2": testKey2,
+		},
+	})
+	testRead(t, db, keys[0], lorem)
+	testRead(t, db, keys[1], "")
+	testRead(t, db, keys[2], lorem)
+
+	// Decrypt.
+	opts.SetUseEncryption(false)
+	reEncrypt(len(keys))
+	opts.SetKeyProvider(nil)
+	testRead(t, db, keys[0], lorem)
+	testRead(t, db, keys[2], lorem)
+
+	if err := local.ReEncrypt(ctx, memory.Open(nil), nil); err != fsdb.ErrNotSupported {
+		t.Errorf("ReEncrypt on memory db expected ErrNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package local
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHKDF(t *testing.T) {
+	seq := func(from, to int) []byte {
+		b := make([]byte, 0, to-from)
+		for i := from; i < to; i++ {
+			b = append(b, byte(i))
+		}
+		return b
+	}
+
+	for _, c := range []struct {
+		label    string
+		secret   []byte
+		salt     []byte
+		info     []byte
+		expected string
+	}{
+		// Test cases 1-3 from RFC 5869 appendix A.
+		{
+			label:    "rfc5869-1",
+			secret:   bytes.Repeat([]byte{0x0b}, 22),
+			salt:     seq(0x00, 0x0d),
+			info:     seq(0xf0, 0xfa),
+			expected: "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		},
+		{
+			label:    "rfc5869-2",
+			secret:   seq(0x00, 0x50),
+			salt:     seq(0x60, 0xb0),
+			info:     seq(0xb0, 0x100),
+			expected: "b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71cc30c58179ec3e87c14c01d5c1f3434f1d87",
+		},
+		{
+			label:    "rfc5869-3",
+			secret:   bytes.Repeat([]byte{0x0b}, 22),
+			salt:     nil,
+			info:     nil,
+			expected: "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		},
+		// The key of a data file derived from an AES-256 master key,
+		// changing it makes the existing data files undecryptable.
+		{
+			label:    "data-file",
+			secret:   seq(0x00, 0x20),
+			salt:     seq(0x20, 0x40),
+			info:     []byte(hkdfInfo),
+			expected: "0db2513a14cbfd80327e0efbf4fb7a0f53d2cf7821c583d2593a0cf3c87e05e6",
+		},
+	} {
+		t.Run(c.label, func(t *testing.T) {
+			actual := hex.EncodeToString(hkdf(c.secret, c.salt, c.info, len(c.expected)/2))
+			if actual != c.expected {
+				t.Errorf("hkdf expected %s, got %s", c.expected, actual)
+			}
+		})
+	}
+}
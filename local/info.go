@@ -0,0 +1,153 @@
+package local
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// entryInfo is the content of the info file under the entry directory.
+type entryInfo struct {
+	Generation int64         `json:"generation"`
+	Metadata   fsdb.Metadata `json:"metadata,omitempty"`
+
+	// Expires is the expiration time in unix nanoseconds,
+	// or 0 if the entry never expires.
+	Expires int64 `json:"expires,omitempty"`
+
+	// Checksum is the hex encoded SHA-256 checksum of the uncompressed and
+	// unencrypted data.
+	//
+	// It's empty for entries written by older versions.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Size is the size of the uncompressed and unencrypted data.
+	//
+	// It's 0 for entries written by older versions.
+	Size int64 `json:"size,omitempty"`
+}
+
+// expired returns true if the entry is expired.
+func (info *entryInfo) expired() bool {
+	return info.Expires != 0 && time.Now().UnixNano() >= info.Expires
+}
+
+// expiresTime returns the expiration time,
+// or zero time if the entry never expires.
+func (info *entryInfo) expiresTime() time.Time {
+	if info.Expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, info.Expires)
+}
+
+// lookup checks the entry of key,
+// and returns the entry directory and the info of the entry.
+//
+// It returns a NoSuchKeyError if the key does not exist or is expired,
+// or a KeyCollisionError if the key collides with the existing one.
+func (db *impl) lookup(key fsdb.Key) (string, *entryInfo, error) {
+	dir, err := db.dirForKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	keyFile := dir + KeyFilename
+	if _, err := os.Lstat(keyFile); os.IsNotExist(err) {
+		return "", nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	if err := checkKeyCollision(key, keyFile); err != nil {
+		return "", nil, err
+	}
+	info, err := loadInfo(dir)
+	if os.IsNotExist(err) {
+		return "", nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if info.expired() {
+		return "", nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	return dir, info, nil
+}
+
+// readInfo reads the info file under the entry directory.
+//
+// Entries written by older versions don't have info files,
+// in which case an os.IsNotExist error is returned.
+func readInfo(dir string) (*entryInfo, error) {
+	file, err := os.Open(dir + InfoFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info := new(entryInfo)
+	if err := json.NewDecoder(file).Decode(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// writeInfo writes an info file to path.
+func writeInfo(path string, info *entryInfo) error {
+	file, err := createFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(info)
+}
+
+// loadInfo loads the info of the entry under dir.
+//
+// For entries written by older versions without info files,
+// the modification time of the key file is used as the generation.
+//
+// It returns an os.IsNotExist error if the entry does not exist.
+func loadInfo(dir string) (*entryInfo, error) {
+	stat, err := os.Lstat(dir + KeyFilename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readInfo(dir)
+	if os.IsNotExist(err) {
+		return &entryInfo{
+			Generation: stat.ModTime().UnixNano(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// readGeneration returns the current generation of the entry under dir,
+// or 0 if the entry does not exist or is expired.
+func readGeneration(dir string) (int64, error) {
+	info, err := loadInfo(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.expired() {
+		return 0, nil
+	}
+	return info.Generation, nil
+}
+
+// nextGeneration returns the generation to be used after current.
+//
+// It's based on the current time so generations won't be reused after an entry
+// is deleted and written again.
+func nextGeneration(current int64) int64 {
+	next := time.Now().UnixNano()
+	if next <= current {
+		next = current + 1
+	}
+	return next
+}
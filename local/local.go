@@ -2,17 +2,17 @@ package local
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"github.com/fishy/wrapreader"
+	"sync"
+	"time"
 
 	"github.com/fishy/fsdb"
 )
@@ -27,10 +27,14 @@ var errCanceled = errors.New("canceled by keyFunc")
 
 // Filenames used under the entry directory.
 const (
-	KeyFilename = "key"
+	KeyFilename  = "key"
+	InfoFilename = "info"
 
 	DataFilename     = "data"
 	GzipDataFilename = "data.gz"
+
+	EncryptedDataFilename     = "data.enc"
+	EncryptedGzipDataFilename = "data.gz.enc"
 )
 
 // Permissions for files and directories.
@@ -53,16 +57,49 @@ func (err *KeyCollisionError) Error() string {
 	)
 }
 
+// Is makes errors.Is(err, fsdb.ErrKeyCollision) work.
+func (err *KeyCollisionError) Is(target error) bool {
+	return target == fsdb.ErrKeyCollision
+}
+
+// IsKeyCollisionError checks whether a given error is KeyCollisionError,
+// or wraps one.
+func IsKeyCollisionError(err error) bool {
+	var target *KeyCollisionError
+	return errors.As(err, &target)
+}
+
+// Number of lock stripes used to serialize commits on the same entry.
+const lockStripes = 256
+
+// commitLocks are only held while moving files into an entry directory,
+// or deleting an entry directory.
+var commitLocks [lockStripes]sync.Mutex
+
 type impl struct {
 	opts Options
+
+	// err is the error validating the manifest,
+	// returned by all the operations.
+	err error
+
+	manifestLock    sync.Mutex
+	manifestChecked bool
 }
 
 // Open opens an FSDB with the given options.
 //
+// The layout related options are validated against the manifest under the
+// root directory, see ManifestFilename.
+// If they don't match, all the operations return a ManifestMismatchError
+// instead of failing to find the entries.
+// The manifest is written on the first write to a new FSDB system.
+//
 // There's no need to close it.
 func Open(opts Options) fsdb.Local {
 	return &impl{
 		opts: opts,
+		err:  checkManifest(opts),
 	}
 }
 
@@ -73,61 +110,150 @@ func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
 		return nil, ctx.Err()
 	}
 
-	dir := db.opts.GetDirForKey(key)
-	keyFile := dir + KeyFilename
-	if _, err := os.Lstat(keyFile); os.IsNotExist(err) {
-		return nil, &fsdb.NoSuchKeyError{Key: key}
-	}
-	if err := checkKeyCollision(key, keyFile); err != nil {
+	// Entry info (generation) must be read before opening the data file,
+	// see commit for more details.
+	dir, entry, err := db.lookup(key)
+	if err != nil {
 		return nil, err
 	}
-
-	if db.opts.GetUseGzip() {
-		reader, err := readGzip(dir)
-		if os.IsNotExist(err) {
-			reader, err = readPlain(dir)
+	var result io.ReadCloser
+	err = retryMissingData(key, dir, func() error {
+		for _, name := range db.dataFilenames() {
+			reader, err := db.readData(key, dir, name, entry.Generation)
 			if os.IsNotExist(err) {
-				return nil, &fsdb.NoSuchKeyError{Key: key}
+				continue
+			}
+			if err != nil {
+				return err
 			}
-			return reader, err
+			result = db.withChecksum(key, dir, name, reader, entry)
+			return nil
 		}
-		return reader, err
+		return errNoDataFile
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	reader, err := readPlain(dir)
-	if os.IsNotExist(err) {
-		reader, err = readGzip(dir)
-		if os.IsNotExist(err) {
-			return nil, &fsdb.NoSuchKeyError{Key: key}
-		}
-		return reader, err
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	return db.write(ctx, key, data, nil)
+}
+
+// writeOptions are the extra options of a write operation.
+type writeOptions struct {
+	// If cond is not nil, it will be called with the current generation of the
+	// entry (0 if it does not exist) right before the write commits,
+	// and the write fails with a PreconditionFailedError if it returns false.
+	cond func(current int64) bool
+
+	// metadata is the user metadata to be stored with the entry.
+	metadata fsdb.Metadata
+
+	// ttl is the time-to-live of the entry.
+	// 0 means using the default from Options, negative means never expires.
+	ttl time.Duration
+
+	// generation is the generation of the entry.
+	// 0 means a new generation.
+	generation int64
+}
+
+// write writes an entry.
+//
+// wo could be nil for a plain write.
+func (db *impl) write(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	wo *writeOptions,
+) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	tmpdir, err := db.getTempDir()
+	if err != nil {
+		return err
 	}
-	return reader, err
+	defer os.RemoveAll(tmpdir)
+	return db.writeIn(ctx, key, data, wo, tmpdir)
 }
 
-func (db *impl) Write(
+// writeIn writes an entry using tmpdir as the temporary directory.
+//
+// wo could be nil for a plain write.
+func (db *impl) writeIn(
 	ctx context.Context,
 	key fsdb.Key,
 	data io.Reader,
+	wo *writeOptions,
+	tmpdir string,
 ) (err error) {
+	if err = db.prepareIn(ctx, key, tmpdir); err != nil {
+		return err
+	}
+
+	// Write temp data file
+	writer, err := db.createData(key, tmpdir)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, data); err != nil {
+		writer.Close()
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+
 	select {
 	default:
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 
-	dir := db.opts.GetDirForKey(key)
+	return db.commit(
+		ctx,
+		key,
+		tmpdir,
+		writer.filename(),
+		writer.checksum(),
+		writer.size(),
+		wo,
+	)
+}
+
+// prepare creates a temporary directory with the key file written.
+//
+// It's the caller's responsibility to remove the temporary directory returned.
+func (db *impl) prepare(ctx context.Context, key fsdb.Key) (string, error) {
+	tmpdir, err := db.getTempDir()
+	if err != nil {
+		return "", err
+	}
+	if err := db.prepareIn(ctx, key, tmpdir); err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+	return tmpdir, nil
+}
+
+// prepareIn checks for key collision, and writes the key file under tmpdir.
+func (db *impl) prepareIn(ctx context.Context, key fsdb.Key, tmpdir string) error {
+	dir, err := db.dirForKey(key)
+	if err != nil {
+		return err
+	}
 	keyFile := dir + KeyFilename
-	if _, err = os.Lstat(keyFile); err == nil {
+	if _, err := os.Lstat(keyFile); err == nil {
 		if err = checkKeyCollision(key, keyFile); err != nil {
 			return err
 		}
 	}
-	tmpdir, err := db.getTempDir()
-	if err != nil {
-		return err
-	}
-	defer os.RemoveAll(tmpdir)
 
 	select {
 	default:
@@ -136,9 +262,8 @@ func (db *impl) Write(
 	}
 
 	// Write temp key file
-	tmpKeyFile := tmpdir + KeyFilename
-	if err = func() error {
-		f, err := createFile(tmpKeyFile)
+	if err := func() error {
+		f, err := createFile(tmpdir + KeyFilename)
 		if err != nil {
 			return err
 		}
@@ -157,46 +282,158 @@ func (db *impl) Write(
 		return ctx.Err()
 	}
 
-	// Write temp data file
-	var tmpDataFile string
-	var dataFile string
-	if db.opts.GetUseGzip() {
-		tmpDataFile = tmpdir + GzipDataFilename
-		dataFile = dir + GzipDataFilename
-		if err = func() error {
-			f, err := createFile(tmpDataFile)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			writer, err := gzip.NewWriterLevel(f, db.opts.GetGzipLevel())
-			if err != nil {
-				return err
-			}
-			defer writer.Close()
-			if _, err = io.Copy(writer, data); err != nil {
-				return err
-			}
-			return nil
-		}(); err != nil {
-			return err
+	return nil
+}
+
+// createData creates the data file under tmpdir per the compression and
+// encryption options.
+//
+// It returns a WriteCloser to write the uncompressed data into,
+// which also calculates the checksum of the data.
+// The data file is only complete after the WriteCloser is closed.
+func (db *impl) createData(key fsdb.Key, tmpdir string) (*dataWriter, error) {
+	return db.createDataFormat(key, tmpdir, dataFormat{
+		codec:    db.opts.GetCodec(),
+		encrypt:  db.opts.GetUseEncryption(),
+		adaptive: db.opts.GetAdaptiveCompression(),
+	})
+}
+
+// dataFormat is the format of a data file to be created.
+type dataFormat struct {
+	// codec is the codec to compress the data, nil for no compression.
+	codec Codec
+
+	// encrypt is whether to encrypt the data.
+	encrypt bool
+
+	// adaptive is whether to fall back to no compression when the data doesn't
+	// compress well, see Options.GetAdaptiveCompression.
+	adaptive bool
+}
+
+// createDataFormat creates the data file under tmpdir with the given format.
+//
+// See createData for the WriteCloser returned.
+func (db *impl) createDataFormat(
+	key fsdb.Key,
+	tmpdir string,
+	format dataFormat,
+) (*dataWriter, error) {
+	if format.codec != nil && format.adaptive {
+		writer := db.newAdaptiveWriter(key, tmpdir, format.codec, format.encrypt)
+		return &dataWriter{
+			checksumWriter: newChecksumWriter(writer),
+			filename:       writer.filename,
+		}, nil
+	}
+
+	name, writer, err := db.createDataAs(key, tmpdir, format.codec, format.encrypt)
+	if err != nil {
+		return nil, err
+	}
+	return &dataWriter{
+		checksumWriter: newChecksumWriter(writer),
+		filename: func() string {
+			return name
+		},
+	}, nil
+}
+
+// dataWriter is the WriteCloser returned by createData.
+type dataWriter struct {
+	*checksumWriter
+
+	// filename returns the name of the data file under tmpdir.
+	//
+	// It's only final after Close.
+	filename func() string
+}
+
+// createDataAs creates the data file under tmpdir with the given codec (nil
+// for no compression) and encryption.
+func (db *impl) createDataAs(
+	key fsdb.Key,
+	tmpdir string,
+	codec Codec,
+	encrypt bool,
+) (string, io.WriteCloser, error) {
+	name := dataFilename(codec, encrypt)
+	f, err := createFile(tmpdir + name)
+	if err != nil {
+		return "", nil, err
+	}
+	var writer io.WriteCloser = f
+	if encrypt {
+		if writer, err = db.newEncryptWriter(key, f); err != nil {
+			f.Close()
+			return "", nil, err
 		}
-	} else {
-		tmpDataFile = tmpdir + DataFilename
-		dataFile = dir + DataFilename
-		if err = func() error {
-			f, err := createFile(tmpDataFile)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			if _, err = io.Copy(f, data); err != nil {
-				return err
-			}
-			return nil
-		}(); err != nil {
-			return err
+	}
+	if codec != nil {
+		cw, err := codec.NewWriter(writer)
+		if err != nil {
+			writer.Close()
+			return "", nil, err
 		}
+		writer = &codecWriteCloser{WriteCloser: cw, file: writer}
+	}
+	return name, writer, nil
+}
+
+// commit moves the key and data files written under tmpdir into the entry
+// directory, and writes the info file along the way.
+//
+// wo could be nil for a plain write.
+func (db *impl) commit(
+	ctx context.Context,
+	key fsdb.Key,
+	tmpdir string,
+	dataFilename string,
+	checksum string,
+	size int64,
+	wo *writeOptions,
+) (err error) {
+	if wo == nil {
+		wo = new(writeOptions)
+	}
+
+	dir := db.opts.GetDirForKey(key)
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := readGeneration(dir)
+	if err != nil {
+		return err
+	}
+	if wo.cond != nil && !wo.cond(current) {
+		return &fsdb.PreconditionFailedError{
+			Key:        key,
+			Generation: current,
+		}
+	}
+
+	// Write temp info file
+	info := &entryInfo{
+		Generation: wo.generation,
+		Metadata:   wo.metadata,
+		Checksum:   checksum,
+		Size:       size,
+	}
+	if info.Generation == 0 {
+		info.Generation = nextGeneration(current)
+	}
+	ttl := wo.ttl
+	if ttl == 0 {
+		ttl = db.opts.GetTTL()
+	}
+	if ttl > 0 {
+		info.Expires = time.Now().Add(ttl).UnixNano()
+	}
+	tmpInfoFile := tmpdir + InfoFilename
+	if err = writeInfo(tmpInfoFile, info); err != nil {
+		return err
 	}
 
 	select {
@@ -206,13 +443,14 @@ func (db *impl) Write(
 	}
 
 	// Move data file
+	dataFile := dir + dataFilename
 	if err = os.MkdirAll(dir, FileModeForDirs); err != nil && !os.IsExist(err) {
 		return err
 	}
-	if err = os.Rename(tmpDataFile, dataFile); err != nil {
+	if err = os.Rename(tmpdir+dataFilename, dataFile); err != nil {
 		return err
 	}
-	for _, file := range []string{DataFilename, GzipDataFilename} {
+	for _, file := range db.dataFilenames() {
 		fullpath := dir + file
 		if dataFile == fullpath {
 			continue
@@ -228,8 +466,18 @@ func (db *impl) Write(
 		return ctx.Err()
 	}
 
+	// Move info file, it must happen after moving data file,
+	// so that a reader never sees the new generation with the old data.
+	if err = os.Rename(tmpInfoFile, dir+InfoFilename); err != nil {
+		return err
+	}
+
 	// Move key file
-	return os.Rename(tmpKeyFile, keyFile)
+	if err = os.Rename(tmpdir+KeyFilename, dir+KeyFilename); err != nil {
+		return err
+	}
+	db.emit(fsdb.EventWrite, key)
+	return nil
 }
 
 func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
@@ -239,7 +487,10 @@ func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
 		return ctx.Err()
 	}
 
-	dir := db.opts.GetDirForKey(key)
+	dir, err := db.dirForKey(key)
+	if err != nil {
+		return err
+	}
 	keyFile := dir + KeyFilename
 	if _, err := os.Lstat(keyFile); os.IsNotExist(err) {
 		return &fsdb.NoSuchKeyError{Key: key}
@@ -247,7 +498,19 @@ func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
 	if err := checkKeyCollision(key, keyFile); err != nil {
 		return err
 	}
-	return os.RemoveAll(dir)
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+	// Expired entries are still removed, but reported as not exist.
+	info, infoErr := loadInfo(dir)
+	if err := removeEntry(dir); err != nil {
+		return err
+	}
+	db.emit(fsdb.EventDelete, key)
+	if infoErr == nil && info.expired() {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	return nil
 }
 
 func (db *impl) ScanKeys(
@@ -261,8 +524,36 @@ func (db *impl) ScanKeys(
 		return ctx.Err()
 	}
 
-	if err := filepath.Walk(
-		db.opts.GetRootDataDir(),
+	if db.err != nil {
+		return db.err
+	}
+	roots := []string{db.opts.GetRootDataDir()}
+	if from := db.opts.GetMigrateFrom(); from != nil &&
+		from.GetRootDataDir() != roots[0] {
+		roots = append(roots, from.GetRootDataDir())
+	}
+	for _, root := range roots {
+		if err := scanDir(ctx, root, keyFunc, errFunc); err != nil {
+			if err == errCanceled {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// scanDir walks root and calls keyFunc with every key found.
+//
+// It returns errCanceled if keyFunc returns false.
+func scanDir(
+	ctx context.Context,
+	root string,
+	keyFunc fsdb.KeyFunc,
+	errFunc fsdb.ErrFunc,
+) error {
+	return filepath.Walk(
+		root,
 		func(path string, info os.FileInfo, err error) error {
 			select {
 			default:
@@ -303,14 +594,17 @@ func (db *impl) ScanKeys(
 			}
 			return nil
 		},
-	); err != errCanceled {
-		return err
-	}
-	return nil
+	)
 }
 
 // getTempDir returns a temp directory ready to use.
 func (db *impl) getTempDir() (dir string, err error) {
+	if db.err != nil {
+		return "", db.err
+	}
+	if err = db.ensureManifest(); err != nil {
+		return "", err
+	}
 	root := db.opts.GetRootTempDir()
 	if err = os.MkdirAll(root, tempDirMode); err != nil && !os.IsExist(err) {
 		return
@@ -354,33 +648,188 @@ func readKey(path string) (fsdb.Key, error) {
 	return fsdb.Key(key), nil
 }
 
+// removeEntry removes an entry directory.
+//
+// The key file is removed first,
+// so that readers never see an entry with key file but no data file.
+func removeEntry(dir string) error {
+	if err := os.Remove(dir + KeyFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// missingData returns the error for an entry without any data file.
+//
+// It's a CorruptEntryError unless the key file is also gone,
+// which means the entry is deleted concurrently.
+func missingData(key fsdb.Key, dir string) error {
+	if _, err := os.Lstat(dir + KeyFilename); os.IsNotExist(err) {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	return &fsdb.CorruptEntryError{
+		Key:    key,
+		Reason: "data file missing",
+	}
+}
+
+// errNoDataFile is returned by the functions passed to retryMissingData when
+// none of the data files exist.
+var errNoDataFile = errors.New("local: no data file")
+
+// retryMissingData calls find to find the data file of an entry.
+//
+// If find returns errNoDataFile,
+// it waits for the in-flight commit or rewrite on dir, if any, to finish,
+// and calls find again before reporting the data file as missing,
+// as the data file could be replaced between the lookups of the different
+// data filenames.
+func retryMissingData(key fsdb.Key, dir string, find func() error) error {
+	if err := find(); err != errNoDataFile {
+		return err
+	}
+
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := find(); err != errNoDataFile {
+		return err
+	}
+	return missingData(key, dir)
+}
+
+// lockForDir returns the commit lock for an entry directory.
+func lockForDir(dir string) *sync.Mutex {
+	return &commitLocks[lockStripe(dir)]
+}
+
+// lockStripe returns the index of the commit lock for an entry directory.
+func lockStripe(dir string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(dir))
+	return h.Sum32() % lockStripes
+}
+
+// lockDirs locks the commit locks of two entry directories in a consistent
+// order to avoid deadlocks,
+// and returns the function to unlock them.
+func lockDirs(dir1, dir2 string) (unlock func()) {
+	s1, s2 := lockStripe(dir1), lockStripe(dir2)
+	if s1 == s2 {
+		commitLocks[s1].Lock()
+		return commitLocks[s1].Unlock
+	}
+	if s1 > s2 {
+		s1, s2 = s2, s1
+	}
+	commitLocks[s1].Lock()
+	commitLocks[s2].Lock()
+	return func() {
+		commitLocks[s2].Unlock()
+		commitLocks[s1].Unlock()
+	}
+}
+
 func createFile(path string) (*os.File, error) {
 	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, FileModeForFiles)
 }
 
-// readPlain reads the uncompressed data file
-func readPlain(dir string) (io.ReadCloser, error) {
-	dataFile := dir + DataFilename
-	if _, err := os.Lstat(dataFile); err != nil {
-		return nil, err
+// codecWriteCloser closes both the codec writer and the underlying file.
+type codecWriteCloser struct {
+	io.WriteCloser
+
+	file io.WriteCloser
+}
+
+func (w *codecWriteCloser) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		w.file.Close()
+		return err
 	}
-	return os.Open(dataFile)
+	return w.file.Close()
 }
 
-// readGzip reads the gzipped data file
-func readGzip(dir string) (io.ReadCloser, error) {
-	dataFile := dir + GzipDataFilename
-	if _, err := os.Lstat(dataFile); err != nil {
-		return nil, err
+// readData reads the data file with the given name under dir.
+func (db *impl) readData(
+	key fsdb.Key,
+	dir, name string,
+	generation int64,
+) (io.ReadCloser, error) {
+	if name == DataFilename {
+		file, err := openPlain(dir)
+		if err != nil {
+			return nil, err
+		}
+		file.generation = generation
+		return file, nil
 	}
-	file, err := os.Open(dataFile)
+
+	file, err := os.Open(dir + name)
 	if err != nil {
 		return nil, err
 	}
-	reader, err := gzip.NewReader(file)
+	reader, err := db.decodeData(key, name, file)
 	if err != nil {
 		file.Close()
 		return nil, err
 	}
-	return wrapreader.Wrap(reader, file), nil
+	return &dataReader{
+		ReadCloser: reader,
+		file:       file,
+		generation: generation,
+	}, nil
+}
+
+// decodeData returns a ReadCloser decoding the content read from r,
+// which is the data file with the given name.
+//
+// Closing the ReadCloser returned does not close r.
+func (db *impl) decodeData(
+	key fsdb.Key,
+	name string,
+	r io.Reader,
+) (io.ReadCloser, error) {
+	codec, encrypted, ok := db.parseDataFilename(name)
+	if !ok {
+		return nil, unknownCodec(key, name)
+	}
+	var err error
+	if encrypted {
+		if r, err = db.newDecryptReader(key, r); err != nil {
+			return nil, err
+		}
+	}
+	if codec == nil {
+		return ioutil.NopCloser(r), nil
+	}
+	reader, err := codec.NewReader(r)
+	if err != nil {
+		if fsdb.IsCorruptEntryError(err) {
+			return nil, err
+		}
+		return nil, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: fmt.Sprintf("bad %s header", codec.Name()),
+			Err:    err,
+		}
+	}
+	return reader, nil
+}
+
+// dataReader is an opened data file that's not seekable.
+type dataReader struct {
+	io.ReadCloser
+
+	file       *os.File
+	generation int64
+}
+
+func (r *dataReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+func (r *dataReader) Generation() int64 {
+	return r.generation
 }
@@ -4,16 +4,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
 	"github.com/fishy/fsdb/local"
 )
 
@@ -29,6 +35,24 @@ Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu
 Excepteur sint occaecat cupidatat non proident,
 sunt in culpa qui officia deserunt mollit anim id est laborum.`
 
+func TestConformance(t *testing.T) {
+	for _, gzip := range []bool{false, true} {
+		gzip := gzip
+		t.Run(fmt.Sprintf("gzip=%v", gzip), func(t *testing.T) {
+			fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+				root, err := ioutil.TempDir("", "fsdb_")
+				if err != nil {
+					t.Fatalf("failed to get tmp dir: %v", err)
+				}
+				t.Cleanup(func() {
+					os.RemoveAll(root)
+				})
+				return local.Open(local.NewDefaultOptions(root).SetUseGzip(gzip))
+			})
+		})
+	}
+}
+
 func TestReadWriteDelete(t *testing.T) {
 	root, err := ioutil.TempDir("", "fsdb_")
 	if err != nil {
@@ -106,6 +130,711 @@ func TestChangeCompression(t *testing.T) {
 	testReadEmpty(t, gzipDb, key)
 }
 
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+	stater := db.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	if _, err := stater.Stat(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError, got: %v", err)
+	}
+
+	for _, useGzip := range []bool{false, true} {
+		gzipDb := local.Open(local.NewDefaultOptions(root).SetUseGzip(useGzip))
+		testWrite(t, gzipDb, key, lorem)
+		info, err := stater.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if !info.Key.Equals(key) {
+			t.Errorf("Stat key expected %v, got %v", key, info.Key)
+		}
+		if info.Size != int64(len(lorem)) {
+			t.Errorf("Stat size expected %d, got %d", len(lorem), info.Size)
+		}
+		if !info.Local || info.Remote {
+			t.Errorf("Stat should report local only, got %+v", info)
+		}
+		if info.ModTime.IsZero() {
+			t.Errorf("Stat should report mod time, got %+v", info)
+		}
+		if useGzip {
+			if info.Codec != fsdb.CodecGzip {
+				t.Errorf("Stat codec expected %q, got %q", fsdb.CodecGzip, info.Codec)
+			}
+			if info.StoredSize >= info.Size {
+				t.Errorf("Stored size should be smaller than size, got %+v", info)
+			}
+		} else {
+			if info.Codec != fsdb.CodecPlain {
+				t.Errorf("Stat codec expected %q, got %q", fsdb.CodecPlain, info.Codec)
+			}
+			if info.StoredSize != info.Size {
+				t.Errorf("Stored size should equal to size, got %+v", info)
+			}
+		}
+	}
+
+	testDelete(t, db, key)
+	if _, err := stater.Stat(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError, got: %v", err)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	key := fsdb.Key("foo")
+	cases := []struct {
+		label          string
+		offset, length int64
+		expect         string
+	}{
+		{"all", 0, -1, lorem},
+		{"head", 0, 5, lorem[:5]},
+		{"middle", 6, 5, lorem[6:11]},
+		{"tail", 100, -1, lorem[100:]},
+		{"over-length", 100, 10000, lorem[100:]},
+		{"over-offset", 10000, 10, ""},
+	}
+
+	for _, useGzip := range []bool{false, true} {
+		db := local.Open(local.NewDefaultOptions(root).SetUseGzip(useGzip))
+		rr := db.(fsdb.RangeReader)
+
+		if _, err := rr.ReadRange(ctx, key, 0, -1); !fsdb.IsNoSuchKeyError(err) {
+			t.Errorf("Expected NoSuchKeyError, got: %v", err)
+		}
+		testWrite(t, db, key, lorem)
+		if _, err := rr.ReadRange(ctx, key, -1, -1); err != fsdb.ErrNegativeOffset {
+			t.Errorf("Expected %v, got: %v", fsdb.ErrNegativeOffset, err)
+		}
+
+		for _, c := range cases {
+			reader, err := rr.ReadRange(ctx, key, c.offset, c.length)
+			if err != nil {
+				t.Fatalf("ReadRange %s failed: %v", c.label, err)
+			}
+			actual, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatalf("ReadRange %s content failed: %v", c.label, err)
+			}
+			if string(actual) != c.expect {
+				t.Errorf(
+					"ReadRange %s (gzip: %v) expected %q, got %q",
+					c.label,
+					useGzip,
+					c.expect,
+					actual,
+				)
+			}
+		}
+		testDelete(t, db, key)
+	}
+}
+
+func TestReadSeekable(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+
+	key := fsdb.Key("foo")
+	if _, err := local.ReadSeekable(ctx, db, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError, got: %v", err)
+	}
+
+	testWrite(t, db, key, lorem)
+	reader, err := local.ReadSeekable(ctx, db, key)
+	if err != nil {
+		t.Fatalf("ReadSeekable failed: %v", err)
+	}
+	defer reader.Close()
+	if reader.Size() != int64(len(lorem)) {
+		t.Errorf("Size expected %d, got %d", len(lorem), reader.Size())
+	}
+	buf := make([]byte, 5)
+	if _, err := reader.ReadAt(buf, 6); err != nil {
+		t.Fatalf("ReadAt failed: %v", err)
+	}
+	if string(buf) != lorem[6:11] {
+		t.Errorf("ReadAt expected %q, got %q", lorem[6:11], buf)
+	}
+	if _, err := reader.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read content failed: %v", err)
+	}
+	if expect := lorem[len(lorem)-5:]; string(actual) != expect {
+		t.Errorf("Read after seek expected %q, got %q", expect, actual)
+	}
+
+	plain, err := db.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer plain.Close()
+	if _, ok := plain.(local.SeekableReadCloser); !ok {
+		t.Errorf("Read on uncompressed entry should return SeekableReadCloser")
+	}
+
+	gzipDb := local.Open(local.NewDefaultOptions(root).SetUseGzip(true))
+	testWrite(t, gzipDb, key, lorem)
+	if _, err := local.ReadSeekable(ctx, db, key); err != local.ErrNotSeekable {
+		t.Errorf("Expected %v, got: %v", local.ErrNotSeekable, err)
+	}
+}
+
+func TestConditionalWrite(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+	cw := db.(fsdb.ConditionalWriter)
+	stater := db.(fsdb.Stater)
+
+	key := fsdb.Key("foo")
+	err = cw.WriteIf(ctx, key, strings.NewReader("bar"), 0)
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIf on empty key expected PreconditionFailedError, got %v", err)
+	}
+	testReadEmpty(t, db, key)
+
+	if err := cw.WriteIfNotExists(ctx, key, strings.NewReader(lorem)); err != nil {
+		t.Fatalf("WriteIfNotExists failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+	err = cw.WriteIfNotExists(ctx, key, strings.NewReader("bar"))
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIfNotExists expected PreconditionFailedError, got %v", err)
+	}
+	testRead(t, db, key, lorem)
+
+	info, err := stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	gen := info.Generation
+	if gen == 0 {
+		t.Fatalf("Generation should not be 0")
+	}
+	reader, err := db.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	versioned, ok := reader.(fsdb.Versioned)
+	reader.Close()
+	if !ok {
+		t.Fatalf("Reader should implement fsdb.Versioned")
+	}
+	if versioned.Generation() != gen {
+		t.Errorf("Generation expected %d, got %d", gen, versioned.Generation())
+	}
+
+	// Another write changes the generation
+	testWrite(t, db, key, "bar")
+	err = cw.WriteIf(ctx, key, strings.NewReader(lorem), gen)
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIf with stale generation expected PreconditionFailedError, got %v", err)
+	}
+	testRead(t, db, key, "bar")
+
+	info, err = stater.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Generation <= gen {
+		t.Errorf("Generation should increase, got %d after %d", info.Generation, gen)
+	}
+	if err := cw.WriteIf(ctx, key, strings.NewReader(lorem), info.Generation); err != nil {
+		t.Fatalf("WriteIf failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+}
+
+func TestConditionalWriteRace(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+	cw := db.(fsdb.ConditionalWriter)
+
+	key := fsdb.Key("foo")
+	n := 10
+	var wg sync.WaitGroup
+	var succeeded int32
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			err := cw.WriteIfNotExists(ctx, key, strings.NewReader(lorem))
+			if err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			} else if !fsdb.IsPreconditionFailedError(err) {
+				t.Errorf("WriteIfNotExists failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("Expected exactly 1 WriteIfNotExists to succeed, got %d", succeeded)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+	mdb := db.(fsdb.MetadataFSDB)
+
+	key := fsdb.Key("foo")
+	if _, err := mdb.ReadMetadata(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError, got: %v", err)
+	}
+
+	metadata := fsdb.Metadata{
+		"content-type": "text/plain",
+		"uploader":     "foo",
+	}
+	if err := mdb.WriteWithMetadata(
+		ctx,
+		key,
+		strings.NewReader(lorem),
+		metadata,
+	); err != nil {
+		t.Fatalf("WriteWithMetadata failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+	actual, err := mdb.ReadMetadata(ctx, key)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(actual, metadata) {
+		t.Errorf("ReadMetadata expected %v, got %v", metadata, actual)
+	}
+
+	// Overwrite without metadata
+	testWrite(t, db, key, "")
+	actual, err = mdb.ReadMetadata(ctx, key)
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if len(actual) != 0 {
+		t.Errorf("ReadMetadata expected empty metadata, got %v", actual)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+	expirer := db.(fsdb.Expirer)
+
+	ttl := time.Millisecond * 50
+	longer := time.Millisecond * 100
+
+	key1 := fsdb.Key("foo")
+	key2 := fsdb.Key("bar")
+	if err := expirer.WriteWithTTL(
+		ctx,
+		key1,
+		strings.NewReader(lorem),
+		ttl,
+	); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+	testWrite(t, db, key2, lorem)
+	testRead(t, db, key1, lorem)
+	info, err := db.(fsdb.Stater).Stat(ctx, key1)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Expires.IsZero() {
+		t.Error("Expected non-zero Expires")
+	}
+
+	time.Sleep(longer)
+	testReadEmpty(t, db, key1)
+	testRead(t, db, key2, lorem)
+	if err := db.(fsdb.ConditionalWriter).WriteIfNotExists(
+		ctx,
+		key1,
+		strings.NewReader(""),
+	); err != nil {
+		t.Errorf("WriteIfNotExists on expired key failed: %v", err)
+	}
+	testRead(t, db, key1, "")
+
+	// Default TTL
+	opts.SetTTL(ttl)
+	testWrite(t, db, key1, lorem)
+	// Negative TTL overrides the default one
+	if err := expirer.WriteWithTTL(
+		ctx,
+		key2,
+		strings.NewReader(lorem),
+		-1,
+	); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+	time.Sleep(longer)
+
+	var reaped []fsdb.Key
+	if err := expirer.Reap(ctx, func(key fsdb.Key) bool {
+		reaped = append(reaped, key)
+		return true
+	}); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if !reflect.DeepEqual(reaped, []fsdb.Key{key1}) {
+		t.Errorf("Reap expected %v, got %v", []fsdb.Key{key1}, reaped)
+	}
+	testRead(t, db, key2, lorem)
+	testDeleteEmpty(t, db, key1)
+}
+
+func TestOpenWriter(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+	sw := db.(fsdb.StreamWriter)
+
+	key := fsdb.Key("foo")
+	for _, gzip := range []bool{false, true} {
+		opts.SetUseGzip(gzip)
+		testWrite(t, db, key, "bar")
+
+		writer, err := sw.OpenWriter(ctx, key)
+		if err != nil {
+			t.Fatalf("OpenWriter failed: %v", err)
+		}
+		if _, err := io.WriteString(writer, lorem); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		// Uncommitted data should not be visible.
+		testRead(t, db, key, "bar")
+		if err := writer.Abort(); err != nil {
+			t.Fatalf("Abort failed: %v", err)
+		}
+		testRead(t, db, key, "bar")
+
+		writer, err = sw.OpenWriter(ctx, key)
+		if err != nil {
+			t.Fatalf("OpenWriter failed: %v", err)
+		}
+		for _, word := range strings.SplitAfter(lorem, " ") {
+			if _, err := io.WriteString(writer, word); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		testRead(t, db, key, "bar")
+		if err := writer.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		testRead(t, db, key, lorem)
+		if err := writer.Commit(); err != fsdb.ErrWriterClosed {
+			t.Errorf("Commit twice expected ErrWriterClosed, got %v", err)
+		}
+	}
+
+	// Aborted and committed writers should leave nothing in the temp dir.
+	files, err := ioutil.ReadDir(opts.GetRootTempDir())
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected empty temp dir, got %d files", len(files))
+	}
+}
+
+func TestOpenWriterError(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root).SetCodec(&failOnceCodec{})
+	db := local.Open(opts)
+	sw := db.(fsdb.StreamWriter)
+
+	key := fsdb.Key("foo")
+	writer, err := sw.OpenWriter(ctx, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	defer writer.Abort()
+	if _, err := io.WriteString(writer, lorem); err != errFailOnce {
+		t.Fatalf("Write expected %v, got %v", errFailOnce, err)
+	}
+	// The codec works again, but the data written is already incomplete.
+	if _, err := io.WriteString(writer, lorem); err != errFailOnce {
+		t.Errorf("Write after error expected %v, got %v", errFailOnce, err)
+	}
+	if err := writer.Commit(); err != errFailOnce {
+		t.Errorf("Commit after error expected %v, got %v", errFailOnce, err)
+	}
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read after failed Commit expected NoSuchKeyError, got %v", err)
+	}
+	if err := writer.Commit(); err != fsdb.ErrWriterClosed {
+		t.Errorf("Commit twice expected ErrWriterClosed, got %v", err)
+	}
+
+	files, err := ioutil.ReadDir(opts.GetRootTempDir())
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected empty temp dir, got %d files", len(files))
+	}
+}
+
+var errFailOnce = errors.New("fail once")
+
+// failOnceCodec is a Codec without compression,
+// which fails the first Write with a short write.
+type failOnceCodec struct{}
+
+func (failOnceCodec) Name() string {
+	return "fail-once"
+}
+
+func (failOnceCodec) Suffix() string {
+	return ".failonce"
+}
+
+func (failOnceCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &failOnceWriter{writer: w}, nil
+}
+
+func (failOnceCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+type failOnceWriter struct {
+	writer io.Writer
+	failed bool
+}
+
+func (w *failOnceWriter) Write(p []byte) (int, error) {
+	if !w.failed {
+		w.failed = true
+		n, _ := w.writer.Write(p[:len(p)/2])
+		return n, errFailOnce
+	}
+	return w.writer.Write(p)
+}
+
+func (w *failOnceWriter) Close() error {
+	return nil
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root).SetBatchThreadNum(3)
+	db := local.Open(opts)
+	batch := db.(fsdb.Batcher)
+
+	const n = 20
+	keys := make([]fsdb.Key, n)
+	entries := make([]fsdb.WriteEntry, n)
+	for i := range entries {
+		keys[i] = fsdb.Key(fmt.Sprintf("key-%d", i))
+		entries[i] = fsdb.WriteEntry{
+			Key:  keys[i],
+			Data: strings.NewReader(fmt.Sprintf("%d: %s", i, lorem)),
+		}
+	}
+	for i, err := range batch.WriteMany(ctx, entries) {
+		if err != nil {
+			t.Errorf("WriteMany %v failed: %v", keys[i], err)
+		}
+	}
+	for i, result := range batch.ReadMany(ctx, keys) {
+		if result.Err != nil {
+			t.Errorf("ReadMany %v failed: %v", keys[i], result.Err)
+			continue
+		}
+		buf, err := ioutil.ReadAll(result.Reader)
+		result.Reader.Close()
+		if err != nil {
+			t.Errorf("ReadMany %v read failed: %v", keys[i], err)
+		}
+		expected := fmt.Sprintf("%d: %s", i, lorem)
+		if string(buf) != expected {
+			t.Errorf("ReadMany %v expected %q, got %q", keys[i], expected, buf)
+		}
+	}
+	for i, err := range batch.DeleteMany(ctx, append(keys, fsdb.Key("foo"))) {
+		if i < n && err != nil {
+			t.Errorf("DeleteMany %v failed: %v", keys[i], err)
+		}
+		if i == n && !fsdb.IsNoSuchKeyError(err) {
+			t.Errorf("DeleteMany on non-exist key expected NoSuchKeyError, got %v", err)
+		}
+	}
+	for _, key := range keys {
+		testReadEmpty(t, db, key)
+	}
+
+	files, err := ioutil.ReadDir(opts.GetRootTempDir())
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected empty temp dir, got %d files", len(files))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := local.Open(local.NewDefaultOptions(root))
+	// Another instance on the same root should see the same events.
+	events := local.Open(local.NewDefaultOptions(root)).(fsdb.Watcher).Watch(ctx)
+
+	key1 := fsdb.Key("foo")
+	key2 := fsdb.Key("bar")
+	testWrite(t, db, key1, lorem)
+	testWrite(t, db, key2, lorem)
+	testWrite(t, db, key1, "")
+	testDelete(t, db, key2)
+	testDeleteEmpty(t, db, key2)
+
+	expected := []fsdb.Event{
+		{Type: fsdb.EventWrite, Key: key1},
+		{Type: fsdb.EventWrite, Key: key2},
+		{Type: fsdb.EventWrite, Key: key1},
+		{Type: fsdb.EventDelete, Key: key2},
+	}
+	checkEvents(t, events, expected)
+
+	cancel()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Errorf("Expected closed channel, got %v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("Channel not closed after cancel")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+
+	key := fsdb.Key("foo")
+	if _, err := db.Read(ctx, key); !errors.Is(err, fsdb.ErrNotFound) {
+		t.Errorf("Read on empty db expected ErrNotFound, got %v", err)
+	}
+
+	// Data file missing
+	testWrite(t, db, key, lorem)
+	if err := os.Remove(opts.GetDirForKey(key) + local.DataFilename); err != nil {
+		t.Fatalf("Remove data file failed: %v", err)
+	}
+	if _, err := db.Read(ctx, key); !errors.Is(err, fsdb.ErrCorruptEntry) {
+		t.Errorf("Read without data file expected ErrCorruptEntry, got %v", err)
+	}
+	if _, err := db.(fsdb.Stater).Stat(ctx, key); !fsdb.IsCorruptEntryError(err) {
+		t.Errorf("Stat without data file expected CorruptEntryError, got %v", err)
+	}
+
+	// Bad gzip header
+	opts.SetUseGzip(true)
+	testWrite(t, db, key, lorem)
+	if err := ioutil.WriteFile(
+		opts.GetDirForKey(key)+local.GzipDataFilename,
+		[]byte(lorem),
+		local.FileModeForFiles,
+	); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := db.Read(ctx, key); !errors.Is(err, fsdb.ErrCorruptEntry) {
+		t.Errorf("Read with bad gzip header expected ErrCorruptEntry, got %v", err)
+	}
+	testDelete(t, db, key)
+
+	// Key collision, on a different root as the manifest doesn't allow changing
+	// the hash function.
+	collisionOpts := local.NewDefaultOptions(root + "/collision").SetHashFunc(
+		func() hash.Hash {
+			return constHash{}
+		},
+	)
+	collisionDB := local.Open(collisionOpts)
+	testWrite(t, collisionDB, fsdb.Key("foo"), lorem)
+	err = collisionDB.Write(ctx, fsdb.Key("bar"), strings.NewReader(lorem))
+	if !errors.Is(err, fsdb.ErrKeyCollision) {
+		t.Errorf("Write on colliding key expected ErrKeyCollision, got %v", err)
+	}
+	if !local.IsKeyCollisionError(fmt.Errorf("wrapped: %w", err)) {
+		t.Errorf("%v should be an instance of KeyCollisionError", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.Read(canceled, key); !fsdb.IsCanceledError(err) {
+		t.Errorf("Read with canceled context expected canceled error, got %v", err)
+	}
+}
+
 func TestScan(t *testing.T) {
 	ctx := context.Background()
 	root, err := ioutil.TempDir("", "fsdb_")
@@ -334,3 +1063,39 @@ func testWrite(t *testing.T, db fsdb.FSDB, key fsdb.Key, data string) {
 		t.Fatalf("Write failed: %v", err)
 	}
 }
+
+func checkEvents(t *testing.T, events <-chan fsdb.Event, expected []fsdb.Event) {
+	t.Helper()
+
+	for _, e := range expected {
+		select {
+		case actual := <-events:
+			if actual.Type != e.Type || !actual.Key.Equals(e.Key) {
+				t.Errorf("Expected event %v, got %v", e, actual)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %v", e)
+		}
+	}
+}
+
+// constHash is a hash.Hash always returning the same sum.
+type constHash struct{}
+
+func (constHash) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (constHash) Sum(b []byte) []byte {
+	return append(b, 0, 1, 2, 3, 4, 5, 6, 7)
+}
+
+func (constHash) Reset() {}
+
+func (constHash) Size() int {
+	return 8
+}
+
+func (constHash) BlockSize() int {
+	return 8
+}
@@ -0,0 +1,443 @@
+package local
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *ManifestMismatchError satisfies error interface.
+var _ error = (*ManifestMismatchError)(nil)
+
+// ManifestFilename is the name of the manifest file under the root directory.
+const ManifestFilename = "manifest"
+
+// manifestVersion is the current version of the manifest format.
+const manifestVersion = 1
+
+// hashProbe is the data hashed to identify the hash function in manifests.
+const hashProbe = "fsdb manifest hash probe"
+
+// ErrNoManifest is the error returned by LoadOptions and OpenExisting when
+// there's no manifest under the root directory.
+var ErrNoManifest = errors.New("local: no manifest under the root directory")
+
+// ManifestMismatchError is the error returned by all the operations of an FSDB
+// opened with options that don't match the manifest under the root directory.
+type ManifestMismatchError struct {
+	Root string
+
+	// Field is the name of the mismatched field in the manifest,
+	// e.g. "dir_level" or "migrate_from".
+	Field string
+
+	// Manifest and Options are the descriptions of the field in the manifest
+	// and the options.
+	Manifest string
+	Options  string
+}
+
+func (err *ManifestMismatchError) Error() string {
+	return fmt.Sprintf(
+		"local: options don't match the manifest under %q: "+
+			"%s is %s in the manifest, but %s in the options",
+		err.Root,
+		err.Field,
+		err.Manifest,
+		err.Options,
+	)
+}
+
+// IsManifestMismatchError checks whether a given error is
+// ManifestMismatchError, or wraps one.
+func IsManifestMismatchError(err error) bool {
+	var target *ManifestMismatchError
+	return errors.As(err, &target)
+}
+
+var hashFuncs = struct {
+	sync.RWMutex
+
+	byName map[string]func() hash.Hash
+
+	// probes are the hashes of hashProbe by the registered hash functions.
+	probes map[string]string
+}{
+	byName: make(map[string]func() hash.Hash),
+	probes: make(map[string]string),
+}
+
+func init() {
+	RegisterHashFunc("sha512/224", sha512.New512_224)
+	RegisterHashFunc("sha512/256", sha512.New512_256)
+	RegisterHashFunc("sha512", sha512.New)
+	RegisterHashFunc("sha384", sha512.New384)
+	RegisterHashFunc("sha256", sha256.New)
+	RegisterHashFunc("sha224", sha256.New224)
+	RegisterHashFunc("sha1", sha1.New)
+	RegisterHashFunc("md5", md5.New)
+}
+
+// RegisterHashFunc registers a hash function by name,
+// so LoadOptions and OpenExisting can reconstruct it from the manifest.
+//
+// The hash functions from crypto/sha512, crypto/sha256, crypto/sha1 and
+// crypto/md5 are registered by default.
+//
+// It panics if there's already a hash function registered with the same name.
+// It's usually called in init functions.
+func RegisterHashFunc(name string, f func() hash.Hash) {
+	hashFuncs.Lock()
+	defer hashFuncs.Unlock()
+	if _, ok := hashFuncs.byName[name]; ok {
+		panic(fmt.Sprintf("local: hash function %q registered twice", name))
+	}
+	hashFuncs.byName[name] = f
+	hashFuncs.probes[name] = probeHash(f)
+}
+
+// probeHash returns the hex encoded hash of hashProbe by f.
+func probeHash(f func() hash.Hash) string {
+	h := f()
+	h.Write([]byte(hashProbe))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFuncName returns the name of the registered hash function with the
+// given probe, or empty string if there's none.
+func hashFuncName(probe string) string {
+	hashFuncs.RLock()
+	defer hashFuncs.RUnlock()
+	for name, p := range hashFuncs.probes {
+		if p == probe {
+			return name
+		}
+	}
+	return ""
+}
+
+// manifest records the layout related options of an FSDB system.
+type manifest struct {
+	Version int `json:"version"`
+
+	manifestLayout
+
+	// MigrateFrom is the old layout when there's a migration in progress.
+	MigrateFrom *manifestLayout `json:"migrate_from,omitempty"`
+}
+
+// manifestLayout is the layout recorded in the manifest.
+//
+// The directories are relative to the root directory.
+type manifestLayout struct {
+	DataDir  string `json:"data_dir"`
+	TempDir  string `json:"temp_dir,omitempty"`
+	DirLevel int    `json:"dir_level"`
+
+	// HashFunc is the name of the hash function,
+	// or empty if it's not registered.
+	HashFunc string `json:"hash_func,omitempty"`
+
+	// HashProbe identifies the hash function even if it's not registered.
+	HashProbe string `json:"hash_probe"`
+}
+
+// layoutOf returns the layout of opts.
+//
+// The temporary directory is only included when withTemp is true.
+func layoutOf(opts Options, withTemp bool) manifestLayout {
+	probe := probeHash(opts.GetHashFunc())
+	layout := manifestLayout{
+		DataDir:   strings.TrimPrefix(opts.GetRootDataDir(), opts.GetRoot()),
+		DirLevel:  opts.GetDirLevel(),
+		HashFunc:  hashFuncName(probe),
+		HashProbe: probe,
+	}
+	if withTemp {
+		layout.TempDir = strings.TrimPrefix(opts.GetRootTempDir(), opts.GetRoot())
+	}
+	return layout
+}
+
+// hashFuncString returns the description of the hash function of the layout.
+func (l manifestLayout) hashFuncString() string {
+	if l.HashFunc == "" {
+		return fmt.Sprintf("an unregistered hash function (probe %s)", l.HashProbe)
+	}
+	return l.HashFunc
+}
+
+func (l manifestLayout) String() string {
+	return fmt.Sprintf(
+		"{data_dir: %q, dir_level: %d, hash_func: %s}",
+		l.DataDir,
+		l.DirLevel,
+		l.hashFuncString(),
+	)
+}
+
+// diff returns the ManifestMismatchError of the first field mismatched
+// between l (from the manifest) and other (from the options),
+// or nil if they match.
+//
+// The temporary directories are only compared when both are set.
+func (l manifestLayout) diff(root, prefix string, other manifestLayout) error {
+	mismatch := func(field, manifest, options string) error {
+		return &ManifestMismatchError{
+			Root:     root,
+			Field:    prefix + field,
+			Manifest: manifest,
+			Options:  options,
+		}
+	}
+	switch {
+	case l.DataDir != other.DataDir:
+		return mismatch(
+			"data_dir",
+			fmt.Sprintf("%q", l.DataDir),
+			fmt.Sprintf("%q", other.DataDir),
+		)
+	case l.TempDir != "" && other.TempDir != "" && l.TempDir != other.TempDir:
+		return mismatch(
+			"temp_dir",
+			fmt.Sprintf("%q", l.TempDir),
+			fmt.Sprintf("%q", other.TempDir),
+		)
+	case l.DirLevel != other.DirLevel:
+		return mismatch(
+			"dir_level",
+			fmt.Sprint(l.DirLevel),
+			fmt.Sprint(other.DirLevel),
+		)
+	case l.HashProbe != other.HashProbe:
+		return mismatch("hash_func", l.hashFuncString(), other.hashFuncString())
+	}
+	return nil
+}
+
+// readManifest reads the manifest under root.
+//
+// If there's no manifest, an os.IsNotExist error is returned.
+func readManifest(root string) (*manifest, error) {
+	content, err := ioutil.ReadFile(root + ManifestFilename)
+	if err != nil {
+		return nil, err
+	}
+	m := new(manifest)
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("local: bad manifest under %q: %w", root, err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf(
+			"local: unsupported manifest version %d under %q",
+			m.Version,
+			root,
+		)
+	}
+	return m, nil
+}
+
+// writeManifest writes the manifest under root atomically.
+func writeManifest(root string, m *manifest) error {
+	m.Version = manifestVersion
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, FileModeForDirs); err != nil && !os.IsExist(err) {
+		return err
+	}
+	f, err := ioutil.TempFile(root, "."+ManifestFilename+"_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), FileModeForFiles); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), root+ManifestFilename)
+}
+
+// newManifest returns the manifest of opts, without the migration.
+func newManifest(opts Options) *manifest {
+	return &manifest{
+		manifestLayout: layoutOf(opts, true),
+	}
+}
+
+// WriteManifest writes the layout of opts into the manifest under its root
+// directory, overwriting the existing one.
+//
+// Manifests are written automatically when new FSDB systems are created.
+// It's only needed for FSDB systems created by older versions without
+// manifests.
+func WriteManifest(opts Options) error {
+	return writeManifest(opts.GetRoot(), newManifest(opts))
+}
+
+// checkManifest validates opts against the manifest under the root directory.
+//
+// If opts has a layout to migrate from which matches the manifest,
+// the migration is recorded into the manifest.
+func checkManifest(opts Options) error {
+	root := opts.GetRoot()
+	m, err := readManifest(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current := layoutOf(opts, true)
+	from := opts.GetMigrateFrom()
+	diff := m.diff(root, "", current)
+	if diff == nil {
+		if m.MigrateFrom == nil {
+			// No migration, or it's already finished.
+			return nil
+		}
+		if from == nil {
+			return &ManifestMismatchError{
+				Root:     root,
+				Field:    "migrate_from",
+				Manifest: m.MigrateFrom.String(),
+				Options:  "none",
+			}
+		}
+		return m.MigrateFrom.diff(root, "migrate_from.", layoutOf(from, false))
+	}
+	if from != nil && m.MigrateFrom == nil {
+		old := layoutOf(from, false)
+		if m.diff(root, "", old) == nil {
+			// Starting the migration.
+			return writeManifest(root, &manifest{
+				manifestLayout: current,
+				MigrateFrom:    &old,
+			})
+		}
+	}
+	return diff
+}
+
+// ensureManifest writes the manifest if the FSDB system is new.
+//
+// It's called before the first write.
+func (db *impl) ensureManifest() error {
+	db.manifestLock.Lock()
+	defer db.manifestLock.Unlock()
+	if db.manifestChecked {
+		return nil
+	}
+
+	root := db.opts.GetRoot()
+	_, err := os.Lstat(root + ManifestFilename)
+	if os.IsNotExist(err) {
+		// Systems created by older versions are left without manifests,
+		// as there's no way to verify their options.
+		err = nil
+		if db.isNew() {
+			err = writeManifest(root, newManifest(db.opts))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	db.manifestChecked = true
+	return nil
+}
+
+// isNew returns true if there's no data directory yet.
+func (db *impl) isNew() bool {
+	dirs := []string{db.opts.GetRootDataDir()}
+	if from := db.opts.GetMigrateFrom(); from != nil {
+		dirs = append(dirs, from.GetRootDataDir())
+	}
+	for _, dir := range dirs {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadOptions reconstructs the options from the manifest under root.
+//
+// The layout related options (data and temporary directories, hash function,
+// directory levels, and the layout to migrate from, if any) are from the
+// manifest, and the others are defaults.
+//
+// It returns ErrNoManifest if there's no manifest under root,
+// or an error if the hash function in the manifest is not registered,
+// see RegisterHashFunc.
+func LoadOptions(root string) (OptionsBuilder, error) {
+	opts := NewDefaultOptions(root)
+	m, err := readManifest(opts.GetRoot())
+	if os.IsNotExist(err) {
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.apply(opts, opts.GetRoot()); err != nil {
+		return nil, err
+	}
+	opts.SetTempDir(m.TempDir)
+	if m.MigrateFrom != nil {
+		from := NewDefaultOptions(root)
+		if err := m.MigrateFrom.apply(from, opts.GetRoot()); err != nil {
+			return nil, err
+		}
+		opts.SetMigrateFrom(from)
+	}
+	return opts, nil
+}
+
+// apply sets the data directory, directory levels and hash function of the
+// layout to opts.
+func (l manifestLayout) apply(opts OptionsBuilder, root string) error {
+	hashFuncs.RLock()
+	f := hashFuncs.byName[l.HashFunc]
+	probe := hashFuncs.probes[l.HashFunc]
+	hashFuncs.RUnlock()
+	if f == nil || probe != l.HashProbe {
+		return fmt.Errorf(
+			"local: manifest under %q uses %s, see RegisterHashFunc",
+			root,
+			l.hashFuncString(),
+		)
+	}
+	opts.SetDataDir(l.DataDir).SetDirLevel(l.DirLevel).SetHashFunc(f)
+	return nil
+}
+
+// OpenExisting opens an existing FSDB with the options reconstructed from the
+// manifest under root, see LoadOptions.
+//
+// Use LoadOptions instead to change the other options,
+// e.g. to set the KeyProvider for encrypted entries.
+func OpenExisting(root string) (fsdb.Local, error) {
+	opts, err := LoadOptions(root)
+	if err != nil {
+		return nil, err
+	}
+	return Open(opts), nil
+}
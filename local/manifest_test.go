@@ -0,0 +1,252 @@
+package local_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	manifest := root + local.PathSeparator + local.ManifestFilename
+
+	opts := local.NewDefaultOptions(root).
+		SetDataDir("foo").
+		SetTempDir("bar").
+		SetDirLevel(2).
+		SetHashFunc(sha256.New)
+	db := local.Open(opts)
+	if _, err := os.Lstat(manifest); !os.IsNotExist(err) {
+		t.Errorf("Manifest should only be written on first write, got %v", err)
+	}
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+
+	content, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(content, &m); err != nil {
+		t.Fatalf("Bad manifest %s: %v", content, err)
+	}
+	for field, expected := range map[string]interface{}{
+		"data_dir":  "foo" + local.PathSeparator,
+		"temp_dir":  "bar" + local.PathSeparator,
+		"dir_level": float64(2),
+		"hash_func": "sha256",
+	} {
+		if m[field] != expected {
+			t.Errorf("Manifest %s expected %v, got %v", field, expected, m[field])
+		}
+	}
+
+	// Mismatched options.
+	for field, mismatched := range map[string]local.OptionsBuilder{
+		"data_dir": local.NewDefaultOptions(root).
+			SetTempDir("bar").
+			SetDirLevel(2).
+			SetHashFunc(sha256.New),
+		"temp_dir": local.NewDefaultOptions(root).
+			SetDataDir("foo").
+			SetDirLevel(2).
+			SetHashFunc(sha256.New),
+		"dir_level": local.NewDefaultOptions(root).
+			SetDataDir("foo").
+			SetTempDir("bar").
+			SetHashFunc(sha256.New),
+		"hash_func": local.NewDefaultOptions(root).
+			SetDataDir("foo").
+			SetTempDir("bar").
+			SetDirLevel(2),
+	} {
+		db := local.Open(mismatched)
+		checkMismatch := func(op string, err error) {
+			t.Helper()
+			var target *local.ManifestMismatchError
+			if !errors.As(err, &target) || target.Field != field {
+				t.Errorf("%s: %s expected ManifestMismatchError, got %v", field, op, err)
+			}
+		}
+		_, err := db.Read(ctx, key)
+		checkMismatch("Read", err)
+		_, err = db.(fsdb.Stater).Stat(ctx, key)
+		checkMismatch("Stat", err)
+		checkMismatch("Write", db.Write(ctx, key, nil))
+		checkMismatch("Delete", db.Delete(ctx, key))
+		checkMismatch("ScanKeys", db.ScanKeys(
+			ctx,
+			func(fsdb.Key) bool {
+				return true
+			},
+			fsdb.StopAll,
+		))
+	}
+
+	db, err = local.OpenExisting(root)
+	if err != nil {
+		t.Fatalf("OpenExisting failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+	loaded, err := local.LoadOptions(root)
+	if err != nil {
+		t.Fatalf("LoadOptions failed: %v", err)
+	}
+	if loaded.GetDirForKey(key) != opts.GetDirForKey(key) ||
+		loaded.GetRootTempDir() != opts.GetRootTempDir() ||
+		loaded.GetMigrateFrom() != nil {
+		t.Errorf("LoadOptions returned different layout: %+v", loaded)
+	}
+}
+
+func TestManifestUnregisteredHashFunc(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	hashFunc := func() hash.Hash {
+		return constHash{}
+	}
+	db := local.Open(local.NewDefaultOptions(root).SetHashFunc(hashFunc))
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+
+	// Unregistered hash functions are still validated.
+	db = local.Open(local.NewDefaultOptions(root).SetHashFunc(hashFunc))
+	testRead(t, db, key, lorem)
+	db = local.Open(local.NewDefaultOptions(root))
+	if _, err := db.Read(context.Background(), key); !local.IsManifestMismatchError(err) {
+		t.Errorf("Read expected ManifestMismatchError, got %v", err)
+	}
+
+	// But can't be reconstructed.
+	if _, err := local.OpenExisting(root); err == nil {
+		t.Error("OpenExisting with unregistered hash function should fail")
+	}
+}
+
+func TestManifestLegacy(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	manifest := root + local.PathSeparator + local.ManifestFilename
+
+	if _, err := local.OpenExisting(root); err != local.ErrNoManifest {
+		t.Errorf("OpenExisting on empty root expected ErrNoManifest, got %v", err)
+	}
+
+	opts := local.NewDefaultOptions(root).SetDirLevel(1)
+	key := fsdb.Key("foo")
+	testWrite(t, local.Open(opts), key, lorem)
+	// Simulate a store created by older versions.
+	if err := os.Remove(manifest); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	testWrite(t, local.Open(opts), key, lorem)
+	if _, err := os.Lstat(manifest); !os.IsNotExist(err) {
+		t.Errorf("Manifest should not be written for existing stores, got %v", err)
+	}
+	if _, err := local.OpenExisting(root); err != local.ErrNoManifest {
+		t.Errorf("OpenExisting without manifest expected ErrNoManifest, got %v", err)
+	}
+
+	if err := local.WriteManifest(opts); err != nil {
+		t.Fatalf("WriteManifest failed: %v", err)
+	}
+	db, err := local.OpenExisting(root)
+	if err != nil {
+		t.Fatalf("OpenExisting failed: %v", err)
+	}
+	testRead(t, db, key, lorem)
+}
+
+func TestManifestMigration(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	oldOpts := local.NewDefaultOptions(root)
+	keys := []fsdb.Key{fsdb.Key("foo"), fsdb.Key("bar")}
+	for _, key := range keys {
+		testWrite(t, local.Open(oldOpts), key, lorem)
+	}
+
+	newOpts := func() local.OptionsBuilder {
+		return local.NewDefaultOptions(root).SetDirLevel(2)
+	}
+	db := local.Open(newOpts().SetMigrateFrom(oldOpts))
+	testRead(t, db, keys[0], lorem)
+
+	// Both the old and the new options alone are rejected during migration.
+	for label, opts := range map[string]local.Options{
+		"old": oldOpts,
+		"new": newOpts(),
+	} {
+		_, err := local.Open(opts).Read(ctx, keys[1])
+		if !local.IsManifestMismatchError(err) {
+			t.Errorf(
+				"%s: Read during migration expected ManifestMismatchError, got %v",
+				label,
+				err,
+			)
+		}
+	}
+
+	// The migration is recorded in the manifest, so it can be resumed.
+	db, err = local.OpenExisting(root)
+	if err != nil {
+		t.Fatalf("OpenExisting failed: %v", err)
+	}
+	for _, key := range keys {
+		testRead(t, db, key, lorem)
+	}
+	if _, err := local.Migrate(ctx, db, nil); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	// Only the new options are accepted after migration.
+	_, err = local.Open(oldOpts).Read(ctx, keys[1])
+	if !local.IsManifestMismatchError(err) {
+		t.Errorf("Read with old options expected ManifestMismatchError, got %v", err)
+	}
+	db = local.Open(newOpts())
+	for _, key := range keys {
+		testRead(t, db, key, lorem)
+	}
+	opts, err := local.LoadOptions(root)
+	if err != nil {
+		t.Fatalf("LoadOptions failed: %v", err)
+	}
+	if opts.GetMigrateFrom() != nil || opts.GetDirLevel() != 2 {
+		t.Errorf("Unexpected options after migration: %+v", opts)
+	}
+}
+
+func TestRegisterHashFunc(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterHashFunc with duplicate name should panic")
+		}
+	}()
+	local.RegisterHashFunc("sha256", sha256.New)
+}
@@ -0,0 +1,39 @@
+package local
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.MetadataFSDB interface.
+var _ fsdb.MetadataFSDB = (*impl)(nil)
+
+func (db *impl) WriteWithMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	metadata fsdb.Metadata,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		metadata: metadata,
+	})
+}
+
+func (db *impl) ReadMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+) (fsdb.Metadata, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	_, entry, err := db.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Metadata, nil
+}
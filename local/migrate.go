@@ -0,0 +1,237 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies Migrator interface.
+var _ Migrator = (*impl)(nil)
+
+// ErrNotMigrating is the error returned by Migrate when the FSDB is not opened
+// with OptionsBuilder.SetMigrateFrom.
+var ErrNotMigrating = errors.New("local: no layout to migrate from")
+
+// MigrateStats are the stats of a Migrate run.
+type MigrateStats struct {
+	// Entries is the number of keys checked, in either layout.
+	Entries int64
+
+	// Migrated is the number of entries moved from the old layout into the new
+	// layout.
+	Migrated int64
+
+	// Superseded is the number of entries removed from the old layout because
+	// they were already overwritten in the new layout.
+	Superseded int64
+}
+
+// Migrator defines the extra interface implemented by local FSDB to move the
+// entries from an old layout into the current one,
+// see OptionsBuilder.SetMigrateFrom.
+type Migrator interface {
+	// Migrate scans all the entries and moves the ones in the old layout into
+	// the new layout.
+	//
+	// Entries are moved by renaming their directories,
+	// so it's cheap and atomic per entry.
+	// If it's interrupted, e.g. by a crash,
+	// just run it again with the same options to resume.
+	//
+	// keyFunc is called with every key migrated,
+	// and returning false from it stops the migration,
+	// which could be resumed later the same way.
+	// It could be nil.
+	//
+	// It keeps going when it fails to migrate an entry,
+	// and returns the first error in the end.
+	// It returns ErrNotMigrating if there's no old layout to migrate from.
+	Migrate(ctx context.Context, keyFunc fsdb.KeyFunc) (MigrateStats, error)
+}
+
+// Migrate moves the entries of db from the old layout into the current one.
+//
+// If db does not implement Migrator, it returns fsdb.ErrNotSupported.
+func Migrate(
+	ctx context.Context,
+	db fsdb.FSDB,
+	keyFunc fsdb.KeyFunc,
+) (MigrateStats, error) {
+	if m, ok := db.(Migrator); ok {
+		return m.Migrate(ctx, keyFunc)
+	}
+	return MigrateStats{}, fsdb.ErrNotSupported
+}
+
+func (db *impl) Migrate(
+	ctx context.Context,
+	keyFunc fsdb.KeyFunc,
+) (MigrateStats, error) {
+	var stats MigrateStats
+	if db.opts.GetMigrateFrom() == nil {
+		return stats, ErrNotMigrating
+	}
+
+	// Collect the keys first,
+	// as moving the entries while walking could get them visited again.
+	var keys []fsdb.Key
+	seen := make(map[string]bool)
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			// An entry in both layouts is scanned twice.
+			if !seen[string(key)] {
+				seen[string(key)] = true
+				keys = append(keys, key)
+			}
+			return true
+		},
+		fsdb.IgnoreAll,
+	); err != nil {
+		return stats, err
+	}
+
+	var migrateErr error
+	for _, key := range keys {
+		select {
+		default:
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		}
+
+		result, err := db.migrateKey(key)
+		if err != nil {
+			// Keep migrating other entries, but report the first error.
+			if migrateErr == nil {
+				migrateErr = err
+			}
+			continue
+		}
+		stats.Entries++
+		switch result {
+		case migrateSuperseded:
+			stats.Superseded++
+		case migrateMoved:
+			stats.Migrated++
+			if keyFunc != nil && !keyFunc(key) {
+				// Stopped before finishing,
+				// so the manifest still records the migration.
+				return stats, migrateErr
+			}
+		}
+	}
+
+	if migrateErr != nil {
+		return stats, migrateErr
+	}
+	// The migration is finished.
+	return stats, WriteManifest(db.opts)
+}
+
+// migrateResult is the result of migrateKey.
+type migrateResult int
+
+const (
+	// The entry is not in the old layout.
+	migrateNone migrateResult = iota
+
+	// The entry is moved from the old layout into the new layout.
+	migrateMoved
+
+	// The entry in the old layout is removed,
+	// as there's already one in the new layout.
+	migrateSuperseded
+)
+
+// dirForKey returns the entry directory of key,
+// after moving the entry from the old layout into it if needed.
+func (db *impl) dirForKey(key fsdb.Key) (string, error) {
+	if db.err != nil {
+		return "", db.err
+	}
+	if _, err := db.migrateKey(key); err != nil {
+		return "", err
+	}
+	return db.opts.GetDirForKey(key), nil
+}
+
+// migrateKey moves the entry of key from the old layout into the new layout,
+// if there's a migration in progress and the entry is still in the old
+// layout.
+//
+// If the entry exists in both layouts,
+// the one in the new layout is newer and the one in the old layout is
+// removed.
+func (db *impl) migrateKey(key fsdb.Key) (migrateResult, error) {
+	from := db.opts.GetMigrateFrom()
+	if from == nil {
+		return migrateNone, nil
+	}
+	oldDir := from.GetDirForKey(key)
+	newDir := db.opts.GetDirForKey(key)
+	if oldDir == newDir {
+		return migrateNone, nil
+	}
+	oldKeyFile := oldDir + KeyFilename
+	if _, err := os.Lstat(oldKeyFile); os.IsNotExist(err) {
+		return migrateNone, nil
+	}
+	if err := checkKeyCollision(key, oldKeyFile); err != nil {
+		if IsKeyCollisionError(err) {
+			// It's the entry of another key.
+			return migrateNone, nil
+		}
+		return migrateNone, err
+	}
+
+	unlock := lockDirs(oldDir, newDir)
+	defer unlock()
+
+	// Check again with the locks held.
+	if _, err := os.Lstat(oldKeyFile); os.IsNotExist(err) {
+		return migrateNone, nil
+	}
+	if _, err := os.Lstat(newDir + KeyFilename); err == nil {
+		if err := removeEntry(oldDir); err != nil {
+			return migrateNone, err
+		}
+		removeEmptyDirs(oldDir, from.GetRootDataDir())
+		return migrateSuperseded, nil
+	}
+
+	// Without the key file, anything in the new directory is left over by an
+	// unfinished write.
+	if err := os.RemoveAll(newDir); err != nil {
+		return migrateNone, err
+	}
+	parent := filepath.Dir(strings.TrimSuffix(newDir, PathSeparator))
+	if err := os.MkdirAll(parent, FileModeForDirs); err != nil && !os.IsExist(err) {
+		return migrateNone, err
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return migrateNone, err
+	}
+	removeEmptyDirs(oldDir, from.GetRootDataDir())
+	return migrateMoved, nil
+}
+
+// removeEmptyDirs removes the parent directories of the entry directory dir
+// under root if they are empty.
+func removeEmptyDirs(dir, root string) {
+	dir = strings.TrimSuffix(dir, PathSeparator)
+	for {
+		dir = filepath.Dir(dir)
+		if !strings.HasPrefix(dir, root) || len(dir) < len(root) {
+			return
+		}
+		// It only works if dir is empty, which is exactly what we want.
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
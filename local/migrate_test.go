@@ -0,0 +1,314 @@
+package local_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestMigrateConformance(t *testing.T) {
+	fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+		root, err := ioutil.TempDir("", "fsdb_")
+		if err != nil {
+			t.Fatalf("failed to get tmp dir: %v", err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(root)
+		})
+		return local.Open(local.NewDefaultOptions(root).
+			SetDirLevel(2).
+			SetMigrateFrom(local.NewDefaultOptions(root)))
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	for _, c := range []struct {
+		label   string
+		newOpts func(root string) local.OptionsBuilder
+	}{
+		{
+			label: "dir-level",
+			newOpts: func(root string) local.OptionsBuilder {
+				return local.NewDefaultOptions(root).SetDirLevel(1)
+			},
+		},
+		{
+			label: "hash-func",
+			newOpts: func(root string) local.OptionsBuilder {
+				return local.NewDefaultOptions(root).SetHashFunc(sha256.New)
+			},
+		},
+		{
+			label: "data-dir",
+			newOpts: func(root string) local.OptionsBuilder {
+				return local.NewDefaultOptions(root).
+					SetDataDir("data2").
+					SetDirLevel(4)
+			},
+		},
+	} {
+		c := c
+		t.Run(c.label, func(t *testing.T) {
+			testMigrate(t, c.newOpts)
+		})
+	}
+}
+
+func testMigrate(t *testing.T, newOpts func(root string) local.OptionsBuilder) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	oldOpts := local.NewDefaultOptions(root)
+	oldDB := local.Open(oldOpts)
+	contents := map[string]string{
+		"foo":     lorem,
+		"bar":     "",
+		"baz":     strings.Repeat(lorem, 10),
+		"read":    "read before migration",
+		"written": "to be overwritten",
+		"deleted": "to be deleted",
+		"stale":   "to be superseded",
+	}
+	for key, content := range contents {
+		testWrite(t, oldDB, fsdb.Key(key), content)
+	}
+	metadata := fsdb.Metadata{"foo": "bar"}
+	if err := oldDB.(fsdb.MetadataFSDB).WriteWithMetadata(
+		ctx,
+		fsdb.Key("metadata"),
+		strings.NewReader(lorem),
+		metadata,
+	); err != nil {
+		t.Fatalf("WriteWithMetadata failed: %v", err)
+	}
+	contents["metadata"] = lorem
+	info, err := oldDB.(fsdb.Stater).Stat(ctx, fsdb.Key("foo"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	generation := info.Generation
+
+	// An entry already in the new layout, written by an instance not aware of
+	// the migration, supersedes the one in the old layout.
+	// The manifest is hidden from that instance, otherwise it refuses to write.
+	manifest := root + local.PathSeparator + local.ManifestFilename
+	if err := os.Rename(manifest, manifest+".bak"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	testWrite(t, local.Open(newOpts(root)), fsdb.Key("stale"), "superseding")
+	contents["stale"] = "superseding"
+	if err := os.Rename(manifest+".bak", manifest); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	opts := newOpts(root).SetMigrateFrom(oldOpts)
+	db := local.Open(opts)
+
+	// Reads are served from the old layout before migration.
+	testRead(t, db, fsdb.Key("read"), contents["read"])
+	info, err = db.(fsdb.Stater).Stat(ctx, fsdb.Key("foo"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Generation != generation {
+		t.Errorf("Generation expected %d, got %d", generation, info.Generation)
+	}
+	testWrite(t, db, fsdb.Key("written"), "overwritten")
+	contents["written"] = "overwritten"
+	if err := db.Delete(ctx, fsdb.Key("deleted")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	delete(contents, "deleted")
+	testReadEmpty(t, db, fsdb.Key("deleted"))
+
+	// Keys from both layouts are scanned,
+	// stale is in both layouts so it could be scanned twice.
+	var keys []string
+	seen := make(map[string]bool)
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			if !seen[string(key)] {
+				seen[string(key)] = true
+				keys = append(keys, string(key))
+			}
+			return true
+		},
+		fsdb.StopAll,
+	); err != nil {
+		t.Fatalf("ScanKeys failed: %v", err)
+	}
+	var expected []string
+	for key := range contents {
+		expected = append(expected, key)
+	}
+	sort.Strings(keys)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("ScanKeys expected %v, got %v", expected, keys)
+	}
+
+	var migrated []string
+	stats, err := local.Migrate(ctx, db, func(key fsdb.Key) bool {
+		migrated = append(migrated, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	// foo, read and written are already migrated, stale is superseded.
+	sort.Strings(migrated)
+	expectedMigrated := []string{"bar", "baz", "metadata"}
+	if !reflect.DeepEqual(migrated, expectedMigrated) {
+		t.Errorf("Migrate expected to migrate %v, got %v", expectedMigrated, migrated)
+	}
+	if stats.Entries != int64(len(contents)) ||
+		stats.Migrated != int64(len(expectedMigrated)) ||
+		stats.Superseded != 1 {
+		t.Errorf("Unexpected Migrate stats: %+v", stats)
+	}
+
+	stats, err = local.Migrate(ctx, db, nil)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if stats.Migrated != 0 || stats.Superseded != 0 {
+		t.Errorf("Migrate again expected nothing to migrate, got %+v", stats)
+	}
+
+	// Nothing left in the old layout.
+	for key := range contents {
+		dir := oldOpts.GetDirForKey(fsdb.Key(key))
+		if dir == opts.GetDirForKey(fsdb.Key(key)) {
+			continue
+		}
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) {
+			t.Errorf("Old entry directory %q expected to be removed, got %v", dir, err)
+		}
+	}
+
+	// Everything is readable without the old layout after migration,
+	// and the manifest is updated.
+	db, err = local.OpenExisting(root)
+	if err != nil {
+		t.Fatalf("OpenExisting failed: %v", err)
+	}
+	for key, content := range contents {
+		testRead(t, db, fsdb.Key(key), content)
+	}
+	info, err = db.(fsdb.Stater).Stat(ctx, fsdb.Key("foo"))
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Generation != generation {
+		t.Errorf("Generation expected %d, got %d", generation, info.Generation)
+	}
+	actual, err := db.(fsdb.MetadataFSDB).ReadMetadata(ctx, fsdb.Key("metadata"))
+	if err != nil {
+		t.Fatalf("ReadMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(actual, metadata) {
+		t.Errorf("ReadMetadata expected %v, got %v", metadata, actual)
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	oldOpts := local.NewDefaultOptions(root)
+	key := fsdb.Key("foo")
+	testWrite(t, local.Open(oldOpts), key, lorem)
+
+	// Leftover of a write interrupted before moving the key file.
+	opts := local.NewDefaultOptions(root).SetDirLevel(2)
+	dir := opts.GetDirForKey(key)
+	if err := os.MkdirAll(dir, local.FileModeForDirs); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := ioutil.WriteFile(
+		dir+local.DataFilename,
+		[]byte("garbage"),
+		local.FileModeForFiles,
+	); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	db := local.Open(opts.SetMigrateFrom(oldOpts))
+	stats, err := local.Migrate(ctx, db, nil)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if stats.Migrated != 1 {
+		t.Errorf("Migrate expected to migrate 1 entry, got %+v", stats)
+	}
+	testRead(t, local.Open(local.NewDefaultOptions(root).SetDirLevel(2)), key, lorem)
+}
+
+func TestMigrateStop(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	oldOpts := local.NewDefaultOptions(root).SetDirLevel(3)
+	oldDB := local.Open(oldOpts)
+	keys := []string{"foo", "bar", "baz", "qux"}
+	for _, key := range keys {
+		testWrite(t, oldDB, fsdb.Key(key), key)
+	}
+
+	db := local.Open(local.NewDefaultOptions(root).
+		SetDirLevel(2).
+		SetMigrateFrom(oldOpts))
+	stats, err := local.Migrate(ctx, db, func(fsdb.Key) bool {
+		return false
+	})
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if stats.Migrated != 1 {
+		t.Errorf("Stopped Migrate expected to migrate 1 entry, got %+v", stats)
+	}
+
+	// The manifest still records the unfinished migration.
+	db, err = local.OpenExisting(root)
+	if err != nil {
+		t.Fatalf("OpenExisting failed: %v", err)
+	}
+	for _, key := range keys {
+		testRead(t, db, fsdb.Key(key), key)
+	}
+}
+
+func TestMigrateNotMigrating(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	db := local.Open(local.NewDefaultOptions(root))
+	if _, err := local.Migrate(context.Background(), db, nil); err != local.ErrNotMigrating {
+		t.Errorf("Migrate expected ErrNotMigrating, got %v", err)
+	}
+}
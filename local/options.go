@@ -7,6 +7,7 @@ import (
 	"hash"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fishy/fsdb"
 )
@@ -25,6 +26,18 @@ const (
 
 	DefaultUseGzip   = false
 	DefaultGzipLevel = gzip.DefaultCompression
+
+	DefaultAdaptiveCompression   = false
+	DefaultMinCompressionRatio   = 1.1
+	DefaultCompressionSampleSize = 64 * 1024
+
+	DefaultUseEncryption = false
+
+	DefaultVerifyChecksum = true
+
+	DefaultTTL time.Duration = 0
+
+	DefaultBatchThreadNum = fsdb.DefaultBatchThreadNum
 )
 
 // DefaultHashFunc is the default hash function, which is SHA-512/224.
@@ -35,6 +48,10 @@ var DefaultHashFunc = sha512.New512_224
 
 // Options defines a read only view of options used by local fsdb.
 type Options interface {
+	// GetRoot returns the root directory,
+	// guaranteed to end with PathSeparator.
+	GetRoot() string
+
 	// GetRootDataDir returns the full path of the root data directory,
 	// guaranteed to end with PathSeparator.
 	GetRootDataDir() string
@@ -46,19 +63,67 @@ type Options interface {
 	// GetHashFunc returns the hash function used in keys.
 	GetHashFunc() func() hash.Hash
 
+	// GetDirLevel returns the directory level used in filenames.
+	GetDirLevel() int
+
 	// GetDirForKey returns the directory to put entry in,
 	// guaranteed to end with PathSeparator and guaranteed to be under root data
 	// directory.
 	GetDirForKey(key fsdb.Key) string
 
+	// GetUseGzip returns whether the codec is gzip.
 	GetUseGzip() bool
+
+	// GetGzipLevel returns the level used by SetUseGzip.
 	GetGzipLevel() int
+
+	// GetCodec returns the Codec used to compress new data files,
+	// or nil if they are not compressed.
+	GetCodec() Codec
+
+	// GetAdaptiveCompression returns whether to store the data uncompressed
+	// when it doesn't compress well.
+	GetAdaptiveCompression() bool
+
+	// GetMinCompressionRatio returns the minimal compression ratio for the data
+	// to be stored compressed in adaptive compression.
+	GetMinCompressionRatio() float64
+
+	// GetCompressionSampleSize returns the size of the sample used to decide
+	// the compression ratio in adaptive compression.
+	GetCompressionSampleSize() int
+
+	// GetUseEncryption returns whether to encrypt new data files.
+	GetUseEncryption() bool
+
+	// GetKeyProvider returns the KeyProvider used to encrypt and decrypt data
+	// files, or nil if there's none.
+	GetKeyProvider() KeyProvider
+
+	// GetVerifyChecksum returns whether to verify the checksums of entries on
+	// Read.
+	GetVerifyChecksum() bool
+
+	// GetTTL returns the default time-to-live for entries written without an
+	// explicit TTL, or 0 if they never expire.
+	GetTTL() time.Duration
+
+	// GetBatchThreadNum returns the number of threads used in batch operations.
+	GetBatchThreadNum() int
+
+	// GetMigrateFrom returns the options of the old layout being migrated
+	// from, or nil if there's no migration in progress.
+	GetMigrateFrom() Options
 }
 
 // OptionsBuilder defines a read-write view of options used by local fsdb.
 //
-// Gzip related options are safe to change on an existing FSDB system.
-// Changing other options will break the existing FSDB system.
+// Codec, gzip, adaptive compression, encryption, checksum, TTL and batch
+// related options are safe to change on an existing FSDB system.
+// Changing other options will break the existing FSDB system,
+// unless it's migrated via SetMigrateFrom.
+// They are recorded in the manifest under the root directory,
+// see Open.
 type OptionsBuilder interface {
 	Options
 
@@ -84,20 +149,109 @@ type OptionsBuilder interface {
 	SetDirLevel(level int) OptionsBuilder
 
 	// SetUseGzip sets whether to use gzip for storage.
+	//
+	// SetUseGzip(true) is a shortcut of SetCodec(NewGzipCodec(GetGzipLevel())),
+	// and SetUseGzip(false) is a shortcut of SetCodec(nil).
 	SetUseGzip(gzip bool) OptionsBuilder
 
 	// SetGzipLevel sets the level used in gzip compression.
+	//
+	// If the current codec is gzip, it's replaced by one with the new level.
 	SetGzipLevel(level int) OptionsBuilder
+
+	// SetCodec sets the Codec used to compress new data files.
+	//
+	// nil means no compression.
+	// Data files written by other registered codecs are still readable,
+	// see RegisterCodec.
+	SetCodec(codec Codec) OptionsBuilder
+
+	// SetAdaptiveCompression sets whether to store the data uncompressed when
+	// it doesn't compress well, e.g. JPEG images or MP4 videos.
+	//
+	// When enabled, the first CompressionSampleSize bytes of the data are
+	// compressed by the codec in memory first.
+	// If the compression ratio (uncompressed size divided by compressed size)
+	// of the sample is below MinCompressionRatio,
+	// the data is stored uncompressed.
+	//
+	// It has no effect when there's no codec.
+	SetAdaptiveCompression(adaptive bool) OptionsBuilder
+
+	// SetMinCompressionRatio sets the minimal compression ratio for the data to
+	// be stored compressed in adaptive compression.
+	SetMinCompressionRatio(ratio float64) OptionsBuilder
+
+	// SetCompressionSampleSize sets the size of the sample used to decide the
+	// compression ratio in adaptive compression.
+	//
+	// The sample is buffered in memory.
+	// Non-positive size means DefaultCompressionSampleSize.
+	SetCompressionSampleSize(size int) OptionsBuilder
+
+	// SetUseEncryption sets whether to encrypt new data files.
+	//
+	// It requires a KeyProvider set via SetKeyProvider.
+	SetUseEncryption(encrypt bool) OptionsBuilder
+
+	// SetKeyProvider sets the KeyProvider used to encrypt and decrypt data
+	// files.
+	//
+	// It's needed to read encrypted data files even if new data files are not
+	// encrypted.
+	SetKeyProvider(provider KeyProvider) OptionsBuilder
+
+	// SetVerifyChecksum sets whether to verify the checksums of entries on Read.
+	//
+	// Checksums are always stored on writes regardless of this option.
+	// Disabling verification saves the hashing on reads for latency sensitive
+	// use cases, at the cost of returning corrupt data silently.
+	SetVerifyChecksum(verify bool) OptionsBuilder
+
+	// SetTTL sets the default time-to-live for entries written without an
+	// explicit TTL.
+	//
+	// 0 means they never expire.
+	// Changing it only affects entries written afterwards.
+	SetTTL(ttl time.Duration) OptionsBuilder
+
+	// SetBatchThreadNum sets the number of threads used in batch operations.
+	SetBatchThreadNum(threads int) OptionsBuilder
+
+	// SetMigrateFrom sets the options of the old layout to migrate from,
+	// when changing the hash function, directory levels, or data directory of
+	// an existing FSDB system.
+	//
+	// Entries still in the old layout are moved into the new layout the first
+	// time they are accessed, and Migrate moves all of them.
+	// Only the layout related options (root data directory, hash function and
+	// directory levels) of old are used.
+	// The old data directory must be on the same filesystem as the new one.
+	//
+	// During the migration, all the FSDB instances on the same root must be
+	// opened with it, otherwise they could miss or recreate the entries in the
+	// old layout.
+	// Once Migrate returns nil error, it's no longer needed.
+	SetMigrateFrom(old Options) OptionsBuilder
 }
 
 type options struct {
-	root      string
-	data      string
-	tmp       string
-	hashFunc  func() hash.Hash
-	dirLevel  int
-	useGzip   bool
-	gzipLevel int
+	root       string
+	data       string
+	tmp        string
+	hashFunc   func() hash.Hash
+	dirLevel   int
+	codec      Codec
+	gzipLevel  int
+	adaptive   bool
+	minRatio   float64
+	sampleSize int
+	encrypt    bool
+	keys       KeyProvider
+	verify     bool
+	ttl        time.Duration
+	threads    int
+	from       Options
 }
 
 // NewDefaultOptions creates an OptionsBuilder with default options.
@@ -105,15 +259,27 @@ func NewDefaultOptions(root string) OptionsBuilder {
 	if !strings.HasSuffix(root, PathSeparator) {
 		root += PathSeparator
 	}
-	return &options{
-		root:      root,
-		data:      DefaultDataDir,
-		tmp:       DefaultTempDir,
-		hashFunc:  DefaultHashFunc,
-		dirLevel:  DefaultDirLevel,
-		useGzip:   DefaultUseGzip,
-		gzipLevel: DefaultGzipLevel,
+	opts := &options{
+		root:       root,
+		data:       DefaultDataDir,
+		tmp:        DefaultTempDir,
+		hashFunc:   DefaultHashFunc,
+		dirLevel:   DefaultDirLevel,
+		gzipLevel:  DefaultGzipLevel,
+		adaptive:   DefaultAdaptiveCompression,
+		minRatio:   DefaultMinCompressionRatio,
+		sampleSize: DefaultCompressionSampleSize,
+		encrypt:    DefaultUseEncryption,
+		verify:     DefaultVerifyChecksum,
+		ttl:        DefaultTTL,
+		threads:    DefaultBatchThreadNum,
 	}
+	opts.SetUseGzip(DefaultUseGzip)
+	return opts
+}
+
+func (opts *options) GetRoot() string {
+	return opts.root
 }
 
 func (opts *options) GetRootDataDir() string {
@@ -128,6 +294,10 @@ func (opts *options) GetHashFunc() func() hash.Hash {
 	return opts.hashFunc
 }
 
+func (opts *options) GetDirLevel() int {
+	return opts.dirLevel
+}
+
 func (opts *options) GetDirForKey(key fsdb.Key) string {
 	h := opts.GetHashFunc()()
 	h.Write(key)
@@ -149,13 +319,53 @@ func (opts *options) GetDirForKey(key fsdb.Key) string {
 }
 
 func (opts *options) GetUseGzip() bool {
-	return opts.useGzip
+	return opts.codec != nil && opts.codec.Name() == fsdb.CodecGzip
 }
 
 func (opts *options) GetGzipLevel() int {
 	return opts.gzipLevel
 }
 
+func (opts *options) GetCodec() Codec {
+	return opts.codec
+}
+
+func (opts *options) GetAdaptiveCompression() bool {
+	return opts.adaptive
+}
+
+func (opts *options) GetMinCompressionRatio() float64 {
+	return opts.minRatio
+}
+
+func (opts *options) GetCompressionSampleSize() int {
+	return opts.sampleSize
+}
+
+func (opts *options) GetUseEncryption() bool {
+	return opts.encrypt
+}
+
+func (opts *options) GetKeyProvider() KeyProvider {
+	return opts.keys
+}
+
+func (opts *options) GetVerifyChecksum() bool {
+	return opts.verify
+}
+
+func (opts *options) GetTTL() time.Duration {
+	return opts.ttl
+}
+
+func (opts *options) GetBatchThreadNum() int {
+	return opts.threads
+}
+
+func (opts *options) GetMigrateFrom() Options {
+	return opts.from
+}
+
 func (opts *options) Build() Options {
 	return opts
 }
@@ -187,11 +397,68 @@ func (opts *options) SetDirLevel(level int) OptionsBuilder {
 }
 
 func (opts *options) SetUseGzip(gzip bool) OptionsBuilder {
-	opts.useGzip = gzip
+	if gzip {
+		opts.codec = NewGzipCodec(opts.gzipLevel)
+	} else {
+		opts.codec = nil
+	}
 	return opts
 }
 
 func (opts *options) SetGzipLevel(level int) OptionsBuilder {
 	opts.gzipLevel = level
+	if opts.GetUseGzip() {
+		opts.codec = NewGzipCodec(level)
+	}
+	return opts
+}
+
+func (opts *options) SetCodec(codec Codec) OptionsBuilder {
+	opts.codec = codec
+	return opts
+}
+
+func (opts *options) SetAdaptiveCompression(adaptive bool) OptionsBuilder {
+	opts.adaptive = adaptive
+	return opts
+}
+
+func (opts *options) SetMinCompressionRatio(ratio float64) OptionsBuilder {
+	opts.minRatio = ratio
+	return opts
+}
+
+func (opts *options) SetCompressionSampleSize(size int) OptionsBuilder {
+	opts.sampleSize = size
+	return opts
+}
+
+func (opts *options) SetUseEncryption(encrypt bool) OptionsBuilder {
+	opts.encrypt = encrypt
+	return opts
+}
+
+func (opts *options) SetKeyProvider(provider KeyProvider) OptionsBuilder {
+	opts.keys = provider
+	return opts
+}
+
+func (opts *options) SetVerifyChecksum(verify bool) OptionsBuilder {
+	opts.verify = verify
+	return opts
+}
+
+func (opts *options) SetTTL(ttl time.Duration) OptionsBuilder {
+	opts.ttl = ttl
+	return opts
+}
+
+func (opts *options) SetBatchThreadNum(threads int) OptionsBuilder {
+	opts.threads = threads
+	return opts
+}
+
+func (opts *options) SetMigrateFrom(old Options) OptionsBuilder {
+	opts.from = old
 	return opts
 }
@@ -0,0 +1,91 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/fishy/wrapreader"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.RangeReader interface.
+var _ fsdb.RangeReader = (*impl)(nil)
+
+// ReadRange reads a byte window of an entry.
+//
+// For uncompressed and unencrypted entries it seeks directly to offset.
+// For other entries it decodes and discards the data before offset.
+func (db *impl) ReadRange(
+	ctx context.Context,
+	key fsdb.Key,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fsdb.ErrNegativeOffset
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	dir, _, err := db.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var result io.ReadCloser
+	err = retryMissingData(key, dir, func() error {
+		for _, name := range db.dataFilenames() {
+			var reader io.ReadCloser
+			var err error
+			if name == DataFilename {
+				reader, err = readPlainRange(dir, offset, length)
+			} else {
+				reader, err = db.readDataRange(key, dir, name, offset, length)
+			}
+			if os.IsNotExist(err) {
+				continue
+			}
+			result = reader
+			return err
+		}
+		return errNoDataFile
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readPlainRange reads a byte window of the uncompressed data file.
+func readPlainRange(dir string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(dir + DataFilename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return wrapreader.Wrap(io.LimitReader(file, length), file), nil
+}
+
+// readDataRange reads a byte window of a compressed or encrypted data file.
+func (db *impl) readDataRange(
+	key fsdb.Key,
+	dir, name string,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	reader, err := db.readData(key, dir, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return fsdb.LimitRange(reader, offset, length)
+}
@@ -0,0 +1,150 @@
+package local
+
+import (
+	"context"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies Recompressor interface.
+var _ Recompressor = (*impl)(nil)
+
+// RecompressOptions are the options used by Recompress.
+type RecompressOptions struct {
+	// BytesPerSecond limits the rate of reading data files from the disk.
+	//
+	// 0 means unlimited.
+	BytesPerSecond int64
+
+	// All, if true, also rewrites the entries already stored with the current
+	// codec,
+	// e.g. to apply a new compression level.
+	//
+	// The compression level is not recorded in the data files,
+	// so there's no way to tell which level an entry is compressed with.
+	All bool
+
+	// KeyFunc, if not nil, is called with every entry rewritten.
+	//
+	// It should return true to continue and false to abort.
+	KeyFunc func(r Recompression) bool
+}
+
+// Recompression describes an entry rewritten by Recompress.
+type Recompression struct {
+	Key fsdb.Key
+
+	// OldSize and NewSize are the sizes of the data file before and after.
+	OldSize int64
+	NewSize int64
+}
+
+// RecompressStats are the stats of a Recompress run.
+type RecompressStats struct {
+	// Entries is the number of entries checked.
+	Entries int64
+
+	// Rewritten is the number of entries rewritten.
+	Rewritten int64
+
+	// Skipped is the number of entries skipped because they were deleted or
+	// overwritten concurrently.
+	Skipped int64
+
+	// OldBytes and NewBytes are the total sizes of the data files of the
+	// rewritten entries, before and after.
+	OldBytes int64
+	NewBytes int64
+}
+
+// SavedBytes returns the number of bytes saved on the disk,
+// which could be negative.
+func (s RecompressStats) SavedBytes() int64 {
+	return s.OldBytes - s.NewBytes
+}
+
+// Recompressor defines the extra interface implemented by local FSDB to
+// rewrite existing entries per the current compression options.
+type Recompressor interface {
+	// Recompress scans all the entries and rewrites the data files not
+	// compressed with the current codec (see Options.GetCodec).
+	// If there's no codec, compressed entries are decompressed.
+	//
+	// With adaptive compression (see Options.GetAdaptiveCompression),
+	// entries that don't compress well are stored uncompressed instead.
+	// Uncompressed entries are tried every time,
+	// but only rewritten when they compress well.
+	//
+	// The encryption of the entries is kept as is.
+	// The generation and other info of the entries are not changed,
+	// and no events are emitted to the watchers.
+	// Entries written or deleted concurrently are skipped.
+	//
+	// It keeps going when it fails to rewrite an entry,
+	// and returns the first error in the end.
+	Recompress(ctx context.Context, opts RecompressOptions) (
+		RecompressStats,
+		error,
+	)
+}
+
+// Recompress rewrites the entries of db per its current compression options.
+//
+// If db does not implement Recompressor, it returns fsdb.ErrNotSupported.
+func Recompress(
+	ctx context.Context,
+	db fsdb.FSDB,
+	opts RecompressOptions,
+) (RecompressStats, error) {
+	if r, ok := db.(Recompressor); ok {
+		return r.Recompress(ctx, opts)
+	}
+	return RecompressStats{}, fsdb.ErrNotSupported
+}
+
+func (db *impl) Recompress(
+	ctx context.Context,
+	opts RecompressOptions,
+) (RecompressStats, error) {
+	var stats RecompressStats
+	codec := db.opts.GetCodec()
+	adaptive := db.opts.GetAdaptiveCompression()
+	err := db.rewriteAll(
+		ctx,
+		newRateLimiter(opts.BytesPerSecond),
+		func(key fsdb.Key, path, name string) (dataFormat, bool, error) {
+			current, encrypted, ok := db.parseDataFilename(name)
+			if !ok {
+				return dataFormat{}, false, unknownCodec(key, name)
+			}
+			format := dataFormat{
+				codec:    codec,
+				encrypt:  encrypted,
+				adaptive: adaptive,
+			}
+			return format, opts.All || codecName(current) != codecName(codec), nil
+		},
+		func(key fsdb.Key, result rewriteResult) bool {
+			if result.skipped {
+				stats.Skipped++
+				return true
+			}
+			stats.Entries++
+			if !result.rewritten {
+				return true
+			}
+			stats.Rewritten++
+			stats.OldBytes += result.oldSize
+			stats.NewBytes += result.newSize
+			if opts.KeyFunc != nil {
+				return opts.KeyFunc(Recompression{
+					Key:     key,
+					OldSize: result.oldSize,
+					NewSize: result.newSize,
+				})
+			}
+			return true
+		},
+	)
+	return stats, err
+}
@@ -0,0 +1,276 @@
+package local_test
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestRecompress(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	opts := local.NewDefaultOptions(root).SetKeyProvider(testKeys("key1"))
+	db := local.Open(opts)
+	random := make([]byte, 16*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	contents := map[string]string{
+		"lorem":           strings.Repeat(lorem, 100),
+		"random":          string(random),
+		"lorem-encrypted": strings.Repeat(lorem, 100),
+		"empty":           "",
+	}
+	generations := make(map[string]int64)
+	for key, content := range contents {
+		opts.SetUseEncryption(strings.HasSuffix(key, "-encrypted"))
+		testWrite(t, db, fsdb.Key(key), content)
+		info, err := db.(fsdb.Stater).Stat(ctx, fsdb.Key(key))
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		generations[key] = info.Generation
+	}
+	opts.SetUseEncryption(false)
+
+	recompress := func(
+		label string,
+		ro local.RecompressOptions,
+		rewritten int,
+		codecs map[string]string,
+	) local.RecompressStats {
+		t.Helper()
+		var count int
+		ro.KeyFunc = func(r local.Recompression) bool {
+			count++
+			return true
+		}
+		stats, err := local.Recompress(ctx, db, ro)
+		if err != nil {
+			t.Fatalf("%s: Recompress failed: %v", label, err)
+		}
+		if stats.Entries != int64(len(contents)) ||
+			stats.Rewritten != int64(rewritten) ||
+			count != rewritten {
+			t.Errorf(
+				"%s: expected to rewrite %d of %d entries, got %d, %+v",
+				label,
+				rewritten,
+				len(contents),
+				count,
+				stats,
+			)
+		}
+		for key, content := range contents {
+			testRead(t, db, fsdb.Key(key), content)
+			info, err := db.(fsdb.Stater).Stat(ctx, fsdb.Key(key))
+			if err != nil {
+				t.Fatalf("%s: Stat failed: %v", label, err)
+			}
+			if info.Generation != generations[key] {
+				t.Errorf("%s: Recompress changed generation of %q", label, key)
+			}
+			if info.Encrypted != strings.HasSuffix(key, "-encrypted") {
+				t.Errorf("%s: Recompress changed encryption of %q", label, key)
+			}
+			if expected := codecs[key]; info.Codec != expected {
+				t.Errorf(
+					"%s: %q expected codec %q, got %q",
+					label,
+					key,
+					expected,
+					info.Codec,
+				)
+			}
+		}
+		return stats
+	}
+
+	allCodecs := func(codec string) map[string]string {
+		m := make(map[string]string)
+		for key := range contents {
+			m[key] = codec
+		}
+		return m
+	}
+
+	stats := recompress(
+		"no-op",
+		local.RecompressOptions{},
+		0,
+		allCodecs(fsdb.CodecPlain),
+	)
+	if stats.SavedBytes() != 0 {
+		t.Errorf("no-op: expected no bytes saved, got %+v", stats)
+	}
+
+	opts.SetCodec(local.NewZlibCodec(-1))
+	stats = recompress(
+		"zlib",
+		local.RecompressOptions{},
+		len(contents),
+		allCodecs(fsdb.CodecZlib),
+	)
+	if stats.SavedBytes() <= 0 {
+		t.Errorf("zlib: expected bytes saved, got %+v", stats)
+	}
+	recompress("zlib-again", local.RecompressOptions{}, 0, allCodecs(fsdb.CodecZlib))
+	recompress(
+		"zlib-all",
+		local.RecompressOptions{All: true},
+		len(contents),
+		allCodecs(fsdb.CodecZlib),
+	)
+
+	opts.SetUseGzip(true).SetAdaptiveCompression(true)
+	adaptive := map[string]string{
+		"lorem":           fsdb.CodecGzip,
+		"random":          fsdb.CodecPlain,
+		"lorem-encrypted": fsdb.CodecGzip,
+		"empty":           fsdb.CodecPlain,
+	}
+	recompress("adaptive", local.RecompressOptions{}, len(contents), adaptive)
+	// The uncompressed ones are tried again, but not rewritten.
+	recompress("adaptive-again", local.RecompressOptions{}, 0, adaptive)
+
+	opts.SetCodec(nil)
+	stats = recompress(
+		"plain",
+		local.RecompressOptions{},
+		2,
+		allCodecs(fsdb.CodecPlain),
+	)
+	if stats.SavedBytes() >= 0 {
+		t.Errorf("plain: expected negative bytes saved, got %+v", stats)
+	}
+}
+
+func TestRecompressConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	db := local.Open(local.NewDefaultOptions(root))
+	recompressors := []fsdb.FSDB{
+		local.Open(local.NewDefaultOptions(root).SetUseGzip(true)),
+		local.Open(local.NewDefaultOptions(root)),
+	}
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+
+	done := make(chan struct{})
+	var last string
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			last = strings.Repeat(lorem, i+1)
+			if err := db.Write(ctx, key, strings.NewReader(last)); err != nil {
+				t.Errorf("Write failed: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; ; i++ {
+		r := recompressors[i%len(recompressors)]
+		if _, err := local.Recompress(ctx, r, local.RecompressOptions{}); err != nil {
+			t.Fatalf("Recompress failed: %v", err)
+		}
+		select {
+		default:
+			continue
+		case <-done:
+		}
+		break
+	}
+	testRead(t, db, key, last)
+}
+
+func TestRecompressRate(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+
+	const size = 16 * 1024
+	testWrite(t, db, fsdb.Key("foo"), strings.Repeat("a", size))
+	opts.SetUseGzip(true)
+	started := time.Now()
+	stats, err := local.Recompress(ctx, db, local.RecompressOptions{
+		BytesPerSecond: size * 5,
+	})
+	if err != nil {
+		t.Fatalf("Recompress failed: %v", err)
+	}
+	if stats.Rewritten != 1 || stats.OldBytes != size {
+		t.Errorf("Recompress expected to rewrite %d bytes, got %+v", size, stats)
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("Recompress at 1/5 of the size per second took only %v", elapsed)
+	}
+}
+
+func TestRecompressConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	dbs := []fsdb.FSDB{
+		local.Open(local.NewDefaultOptions(root).SetUseGzip(true)),
+		local.Open(local.NewDefaultOptions(root)),
+	}
+	key := fsdb.Key("foo")
+	content := strings.Repeat(lorem, 10)
+	testWrite(t, dbs[0], key, content)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			r := dbs[i%len(dbs)]
+			if _, err := local.Recompress(ctx, r, local.RecompressOptions{}); err != nil {
+				t.Errorf("Recompress failed: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; ; i++ {
+		reader, err := dbs[i%len(dbs)].Read(ctx, key)
+		if err != nil {
+			t.Fatalf("Read during recompress failed: %v", err)
+		}
+		buf, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Read content during recompress failed: %v", err)
+		}
+		if string(buf) != content {
+			t.Fatalf("Read during recompress expected %q, got %q", content, buf)
+		}
+		select {
+		default:
+			continue
+		case <-done:
+		}
+		break
+	}
+}
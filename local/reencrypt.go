@@ -0,0 +1,80 @@
+package local
+
+import (
+	"context"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies ReEncrypter interface.
+var _ ReEncrypter = (*impl)(nil)
+
+// ReEncrypter defines the extra interface implemented by local FSDB to
+// rewrite existing entries per the current encryption options.
+type ReEncrypter interface {
+	// ReEncrypt scans all the entries and rewrites the data files that are not
+	// encrypted with the current key per the current options:
+	//
+	// - If encryption is enabled, unencrypted entries and entries encrypted
+	// with other keys are encrypted with the current key;
+	//
+	// - If encryption is disabled, encrypted entries are decrypted.
+	//
+	// The compression of the entries is kept as is.
+	// The generation and other info of the entries are not changed,
+	// and no events are emitted to the watchers.
+	//
+	// keyFunc is called with every key rewritten,
+	// and returning false from it stops the scan.
+	// It could be nil.
+	//
+	// It keeps going when it fails to rewrite an entry,
+	// and returns the first error in the end.
+	ReEncrypt(ctx context.Context, keyFunc fsdb.KeyFunc) error
+}
+
+// ReEncrypt rewrites the entries of db per its current encryption options.
+//
+// If db does not implement ReEncrypter, it returns fsdb.ErrNotSupported.
+func ReEncrypt(ctx context.Context, db fsdb.FSDB, keyFunc fsdb.KeyFunc) error {
+	if r, ok := db.(ReEncrypter); ok {
+		return r.ReEncrypt(ctx, keyFunc)
+	}
+	return fsdb.ErrNotSupported
+}
+
+func (db *impl) ReEncrypt(ctx context.Context, keyFunc fsdb.KeyFunc) error {
+	encrypt := db.opts.GetUseEncryption()
+	return db.rewriteAll(
+		ctx,
+		nil,
+		func(key fsdb.Key, path, name string) (dataFormat, bool, error) {
+			codec, encrypted, ok := db.parseDataFilename(name)
+			if !ok {
+				return dataFormat{}, false, unknownCodec(key, name)
+			}
+			format := dataFormat{
+				codec:   codec,
+				encrypt: encrypt,
+			}
+			if !encrypted || !encrypt {
+				return format, encrypted != encrypt, nil
+			}
+			keys := db.opts.GetKeyProvider()
+			if keys == nil {
+				return dataFormat{}, false, ErrNoKeyProvider
+			}
+			id, err := readKeyID(key, path)
+			if err != nil {
+				return dataFormat{}, false, err
+			}
+			return format, id != keys.CurrentKeyID(), nil
+		},
+		func(key fsdb.Key, result rewriteResult) bool {
+			if result.rewritten && keyFunc != nil {
+				return keyFunc(key)
+			}
+			return true
+		},
+	)
+}
@@ -0,0 +1,233 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/fishy/fsdb"
+)
+
+// rewriteFunc decides how the data file of an entry should be rewritten.
+//
+// path is the full path of the current data file, and name is its filename.
+// It returns the format of the new data file,
+// and whether the data file needs to be rewritten at all.
+type rewriteFunc func(key fsdb.Key, path, name string) (
+	format dataFormat,
+	rewrite bool,
+	err error,
+)
+
+// rewriteResult is the result of rewriteData on an entry.
+type rewriteResult struct {
+	// rewritten is true if the data file is replaced.
+	rewritten bool
+
+	// skipped is true if the entry is deleted or overwritten concurrently,
+	// so it's left untouched.
+	skipped bool
+
+	// oldSize and newSize are the sizes of the old and new data files,
+	// only set when rewritten is true.
+	oldSize int64
+	newSize int64
+}
+
+// rewriteAll calls rewriteData on all the entries,
+// and resultFunc with the results.
+//
+// Returning false from resultFunc stops the scan.
+//
+// It keeps going when it fails to rewrite an entry,
+// and returns the first error in the end.
+func (db *impl) rewriteAll(
+	ctx context.Context,
+	limiter *rateLimiter,
+	f rewriteFunc,
+	resultFunc func(key fsdb.Key, result rewriteResult) bool,
+) error {
+	var rewriteErr error
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			result, err := db.rewriteData(ctx, key, limiter, f)
+			if err != nil {
+				if fsdb.IsCanceledError(err) {
+					return false
+				}
+				// Keep rewriting other entries, but report the first error.
+				if rewriteErr == nil {
+					rewriteErr = err
+				}
+				return true
+			}
+			return resultFunc(key, result)
+		},
+		fsdb.IgnoreAll,
+	); err != nil {
+		return err
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return rewriteErr
+}
+
+// rewriteData rewrites the data file of an entry in place if f says so.
+//
+// The data file is read through limiter, which could be nil.
+//
+// The info file, including the generation, is not changed.
+// If the entry is deleted or overwritten concurrently,
+// it's left untouched and no error is returned.
+func (db *impl) rewriteData(
+	ctx context.Context,
+	key fsdb.Key,
+	limiter *rateLimiter,
+	f rewriteFunc,
+) (rewriteResult, error) {
+	var result rewriteResult
+	select {
+	default:
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+
+	dir, entry, err := db.lookup(key)
+	if fsdb.IsNoSuchKeyError(err) {
+		result.skipped = true
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	name, err := db.findData(key, dir)
+	if err != nil {
+		return result, err
+	}
+	format, rewrite, err := f(key, dir+name, name)
+	if err != nil || !rewrite {
+		return result, err
+	}
+
+	tmpdir, err := db.getTempDir()
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	file, err := os.Open(dir + name)
+	if os.IsNotExist(err) {
+		result.skipped = true
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return result, err
+	}
+	reader, err := db.decodeData(key, name, &limitedReader{
+		ctx:     ctx,
+		reader:  file,
+		limiter: limiter,
+	})
+	if err != nil {
+		return result, err
+	}
+	defer reader.Close()
+	writer, err := db.createDataFormat(key, tmpdir, format)
+	if err != nil {
+		return result, err
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return result, err
+	}
+	if err = writer.Close(); err != nil {
+		return result, err
+	}
+	newName := writer.filename()
+	if format.adaptive &&
+		newName == name &&
+		newName == dataFilename(nil, format.encrypt) {
+		// It was uncompressed and still doesn't compress well,
+		// nothing to gain from replacing it.
+		return result, nil
+	}
+	newStat, err := os.Lstat(tmpdir + newName)
+	if err != nil {
+		return result, err
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return result, ctx.Err()
+	}
+
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	current, err := readGeneration(dir)
+	if err != nil {
+		return result, err
+	}
+	if current != entry.Generation {
+		// Overwritten or deleted since we read it.
+		result.skipped = true
+		return result, nil
+	}
+	if entry.Checksum != "" && writer.checksum() != entry.Checksum {
+		// Don't replace a corrupt data file with a seemingly valid one.
+		return result, &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: "rewrite failed",
+			Err: &ChecksumMismatchError{
+				Expected: entry.Checksum,
+				Actual:   writer.checksum(),
+			},
+		}
+	}
+	if err = os.Rename(tmpdir+newName, dir+newName); err != nil {
+		return result, err
+	}
+	for _, file := range db.dataFilenames() {
+		if file == newName {
+			continue
+		}
+		if err = os.Remove(dir + file); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+	result.rewritten = true
+	result.oldSize = stat.Size()
+	result.newSize = newStat.Size()
+	return result, nil
+}
+
+// findData returns the name of the data file under the entry directory.
+func (db *impl) findData(key fsdb.Key, dir string) (string, error) {
+	var result string
+	err := retryMissingData(key, dir, func() error {
+		for _, name := range db.dataFilenames() {
+			_, err := os.Lstat(dir + name)
+			if err == nil {
+				result = name
+				return nil
+			}
+			if !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return errNoDataFile
+	})
+	return result, err
+}
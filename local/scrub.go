@@ -0,0 +1,305 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies Scrubber interface.
+var _ Scrubber = (*impl)(nil)
+
+// ScrubOptions are the options used by Scrub.
+type ScrubOptions struct {
+	// BytesPerSecond limits the rate of reading data files from the disk.
+	//
+	// 0 means unlimited.
+	BytesPerSecond int64
+
+	// Repair, if not nil, is called with every corrupt entry found,
+	// before CorruptFunc.
+	//
+	// It should return true if the entry is repaired,
+	// or false if it can't be repaired, e.g. there's no other copy.
+	Repair func(ctx context.Context, key fsdb.Key) (bool, error)
+
+	// CorruptFunc, if not nil, is called with every corrupt entry found.
+	//
+	// It should return true to continue and false to abort.
+	CorruptFunc func(c Corruption) bool
+}
+
+// Corruption describes a corrupt entry found by Scrub.
+type Corruption struct {
+	Key fsdb.Key
+
+	// Err is the error verifying the entry,
+	// which is always an fsdb.CorruptEntryError or wraps one.
+	Err error
+
+	// Repaired is true if the entry is repaired by ScrubOptions.Repair.
+	Repaired bool
+
+	// RepairErr is the error returned by ScrubOptions.Repair, if any.
+	RepairErr error
+}
+
+// ScrubStats are the stats of a Scrub run.
+type ScrubStats struct {
+	// Entries is the number of entries verified.
+	Entries int64
+
+	// Bytes is the number of bytes read from the data files.
+	Bytes int64
+
+	// Corrupt is the number of corrupt entries found.
+	Corrupt int64
+
+	// Repaired is the number of corrupt entries repaired.
+	Repaired int64
+}
+
+// Scrubber defines the extra interface implemented by local FSDB to verify the
+// integrity of all the entries.
+type Scrubber interface {
+	// Scrub scans all the entries and reads their data fully,
+	// verifying the checksums (regardless of Options.GetVerifyChecksum),
+	// the encryption, and the compression along the way.
+	//
+	// Corrupt, truncated, or undecodable entries are reported via
+	// opts.CorruptFunc.
+	// Entries that can't be decrypted because of missing keys are also
+	// reported as corrupt.
+	//
+	// It keeps going when it fails to read an entry for other reasons,
+	// e.g. I/O errors, and returns the first such error in the end.
+	Scrub(ctx context.Context, opts ScrubOptions) (ScrubStats, error)
+}
+
+// Scrub verifies all the entries of db.
+//
+// If db does not implement Scrubber, it returns fsdb.ErrNotSupported.
+func Scrub(
+	ctx context.Context,
+	db fsdb.FSDB,
+	opts ScrubOptions,
+) (ScrubStats, error) {
+	if s, ok := db.(Scrubber); ok {
+		return s.Scrub(ctx, opts)
+	}
+	return ScrubStats{}, fsdb.ErrNotSupported
+}
+
+func (db *impl) Scrub(
+	ctx context.Context,
+	opts ScrubOptions,
+) (ScrubStats, error) {
+	var stats ScrubStats
+	var scrubErr error
+	limiter := newRateLimiter(opts.BytesPerSecond)
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			n, err := db.scrubKey(ctx, key, limiter)
+			stats.Bytes += n
+			if fsdb.IsNoSuchKeyError(err) {
+				// Deleted concurrently.
+				return true
+			}
+			if fsdb.IsCanceledError(err) {
+				return false
+			}
+			stats.Entries++
+			if err == nil {
+				return true
+			}
+			if !fsdb.IsCorruptEntryError(err) {
+				// Keep scrubbing other entries, but report the first error.
+				if scrubErr == nil {
+					scrubErr = err
+				}
+				return true
+			}
+
+			stats.Corrupt++
+			c := Corruption{
+				Key: key,
+				Err: err,
+			}
+			if opts.Repair != nil {
+				c.Repaired, c.RepairErr = opts.Repair(ctx, key)
+				if c.Repaired {
+					stats.Repaired++
+				}
+			}
+			if opts.CorruptFunc != nil {
+				return opts.CorruptFunc(c)
+			}
+			return true
+		},
+		fsdb.IgnoreAll,
+	); err != nil {
+		return stats, err
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return stats, ctx.Err()
+	}
+	return stats, scrubErr
+}
+
+// scrubKey reads the data of key fully and verifies it.
+//
+// It returns the number of bytes read from the data file,
+// and a CorruptEntryError if the entry is corrupt.
+func (db *impl) scrubKey(
+	ctx context.Context,
+	key fsdb.Key,
+	limiter *rateLimiter,
+) (int64, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	dir, entry, err := db.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	var name string
+	var file *os.File
+	err = retryMissingData(key, dir, func() error {
+		for _, name = range db.dataFilenames() {
+			var err error
+			file, err = os.Open(dir + name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		return errNoDataFile
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	counter := &limitedReader{
+		ctx:     ctx,
+		reader:  file,
+		limiter: limiter,
+	}
+	reader, err := db.decodeData(key, name, counter)
+	if err != nil {
+		return counter.n, corruptData(key, err, counter.err)
+	}
+	defer reader.Close()
+	if v := newChecksumVerifier(key, dir, name, file, entry); v != nil {
+		reader = &checksumReader{
+			ReadCloser: reader,
+			verifier:   v,
+		}
+	}
+	_, err = io.Copy(ioutil.Discard, reader)
+	return counter.n, corruptData(key, err, counter.err)
+}
+
+// corruptData converts the errors from decoding data files into
+// CorruptEntryError.
+//
+// fileErr is the error from reading the data file itself, if any.
+// Other errors are from the decoders, e.g. the codec,
+// which means the data is undecodable.
+func corruptData(key fsdb.Key, err, fileErr error) error {
+	switch {
+	case err == nil, fsdb.IsCorruptEntryError(err), fsdb.IsCanceledError(err):
+		return err
+	case errors.Is(err, ErrNoKeyProvider), IsUnknownKeyIDError(err):
+		return &fsdb.CorruptEntryError{
+			Key:    key,
+			Reason: "undecryptable data",
+			Err:    err,
+		}
+	case fileErr != nil:
+		return err
+	}
+	return &fsdb.CorruptEntryError{
+		Key:    key,
+		Reason: "undecodable data",
+		Err:    err,
+	}
+}
+
+// rateLimiter limits the rate of bytes read.
+//
+// All its functions are safe to be called on nil limiter,
+// which doesn't limit anything.
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+// newRateLimiter returns a rateLimiter with the given bytes per second,
+// or nil if rate <= 0.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:  rate,
+		start: time.Now(),
+	}
+}
+
+// wait records n bytes read, and waits until the rate is under limit.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.bytes += int64(n)
+	expected := time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second))
+	delay := expected - time.Since(l.start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limitedReader counts the bytes read and waits on the rateLimiter.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+	n       int64
+
+	// err is the first error other than io.EOF from reader.
+	err error
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	if werr := r.limiter.wait(r.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+package local_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+	"github.com/fishy/fsdb/memory"
+)
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	opts := local.NewDefaultOptions(root).
+		SetKeyProvider(testKeys("key1")).
+		SetVerifyChecksum(false)
+	db := local.Open(opts)
+
+	mangle := func(key fsdb.Key, name string, f func(data []byte) []byte) {
+		t.Helper()
+		path := opts.GetDirForKey(key) + name
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		if err := ioutil.WriteFile(path, f(data), local.FileModeForFiles); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	flip := func(data []byte) []byte {
+		data[len(data)/2] ^= 1
+		return data
+	}
+
+	testWrite(t, db, fsdb.Key("good"), lorem)
+	testWrite(t, db, fsdb.Key("flipped"), lorem)
+	mangle(fsdb.Key("flipped"), local.DataFilename, flip)
+	testWrite(t, db, fsdb.Key("missing"), lorem)
+	if err := os.Remove(
+		opts.GetDirForKey(fsdb.Key("missing")) + local.DataFilename,
+	); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+
+	opts.SetUseGzip(true)
+	testWrite(t, db, fsdb.Key("good-gzip"), lorem)
+	testWrite(t, db, fsdb.Key("truncated-gzip"), lorem)
+	mangle(
+		fsdb.Key("truncated-gzip"),
+		local.GzipDataFilename,
+		func(data []byte) []byte {
+			return data[:len(data)-10]
+		},
+	)
+
+	opts.SetUseGzip(false).SetUseEncryption(true)
+	testWrite(t, db, fsdb.Key("good-encrypted"), lorem)
+	testWrite(t, db, fsdb.Key("flipped-encrypted"), lorem)
+	mangle(fsdb.Key("flipped-encrypted"), local.EncryptedDataFilename, flip)
+
+	corrupt := []string{
+		"flipped",
+		"flipped-encrypted",
+		"missing",
+		"truncated-gzip",
+	}
+	var found []string
+	stats, err := local.Scrub(ctx, db, local.ScrubOptions{
+		CorruptFunc: func(c local.Corruption) bool {
+			if !fsdb.IsCorruptEntryError(c.Err) {
+				t.Errorf("%v: expected CorruptEntryError, got %v", c.Key, c.Err)
+			}
+			if c.Repaired || c.RepairErr != nil {
+				t.Errorf("%v: unexpected repair: %+v", c.Key, c)
+			}
+			found = append(found, string(c.Key))
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	sort.Strings(found)
+	if strings.Join(found, ",") != strings.Join(corrupt, ",") {
+		t.Errorf("Scrub expected to find %v, got %v", corrupt, found)
+	}
+	if stats.Entries != 7 || stats.Corrupt != 4 || stats.Repaired != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.Bytes == 0 {
+		t.Errorf("Scrub should report bytes read, got %+v", stats)
+	}
+
+	// Undecryptable entries.
+	opts.SetKeyProvider(nil)
+	found = nil
+	if _, err := local.Scrub(ctx, db, local.ScrubOptions{
+		CorruptFunc: func(c local.Corruption) bool {
+			if c.Key.Equals(fsdb.Key("good-encrypted")) &&
+				!errors.Is(c.Err, local.ErrNoKeyProvider) {
+				t.Errorf("%v: expected ErrNoKeyProvider, got %v", c.Key, c.Err)
+			}
+			found = append(found, string(c.Key))
+			return true
+		},
+	}); err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if len(found) != 5 {
+		t.Errorf("Scrub without keys expected 5 corrupt entries, got %v", found)
+	}
+	opts.SetKeyProvider(testKeys("key1"))
+
+	// Repair.
+	stats, err = local.Scrub(ctx, db, local.ScrubOptions{
+		Repair: func(ctx context.Context, key fsdb.Key) (bool, error) {
+			if key.Equals(fsdb.Key("missing")) {
+				return false, nil
+			}
+			return true, db.Write(ctx, key, strings.NewReader(lorem))
+		},
+	})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if stats.Corrupt != 4 || stats.Repaired != 3 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	stats, err = local.Scrub(ctx, db, local.ScrubOptions{})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if stats.Corrupt != 1 {
+		t.Errorf("Unexpected stats after repair: %+v", stats)
+	}
+
+	// Abort.
+	var count int
+	if _, err := local.Scrub(ctx, db, local.ScrubOptions{
+		CorruptFunc: func(c local.Corruption) bool {
+			count++
+			return false
+		},
+	}); err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("CorruptFunc expected to be called once, got %d", count)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := local.Scrub(canceled, db, local.ScrubOptions{}); !fsdb.IsCanceledError(err) {
+		t.Errorf("Scrub with canceled context expected canceled error, got %v", err)
+	}
+
+	if _, err := local.Scrub(ctx, memory.Open(nil), local.ScrubOptions{}); err != fsdb.ErrNotSupported {
+		t.Errorf("Scrub on memory db expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestScrubRate(t *testing.T) {
+	ctx := context.Background()
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	db := local.Open(local.NewDefaultOptions(root))
+
+	const size = 16 * 1024
+	testWrite(t, db, fsdb.Key("foo"), strings.Repeat("a", size))
+	started := time.Now()
+	stats, err := local.Scrub(ctx, db, local.ScrubOptions{
+		BytesPerSecond: size * 5,
+	})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if stats.Bytes != size {
+		t.Errorf("Scrub expected to read %d bytes, got %+v", size, stats)
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("Scrub at 1/5 of the size per second took only %v", elapsed)
+	}
+}
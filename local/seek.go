@@ -0,0 +1,165 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies Seekable interface.
+var _ Seekable = (*impl)(nil)
+
+// Make sure *seekableFile satisfies SeekableReadCloser interface.
+var _ SeekableReadCloser = (*seekableFile)(nil)
+
+// ErrNotSeekable is the error returned by ReadSeekable when the entry is
+// compressed or encrypted, or the FSDB does not support seeking.
+var ErrNotSeekable = errors.New("local: entry is not seekable")
+
+// SeekableReadCloser is the reader returned for uncompressed and unencrypted
+// entries.
+type SeekableReadCloser interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the size of the entry when it was opened.
+	Size() int64
+}
+
+// Seekable defines the extra interface implemented by local FSDB.
+type Seekable interface {
+	// ReadSeekable opens an uncompressed and unencrypted entry and returns a
+	// SeekableReadCloser.
+	//
+	// If the key does not exist, it returns a NoSuchKeyError.
+	// If the entry is compressed or encrypted, it returns ErrNotSeekable.
+	//
+	// It's the caller's responsibility to close the SeekableReadCloser returned.
+	ReadSeekable(ctx context.Context, key fsdb.Key) (SeekableReadCloser, error)
+}
+
+// ReadSeekable opens an uncompressed and unencrypted entry from db and returns
+// a SeekableReadCloser.
+//
+// If db does not implement Seekable, it returns ErrNotSeekable.
+func ReadSeekable(
+	ctx context.Context,
+	db fsdb.FSDB,
+	key fsdb.Key,
+) (SeekableReadCloser, error) {
+	if s, ok := db.(Seekable); ok {
+		return s.ReadSeekable(ctx, key)
+	}
+	return nil, ErrNotSeekable
+}
+
+func (db *impl) ReadSeekable(
+	ctx context.Context,
+	key fsdb.Key,
+) (SeekableReadCloser, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	dir, entry, err := db.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	var result *seekableFile
+	err = retryMissingData(key, dir, func() error {
+		for _, name := range db.dataFilenames() {
+			if name != DataFilename {
+				if _, err := os.Lstat(dir + name); err == nil {
+					return ErrNotSeekable
+				}
+				continue
+			}
+			file, err := openPlain(dir)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			file.generation = entry.Generation
+			result = db.withChecksum(key, dir, name, file, entry).(*seekableFile)
+			return nil
+		}
+		return errNoDataFile
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// seekableFile is an opened uncompressed data file.
+type seekableFile struct {
+	*os.File
+
+	size       int64
+	generation int64
+
+	// verifier verifies the checksum when the file is read sequentially from
+	// the beginning to EOF.
+	verifier *checksumVerifier
+}
+
+func (f *seekableFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.verifier.update(p[:n])
+	if err == io.EOF {
+		if verr := f.verifier.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (f *seekableFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.verifier.reset(pos)
+	}
+	return pos, err
+}
+
+// WriteTo makes sure io.Copy goes through Read when the checksum needs to be
+// verified.
+func (f *seekableFile) WriteTo(w io.Writer) (int64, error) {
+	if f.verifier == nil {
+		return io.Copy(w, f.File)
+	}
+	return io.Copy(w, struct{ io.Reader }{f})
+}
+
+func (f *seekableFile) Size() int64 {
+	return f.size
+}
+
+func (f *seekableFile) Generation() int64 {
+	return f.generation
+}
+
+// openPlain opens the uncompressed data file.
+func openPlain(dir string) (*seekableFile, error) {
+	file, err := os.Open(dir + DataFilename)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &seekableFile{
+		File: file,
+		size: stat.Size(),
+	}, nil
+}
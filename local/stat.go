@@ -0,0 +1,204 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Stater interface.
+var _ fsdb.Stater = (*impl)(nil)
+
+func (db *impl) Stat(ctx context.Context, key fsdb.Key) (*fsdb.EntryInfo, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	dir, entry, err := db.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	var result *fsdb.EntryInfo
+	err = retryMissingData(key, dir, func() error {
+		for _, name := range db.dataFilenames() {
+			info, err := db.statData(key, dir, name, entry.Size)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			info.Generation = entry.Generation
+			info.Expires = entry.expiresTime()
+			info.Checksum = entry.Checksum
+			result = info
+			return nil
+		}
+		return errNoDataFile
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// dataFilename returns the data filename with the given codec (nil for no
+// compression) and encryption.
+func dataFilename(codec Codec, encrypt bool) string {
+	name := DataFilename
+	if codec != nil {
+		name += codec.Suffix()
+	}
+	if encrypt {
+		name += encryptedSuffix
+	}
+	return name
+}
+
+// parseDataFilename returns the codec (nil for no compression) and encryption
+// of a data filename.
+//
+// ok is false if the codec is neither the one from the options nor a
+// registered one.
+func (db *impl) parseDataFilename(name string) (
+	codec Codec,
+	encrypted bool,
+	ok bool,
+) {
+	suffix := strings.TrimPrefix(name, DataFilename)
+	if strings.HasSuffix(suffix, encryptedSuffix) {
+		encrypted = true
+		suffix = strings.TrimSuffix(suffix, encryptedSuffix)
+	}
+	if suffix == "" {
+		return nil, encrypted, true
+	}
+	if codec = db.opts.GetCodec(); codec != nil && codec.Suffix() == suffix {
+		return codec, encrypted, true
+	}
+	codec = codecForSuffix(suffix)
+	return codec, encrypted, codec != nil
+}
+
+// dataFilenames returns the possible data filenames under an entry directory,
+// in the order they should be tried.
+//
+// They are the ones with the codec from the options,
+// which could be unregistered,
+// followed by the ones with the registered codecs.
+func (db *impl) dataFilenames() []string {
+	codec := db.opts.GetCodec()
+	encrypt := db.opts.GetUseEncryption()
+	preferred := []string{
+		dataFilename(codec, encrypt),
+		dataFilename(codec, !encrypt),
+	}
+	all := allDataFilenames()
+	names := make([]string, 0, len(all)+len(preferred))
+	names = append(names, preferred...)
+	for _, name := range all {
+		if name != preferred[0] && name != preferred[1] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// statData returns the EntryInfo of a data file.
+//
+// size is the size of the data recorded in the info file,
+// or 0 if it's unknown and needs to be read from the data file.
+func (db *impl) statData(
+	key fsdb.Key,
+	dir, name string,
+	size int64,
+) (*fsdb.EntryInfo, error) {
+	path := dir + name
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	codec, encrypted, ok := db.parseDataFilename(name)
+	if !ok {
+		return nil, unknownCodec(key, name)
+	}
+	info := &fsdb.EntryInfo{
+		Key:        key,
+		Size:       stat.Size(),
+		StoredSize: stat.Size(),
+		Codec:      codecName(codec),
+		Encrypted:  encrypted,
+		ModTime:    stat.ModTime(),
+		Local:      true,
+	}
+	if size > 0 {
+		info.Size = size
+	} else if sized, ok := codec.(SizedCodec); ok {
+		info.Size, err = db.trailerSize(key, path, stat.Size(), sized, encrypted)
+	} else if codec != nil {
+		info.Size, err = db.decodedSize(key, dir, name)
+	} else if encrypted {
+		info.Size, err = encryptedSize(key, path, stat.Size())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// trailerSize reads the decompressed size from the trailer of a data file
+// compressed by a SizedCodec.
+func (db *impl) trailerSize(
+	key fsdb.Key,
+	path string,
+	fileSize int64,
+	codec SizedCodec,
+	encrypted bool,
+) (int64, error) {
+	n := codec.TrailerSize()
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if encrypted {
+		trailer, err := db.readEncryptedTail(key, file, fileSize, n)
+		if err != nil {
+			return 0, err
+		}
+		return codec.DecompressedSize(trailer), nil
+	}
+	if fileSize < int64(n) {
+		return 0, &fsdb.CorruptEntryError{
+			Key: key,
+			Reason: fmt.Sprintf(
+				"%s data file is too short: %d bytes",
+				codec.Name(),
+				fileSize,
+			),
+		}
+	}
+	trailer := make([]byte, n)
+	if _, err := file.ReadAt(trailer, fileSize-int64(n)); err != nil {
+		return 0, err
+	}
+	return codec.DecompressedSize(trailer), nil
+}
+
+// decodedSize decodes a data file fully to get its decoded size.
+func (db *impl) decodedSize(key fsdb.Key, dir, name string) (int64, error) {
+	reader, err := db.readData(key, dir, name, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.Copy(ioutil.Discard, reader)
+}
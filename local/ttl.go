@@ -0,0 +1,97 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Expirer and fsdb.OptionsWriter interfaces.
+var (
+	_ fsdb.Expirer       = (*impl)(nil)
+	_ fsdb.OptionsWriter = (*impl)(nil)
+)
+
+func (db *impl) WriteWithTTL(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	ttl time.Duration,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		ttl: ttl,
+	})
+}
+
+func (db *impl) WriteWithOptions(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	opts fsdb.WriteOptions,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		metadata:   opts.Metadata,
+		ttl:        opts.TTL,
+		generation: opts.Generation,
+	})
+}
+
+// Reap removes all the expired entries.
+//
+// It's built on top of ScanKeys and ignores all I/O errors from the scan.
+// Errors on individual entries don't stop the reap,
+// the first one is returned after the scan finishes.
+func (db *impl) Reap(ctx context.Context, keyFunc fsdb.KeyFunc) error {
+	var reapErr error
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			reaped, err := db.reapKey(key)
+			if err != nil {
+				// Keep reaping other entries, but report the first error.
+				if reapErr == nil {
+					reapErr = err
+				}
+				return true
+			}
+			if reaped && keyFunc != nil {
+				return keyFunc(key)
+			}
+			return true
+		},
+		fsdb.IgnoreAll,
+	); err != nil {
+		return err
+	}
+	return reapErr
+}
+
+// reapKey removes the entry of key if it's expired.
+func (db *impl) reapKey(key fsdb.Key) (bool, error) {
+	dir, err := db.dirForKey(key)
+	if err != nil {
+		return false, err
+	}
+	lock := lockForDir(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	info, err := loadInfo(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !info.expired() {
+		return false, nil
+	}
+	if err := removeEntry(dir); err != nil {
+		return false, err
+	}
+	db.emit(fsdb.EventDelete, key)
+	return true, nil
+}
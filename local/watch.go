@@ -0,0 +1,109 @@
+package local
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Watcher interface.
+var _ fsdb.Watcher = (*impl)(nil)
+
+// watchers are all the watchers in this process, grouped by root data dir,
+// so that different FSDB instances opened on the same root see the same
+// events.
+var watchers = struct {
+	lock  sync.RWMutex
+	roots map[string]map[*watcher]bool
+}{
+	roots: make(map[string]map[*watcher]bool),
+}
+
+// Watch returns a channel of events emitted by operations in this process.
+//
+// Changes made by other processes are not reported.
+// Use WatchInotify to catch them on Linux.
+func (db *impl) Watch(ctx context.Context) <-chan fsdb.Event {
+	root := db.opts.GetRootDataDir()
+	w := &watcher{
+		notify: make(chan struct{}, 1),
+	}
+	ch := make(chan fsdb.Event)
+
+	watchers.lock.Lock()
+	if watchers.roots[root] == nil {
+		watchers.roots[root] = make(map[*watcher]bool)
+	}
+	watchers.roots[root][w] = true
+	watchers.lock.Unlock()
+
+	go func() {
+		defer close(ch)
+		defer func() {
+			watchers.lock.Lock()
+			defer watchers.lock.Unlock()
+			delete(watchers.roots[root], w)
+			if len(watchers.roots[root]) == 0 {
+				delete(watchers.roots, root)
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.notify:
+			}
+			for _, event := range w.drain() {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- event:
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// emit sends an event to all the watchers of the same root.
+func (db *impl) emit(t fsdb.EventType, key fsdb.Key) {
+	event := fsdb.Event{
+		Type: t,
+		Key:  key,
+	}
+	watchers.lock.RLock()
+	defer watchers.lock.RUnlock()
+	for w := range watchers.roots[db.opts.GetRootDataDir()] {
+		w.push(event)
+	}
+}
+
+// watcher queues the events in memory,
+// so that emitting events never blocks.
+type watcher struct {
+	lock   sync.Mutex
+	queue  []fsdb.Event
+	notify chan struct{}
+}
+
+func (w *watcher) push(event fsdb.Event) {
+	w.lock.Lock()
+	w.queue = append(w.queue, event)
+	w.lock.Unlock()
+
+	select {
+	default:
+		// There's already a pending notification.
+	case w.notify <- struct{}{}:
+	}
+}
+
+func (w *watcher) drain() []fsdb.Event {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	events := w.queue
+	w.queue = nil
+	return events
+}
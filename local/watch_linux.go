@@ -0,0 +1,241 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/fishy/fsdb"
+)
+
+// inotifyMask is the mask used on all the watched directories.
+const inotifyMask = syscall.IN_CREATE |
+	syscall.IN_MOVED_TO |
+	syscall.IN_DELETE |
+	syscall.IN_ONLYDIR
+
+// WatchInotify watches the data directory of a local FSDB with inotify,
+// and reports writes and deletes made by all the processes sharing the same
+// root.
+//
+// It adds an inotify watch to every directory under the data directory and
+// reads every key file on start,
+// so it could take a long time on a large FSDB,
+// and might need a higher fs.inotify.max_user_watches sysctl.
+//
+// Events could be lost if the kernel event queue overflows,
+// or if an entry is written and deleted before its newly created directory is
+// added to the watch list.
+//
+// The channel is closed after ctx is canceled,
+// or when an unrecoverable error happened.
+func WatchInotify(ctx context.Context, opts Options) (<-chan fsdb.Event, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	root := opts.GetRootDataDir()
+	if !strings.HasSuffix(root, PathSeparator) {
+		root += PathSeparator
+	}
+	if err := os.MkdirAll(root, FileModeForDirs); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		ctx: ctx,
+		// With IN_NONBLOCK, reads go through the runtime poller,
+		// so closing the file unblocks them.
+		file:  os.NewFile(uintptr(fd), "inotify"),
+		fd:    fd,
+		dirs:  make(map[int32]string),
+		keys:  make(map[string]watchedKey),
+		ch:    make(chan fsdb.Event),
+		start: true,
+	}
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	w.start = false
+	go w.run()
+	return w.ch, nil
+}
+
+type inotifyWatcher struct {
+	ctx  context.Context
+	file *os.File
+	fd   int
+	ch   chan fsdb.Event
+
+	// dirs maps watch descriptors to directories.
+	dirs map[int32]string
+	// keys maps entry directories to their keys,
+	// as the key file is gone when we get the delete event.
+	keys map[string]watchedKey
+
+	// start is true during the initial scan, when no events are sent.
+	start bool
+}
+
+// watchedKey is a key with the FileInfo of its key file.
+type watchedKey struct {
+	key  fsdb.Key
+	info os.FileInfo
+}
+
+// addTree adds dir and all its subdirectories into the watch list.
+//
+// Entries already under the new directories are reported as writes,
+// unless it's the initial scan.
+func (w *inotifyWatcher) addTree(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.dirs[int32(wd)] = dir
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			if err := w.addTree(dir + file.Name() + PathSeparator); err != nil {
+				if os.IsNotExist(err) {
+					// Removed before we get to it.
+					continue
+				}
+				return err
+			}
+			continue
+		}
+		if file.Name() == KeyFilename {
+			if !w.written(dir) {
+				return errCanceled
+			}
+		}
+	}
+	return nil
+}
+
+// written records the key of the entry directory and sends a write event.
+//
+// A key file already reported is ignored,
+// as it could be seen by both addTree and the event moving it in.
+//
+// It returns false if ctx is canceled.
+func (w *inotifyWatcher) written(dir string) bool {
+	path := dir + KeyFilename
+	info, err := os.Lstat(path)
+	if err != nil {
+		// Most likely removed right after it's written.
+		return true
+	}
+	if old, ok := w.keys[dir]; ok && os.SameFile(old.info, info) {
+		return true
+	}
+	key, err := readKey(path)
+	if err != nil {
+		return true
+	}
+	w.keys[dir] = watchedKey{
+		key:  key,
+		info: info,
+	}
+	if w.start {
+		return true
+	}
+	return w.send(fsdb.EventWrite, key)
+}
+
+func (w *inotifyWatcher) send(t fsdb.EventType, key fsdb.Key) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case w.ch <- fsdb.Event{Type: t, Key: key}:
+		return true
+	}
+}
+
+func (w *inotifyWatcher) run() {
+	defer close(w.ch)
+	defer w.file.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-w.ctx.Done():
+			w.file.Close()
+		}
+	}()
+
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+			if !w.handle(event.Wd, event.Mask, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle handles a single inotify event.
+//
+// It returns false if the watcher should stop.
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) bool {
+	if mask&syscall.IN_IGNORED != 0 {
+		// The directory is removed.
+		delete(w.dirs, wd)
+		return true
+	}
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return true
+	}
+
+	if mask&syscall.IN_ISDIR != 0 {
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			err := w.addTree(dir + name + PathSeparator)
+			if err == errCanceled {
+				return false
+			}
+		}
+		return true
+	}
+	if name != KeyFilename {
+		return true
+	}
+	switch {
+	case mask&syscall.IN_MOVED_TO != 0:
+		// The key file is always the last one moved into the entry directory.
+		return w.written(dir)
+	case mask&syscall.IN_DELETE != 0:
+		watched, ok := w.keys[dir]
+		if !ok {
+			return true
+		}
+		delete(w.keys, dir)
+		return w.send(fsdb.EventDelete, watched.key)
+	}
+	return true
+}
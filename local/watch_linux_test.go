@@ -0,0 +1,49 @@
+package local_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/local"
+)
+
+func TestWatchInotify(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsdb_")
+	if err != nil {
+		t.Fatalf("failed to get tmp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := local.NewDefaultOptions(root)
+	db := local.Open(opts)
+	existing := fsdb.Key("existing")
+	testWrite(t, db, existing, lorem)
+
+	events, err := local.WatchInotify(ctx, opts)
+	if err != nil {
+		t.Fatalf("WatchInotify failed: %v", err)
+	}
+
+	key := fsdb.Key("foo")
+	testWrite(t, db, key, lorem)
+	checkEvents(t, events, []fsdb.Event{{Type: fsdb.EventWrite, Key: key}})
+	testWrite(t, db, key, "")
+	checkEvents(t, events, []fsdb.Event{{Type: fsdb.EventWrite, Key: key}})
+	testDelete(t, db, key)
+	checkEvents(t, events, []fsdb.Event{{Type: fsdb.EventDelete, Key: key}})
+	// Keys existed before the watch started.
+	testDelete(t, db, existing)
+	checkEvents(t, events, []fsdb.Event{{Type: fsdb.EventDelete, Key: existing}})
+
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected event %v", event)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package local
+
+import (
+	"context"
+
+	"github.com/fishy/fsdb"
+)
+
+// WatchInotify is only supported on Linux.
+//
+// On other platforms it always returns fsdb.ErrNotSupported.
+func WatchInotify(ctx context.Context, opts Options) (<-chan fsdb.Event, error) {
+	return nil, fsdb.ErrNotSupported
+}
@@ -0,0 +1,124 @@
+package local
+
+import (
+	"context"
+	"os"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.StreamWriter interface.
+var _ fsdb.StreamWriter = (*impl)(nil)
+
+// Make sure *entryWriter satisfies fsdb.EntryWriter interface.
+var _ fsdb.EntryWriter = (*entryWriter)(nil)
+
+func (db *impl) OpenWriter(
+	ctx context.Context,
+	key fsdb.Key,
+) (fsdb.EntryWriter, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	tmpdir, err := db.prepare(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := db.createData(key, tmpdir)
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, err
+	}
+	return &entryWriter{
+		ctx:    ctx,
+		db:     db,
+		key:    key,
+		tmpdir: tmpdir,
+		writer: writer,
+	}, nil
+}
+
+// entryWriter writes the data into the temporary directory,
+// and only moves them into the entry directory on Commit.
+type entryWriter struct {
+	ctx    context.Context
+	db     *impl
+	key    fsdb.Key
+	tmpdir string
+	writer *dataWriter
+	done   bool
+
+	// err is the first error returned by Write,
+	// after which the data written is incomplete.
+	err error
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, fsdb.ErrWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	select {
+	default:
+	case <-w.ctx.Done():
+		w.err = w.ctx.Err()
+		return 0, w.err
+	}
+
+	n, err := w.writer.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Commit commits the data written.
+//
+// If any Write failed, it aborts the write and returns the first Write error
+// instead, as the data written is incomplete.
+func (w *entryWriter) Commit() error {
+	if w.done {
+		return fsdb.ErrWriterClosed
+	}
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+	w.done = true
+	defer os.RemoveAll(w.tmpdir)
+
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+
+	select {
+	default:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+
+	return w.db.commit(
+		w.ctx,
+		w.key,
+		w.tmpdir,
+		w.writer.filename(),
+		w.writer.checksum(),
+		w.writer.size(),
+		nil,
+	)
+}
+
+func (w *entryWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.writer.Close()
+	return os.RemoveAll(w.tmpdir)
+}
@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.ConditionalWriter interface.
+var _ fsdb.ConditionalWriter = (*impl)(nil)
+
+func (db *impl) WriteIf(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	generation int64,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		cond: func(current int64) bool {
+			return current != 0 && current == generation
+		},
+	})
+}
+
+func (db *impl) WriteIfNotExists(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		cond: func(current int64) bool {
+			return current == 0
+		},
+	})
+}
@@ -0,0 +1,31 @@
+// Package memory provides an in-memory implementation of FSDB.
+//
+// It implements fsdb.Local interface,
+// along with fsdb.Stater, fsdb.RangeReader, fsdb.ConditionalWriter,
+// fsdb.MetadataFSDB, fsdb.Expirer, fsdb.OptionsWriter and fsdb.Watcher.
+// It's safe for concurrent use.
+//
+// It's mainly designed for tests depending on FSDB,
+// e.g. as the local FSDB of hybrid FSDB,
+// or as the backing FSDB of bucket.Mock via bucket.MockBucketWithFSDB.
+//
+// Limits and Error Injection
+//
+// A size limit on the total size of the data can be set with
+// OptionsBuilder.SetMaxSize.
+// Writes exceeding it fail with a SizeLimitError.
+//
+// A Hook set with OptionsBuilder.SetHook is called before every operation,
+// and the error it returns fails the operation,
+// which makes it possible to test error handling:
+//
+//	opts := memory.NewDefaultOptions().SetHook(
+//		func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+//			if op == memory.OpWrite {
+//				return errors.New("disk full")
+//			}
+//			return nil
+//		},
+//	)
+//	db := memory.Open(opts)
+package memory
@@ -0,0 +1,337 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Local interface.
+var _ fsdb.Local = (*impl)(nil)
+
+// Make sure *entryReader satisfies fsdb.Versioned interface.
+var _ fsdb.Versioned = (*entryReader)(nil)
+
+// Make sure *SizeLimitError satisfies error interface.
+var _ error = (*SizeLimitError)(nil)
+
+// SizeLimitError is an error returned by write operations when the total size
+// of the data would exceed the limit set by OptionsBuilder.SetMaxSize.
+type SizeLimitError struct {
+	Key   fsdb.Key
+	Limit int64
+}
+
+func (err *SizeLimitError) Error() string {
+	return fmt.Sprintf(
+		"writing key %q would exceed the size limit of %d bytes",
+		err.Key,
+		err.Limit,
+	)
+}
+
+// IsSizeLimitError checks whether a given error is SizeLimitError,
+// or wraps one.
+func IsSizeLimitError(err error) bool {
+	var target *SizeLimitError
+	return errors.As(err, &target)
+}
+
+// entry is a single entry stored in memory.
+//
+// Entries are never modified after they are stored,
+// overwriting a key replaces the whole entry.
+type entry struct {
+	key        fsdb.Key
+	data       []byte
+	generation int64
+	metadata   fsdb.Metadata
+	modTime    time.Time
+
+	// expires is zero if the entry never expires.
+	expires time.Time
+}
+
+// expired returns true if the entry is expired.
+func (e *entry) expired() bool {
+	return !e.expires.IsZero() && !time.Now().Before(e.expires)
+}
+
+type impl struct {
+	opts Options
+
+	lock    sync.RWMutex
+	entries map[string]*entry
+	size    int64
+
+	watchers watchers
+}
+
+// Open opens an empty FSDB with the given options.
+//
+// All the data are lost when the FSDB is garbage collected.
+func Open(opts Options) fsdb.Local {
+	return &impl{
+		opts:    opts,
+		entries: make(map[string]*entry),
+	}
+}
+
+func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	e, err := db.get(ctx, OpRead, key)
+	if err != nil {
+		return nil, err
+	}
+	return &entryReader{
+		Reader:     bytes.NewReader(e.data),
+		generation: e.generation,
+	}, nil
+}
+
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	return db.write(ctx, key, data, nil)
+}
+
+func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
+	if err := db.before(ctx, OpDelete, key); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	e := db.entries[string(key)]
+	if e != nil {
+		delete(db.entries, string(key))
+		db.size -= int64(len(e.data))
+	}
+	db.lock.Unlock()
+
+	if e == nil || e.expired() {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	db.emit(fsdb.EventDelete, key)
+	return nil
+}
+
+// ScanKeys scans a snapshot of all the keys taken when the scan starts.
+//
+// errFunc is only called with the errors returned by the Hook.
+func (db *impl) ScanKeys(
+	ctx context.Context,
+	keyFunc fsdb.KeyFunc,
+	errFunc fsdb.ErrFunc,
+) error {
+	db.lock.RLock()
+	entries := make([]*entry, 0, len(db.entries))
+	for _, e := range db.entries {
+		entries = append(entries, e)
+	}
+	db.lock.RUnlock()
+
+	for _, e := range entries {
+		select {
+		default:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if e.expired() {
+			continue
+		}
+		if hook := db.opts.GetHook(); hook != nil {
+			if err := hook(ctx, OpScan, e.key); err != nil {
+				if errFunc(e.key.String(), err) {
+					continue
+				}
+				return err
+			}
+		}
+		if !keyFunc(e.key) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// before checks ctx and calls the hook, if any.
+func (db *impl) before(ctx context.Context, op Op, key fsdb.Key) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if hook := db.opts.GetHook(); hook != nil {
+		return hook(ctx, op, key)
+	}
+	return nil
+}
+
+// get returns the entry of key.
+//
+// It returns a NoSuchKeyError if the key does not exist or is expired.
+func (db *impl) get(ctx context.Context, op Op, key fsdb.Key) (*entry, error) {
+	if err := db.before(ctx, op, key); err != nil {
+		return nil, err
+	}
+
+	db.lock.RLock()
+	e := db.entries[string(key)]
+	db.lock.RUnlock()
+
+	if e == nil || e.expired() {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	return e, nil
+}
+
+// writeOptions are the extra options of a write operation.
+type writeOptions struct {
+	// If cond is not nil, it will be called with the current generation of the
+	// entry (0 if it does not exist),
+	// and the write fails with a PreconditionFailedError if it returns false.
+	cond func(current int64) bool
+
+	// metadata is the user metadata to be stored with the entry.
+	metadata fsdb.Metadata
+
+	// ttl is the time-to-live of the entry.
+	// 0 means using the default from Options, negative means never expires.
+	ttl time.Duration
+
+	// generation is the generation of the entry.
+	// 0 means a new generation.
+	generation int64
+}
+
+// write writes an entry.
+//
+// wo could be nil for a plain write.
+func (db *impl) write(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	wo *writeOptions,
+) error {
+	if wo == nil {
+		wo = &writeOptions{}
+	}
+	if err := db.before(ctx, OpWrite, key); err != nil {
+		return err
+	}
+
+	// Read one more byte than the limit to fail early on large data.
+	maxSize := db.opts.GetMaxSize()
+	if maxSize > 0 {
+		data = io.LimitReader(data, maxSize+1)
+	}
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	now := time.Now()
+	e := &entry{
+		key:      append(fsdb.Key(nil), key...),
+		data:     buf,
+		metadata: copyMetadata(wo.metadata),
+		modTime:  now,
+	}
+	ttl := wo.ttl
+	if ttl == 0 {
+		ttl = db.opts.GetTTL()
+	}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+
+	db.lock.Lock()
+	old := db.entries[string(key)]
+	var current int64
+	if old != nil && !old.expired() {
+		current = old.generation
+	}
+	if wo.cond != nil && !wo.cond(current) {
+		db.lock.Unlock()
+		return &fsdb.PreconditionFailedError{
+			Key:        key,
+			Generation: current,
+		}
+	}
+	newSize := db.size + int64(len(buf))
+	if old != nil {
+		newSize -= int64(len(old.data))
+	}
+	if maxSize > 0 && newSize > maxSize {
+		db.lock.Unlock()
+		return &SizeLimitError{
+			Key:   key,
+			Limit: maxSize,
+		}
+	}
+	e.generation = wo.generation
+	if e.generation == 0 {
+		e.generation = nextGeneration(current)
+		if old != nil && e.generation <= old.generation {
+			e.generation = old.generation + 1
+		}
+	}
+	db.entries[string(key)] = e
+	db.size = newSize
+	db.lock.Unlock()
+
+	db.emit(fsdb.EventWrite, key)
+	return nil
+}
+
+// nextGeneration returns the generation to be used after current.
+//
+// It's based on the current time so generations won't be reused after an entry
+// is deleted and written again.
+func nextGeneration(current int64) int64 {
+	next := time.Now().UnixNano()
+	if next <= current {
+		next = current + 1
+	}
+	return next
+}
+
+// copyMetadata returns a copy of metadata,
+// or nil if it's empty.
+func copyMetadata(metadata fsdb.Metadata) fsdb.Metadata {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(fsdb.Metadata, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
+
+// entryReader is the ReadCloser returned by Read.
+type entryReader struct {
+	*bytes.Reader
+
+	generation int64
+}
+
+func (r *entryReader) Close() error {
+	return nil
+}
+
+func (r *entryReader) Generation() int64 {
+	return r.generation
+}
@@ -0,0 +1,259 @@
+package memory_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/bucket"
+	"github.com/fishy/fsdb/buckettest"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/hybrid"
+	"github.com/fishy/fsdb/memory"
+)
+
+func TestConformance(t *testing.T) {
+	fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+		return memory.Open(memory.NewDefaultOptions())
+	})
+}
+
+func TestHybrid(t *testing.T) {
+	fsdbtest.TestFSDB(t, func(t *testing.T) fsdb.FSDB {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return hybrid.Open(
+			ctx,
+			memory.Open(memory.NewDefaultOptions()),
+			bucket.MockBucketWithFSDB(memory.Open(memory.NewDefaultOptions())),
+			hybrid.NewDefaultOptions(),
+		)
+	})
+}
+
+func TestSizeLimit(t *testing.T) {
+	ctx := context.Background()
+	db := memory.Open(memory.NewDefaultOptions().SetMaxSize(10))
+
+	if err := db.Write(ctx, fsdb.Key("foo"), strings.NewReader("123456")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	err := db.Write(ctx, fsdb.Key("bar"), strings.NewReader("123456"))
+	if !memory.IsSizeLimitError(err) {
+		t.Errorf("Write exceeding size limit expected SizeLimitError, got %v", err)
+	}
+	if _, err := db.Read(ctx, fsdb.Key("bar")); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read failed write expected NoSuchKeyError, got %v", err)
+	}
+	if err := db.Write(ctx, fsdb.Key("bar"), strings.NewReader("1234")); err != nil {
+		t.Errorf("Write within size limit failed: %v", err)
+	}
+
+	// Overwriting an entry reuses its space.
+	if err := db.Write(ctx, fsdb.Key("foo"), strings.NewReader("12")); err != nil {
+		t.Errorf("Overwrite within size limit failed: %v", err)
+	}
+	if err := db.Delete(ctx, fsdb.Key("foo")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := db.Write(ctx, fsdb.Key("foo"), strings.NewReader("123456")); err != nil {
+		t.Errorf("Write after delete failed: %v", err)
+	}
+
+	err = db.Write(ctx, fsdb.Key("large"), bytes.NewReader(make([]byte, 1024)))
+	if !memory.IsSizeLimitError(err) {
+		t.Errorf("Write large data expected SizeLimitError, got %v", err)
+	}
+}
+
+func TestHook(t *testing.T) {
+	ctx := context.Background()
+	injected := errors.New("injected")
+	var lock sync.Mutex
+	var ops []memory.Op
+	db := memory.Open(memory.NewDefaultOptions().SetHook(
+		func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+			lock.Lock()
+			ops = append(ops, op)
+			lock.Unlock()
+			if key.Equals(fsdb.Key("bad")) {
+				return injected
+			}
+			return nil
+		},
+	))
+
+	for _, key := range []string{"foo", "bar"} {
+		if err := db.Write(ctx, fsdb.Key(key), strings.NewReader(key)); err != nil {
+			t.Fatalf("Write %q failed: %v", key, err)
+		}
+	}
+	if err := db.Write(ctx, fsdb.Key("bad"), strings.NewReader("bad")); err != injected {
+		t.Errorf("Write expected injected error, got %v", err)
+	}
+	if _, err := db.Read(ctx, fsdb.Key("bad")); err != injected {
+		t.Errorf("Read expected injected error, got %v", err)
+	}
+	if err := db.Delete(ctx, fsdb.Key("bad")); err != injected {
+		t.Errorf("Delete expected injected error, got %v", err)
+	}
+	reader, err := db.Read(ctx, fsdb.Key("foo"))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	reader.Close()
+
+	expected := []memory.Op{
+		memory.OpWrite,
+		memory.OpWrite,
+		memory.OpWrite,
+		memory.OpRead,
+		memory.OpDelete,
+		memory.OpRead,
+	}
+	if len(ops) != len(expected) {
+		t.Fatalf("Expected ops %v, got %v", expected, ops)
+	}
+	for i := range ops {
+		if ops[i] != expected[i] {
+			t.Errorf("Expected ops %v, got %v", expected, ops)
+			break
+		}
+	}
+}
+
+func TestScanKeysHook(t *testing.T) {
+	ctx := context.Background()
+	injected := errors.New("injected")
+	db := memory.Open(memory.NewDefaultOptions().SetHook(
+		func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+			if op == memory.OpScan && key.Equals(fsdb.Key("bad")) {
+				return injected
+			}
+			return nil
+		},
+	))
+	for _, key := range []string{"foo", "bar", "bad"} {
+		if err := db.Write(ctx, fsdb.Key(key), strings.NewReader(key)); err != nil {
+			t.Fatalf("Write %q failed: %v", key, err)
+		}
+	}
+
+	var errPaths []string
+	count := 0
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			count++
+			return true
+		},
+		func(path string, err error) bool {
+			if err != injected {
+				t.Errorf("ErrFunc expected injected error, got %v", err)
+			}
+			errPaths = append(errPaths, path)
+			return true
+		},
+	); err != nil {
+		t.Errorf("ScanKeys with IgnoreAll failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("ScanKeys expected to visit 2 keys, got %d", count)
+	}
+	if len(errPaths) != 1 || errPaths[0] != "bad" {
+		t.Errorf("ErrFunc expected to be called with [bad], got %v", errPaths)
+	}
+
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			return true
+		},
+		fsdb.StopAll,
+	); err != injected {
+		t.Errorf("ScanKeys with StopAll expected injected error, got %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	db := memory.Open(memory.NewDefaultOptions())
+	e := db.(fsdb.Expirer)
+	key := fsdb.Key("foo")
+
+	if err := e.WriteWithTTL(ctx, key, strings.NewReader("foo"), time.Millisecond); err != nil {
+		t.Fatalf("WriteWithTTL failed: %v", err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read expired key expected NoSuchKeyError, got %v", err)
+	}
+
+	var reaped []fsdb.Key
+	if err := e.Reap(ctx, func(key fsdb.Key) bool {
+		reaped = append(reaped, key)
+		return true
+	}); err != nil {
+		t.Fatalf("Reap failed: %v", err)
+	}
+	if len(reaped) != 1 || !reaped[0].Equals(key) {
+		t.Errorf("Reap expected to remove [%v], got %v", key, reaped)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	ctx := context.Background()
+	db := memory.Open(memory.NewDefaultOptions())
+	cw := db.(fsdb.ConditionalWriter)
+	key := fsdb.Key("foo")
+
+	if err := cw.WriteIfNotExists(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("WriteIfNotExists failed: %v", err)
+	}
+	err := cw.WriteIfNotExists(ctx, key, strings.NewReader("bar"))
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIfNotExists expected PreconditionFailedError, got %v", err)
+	}
+	info, err := db.(fsdb.Stater).Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != 3 {
+		t.Errorf("Stat expected size 3, got %d", info.Size)
+	}
+	err = cw.WriteIf(ctx, key, strings.NewReader("bar"), info.Generation+1)
+	if !fsdb.IsPreconditionFailedError(err) {
+		t.Errorf("WriteIf expected PreconditionFailedError, got %v", err)
+	}
+	if err := cw.WriteIf(ctx, key, strings.NewReader("bar"), info.Generation); err != nil {
+		t.Errorf("WriteIf failed: %v", err)
+	}
+	reader, err := db.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	if gen := reader.(fsdb.Versioned).Generation(); gen <= info.Generation {
+		t.Errorf("Generation expected to increase from %d, got %d", info.Generation, gen)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read content failed: %v", err)
+	}
+	if string(data) != "bar" {
+		t.Errorf("Read expected %q, got %q", "bar", data)
+	}
+}
+
+func TestMockBucket(t *testing.T) {
+	buckettest.TestBucket(
+		t,
+		bucket.MockBucketWithFSDB(memory.Open(memory.NewDefaultOptions())),
+	)
+}
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.MetadataFSDB interface.
+var _ fsdb.MetadataFSDB = (*impl)(nil)
+
+func (db *impl) WriteWithMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	metadata fsdb.Metadata,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		metadata: metadata,
+	})
+}
+
+func (db *impl) ReadMetadata(
+	ctx context.Context,
+	key fsdb.Key,
+) (fsdb.Metadata, error) {
+	e, err := db.get(ctx, OpRead, key)
+	if err != nil {
+		return nil, err
+	}
+	return copyMetadata(e.metadata), nil
+}
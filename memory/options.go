@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Default options values.
+const (
+	DefaultMaxSize int64 = 0
+
+	DefaultTTL time.Duration = 0
+)
+
+// Op is the type of operation passed to Hook.
+type Op int
+
+// Op values.
+const (
+	OpRead Op = iota + 1
+	OpWrite
+	OpDelete
+	OpStat
+	OpScan
+)
+
+func (op Op) String() string {
+	switch op {
+	default:
+		return fmt.Sprintf("Op(%d)", int(op))
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpDelete:
+		return "delete"
+	case OpStat:
+		return "stat"
+	case OpScan:
+		return "scan"
+	}
+}
+
+// Hook is called before every operation on a key,
+// and can be used to inject errors in tests.
+//
+// If it returns a non-nil error, the operation fails with that error without
+// touching the data.
+//
+// For OpScan, it's called for every key visited by ScanKeys,
+// and the error is passed to the ErrFunc.
+//
+// It's called without holding any locks,
+// so it's OK for it to block or to call the FSDB.
+type Hook func(ctx context.Context, op Op, key fsdb.Key) error
+
+// Options defines a read only view of options used by memory fsdb.
+type Options interface {
+	// GetMaxSize returns the maximum total size of the data in bytes,
+	// or 0 if it's unlimited.
+	GetMaxSize() int64
+
+	// GetTTL returns the default time-to-live for entries written without an
+	// explicit TTL, or 0 if they never expire.
+	GetTTL() time.Duration
+
+	// GetHook returns the hook called before every operation,
+	// or nil if there's none.
+	GetHook() Hook
+}
+
+// OptionsBuilder defines a read-write view of options used by memory fsdb.
+//
+// All options are safe to change on an existing FSDB.
+type OptionsBuilder interface {
+	Options
+
+	// Build returns the read-only version of options.
+	Build() Options
+
+	// SetMaxSize sets the maximum total size of the data in bytes.
+	//
+	// Writes making the total size exceed it fail with a SizeLimitError.
+	// 0 means unlimited.
+	SetMaxSize(size int64) OptionsBuilder
+
+	// SetTTL sets the default time-to-live for entries written without an
+	// explicit TTL.
+	//
+	// 0 means they never expire.
+	// Changing it only affects entries written afterwards.
+	SetTTL(ttl time.Duration) OptionsBuilder
+
+	// SetHook sets the hook called before every operation.
+	SetHook(hook Hook) OptionsBuilder
+}
+
+type options struct {
+	maxSize int64
+	ttl     time.Duration
+	hook    Hook
+}
+
+// NewDefaultOptions creates an OptionsBuilder with default options.
+func NewDefaultOptions() OptionsBuilder {
+	return &options{
+		maxSize: DefaultMaxSize,
+		ttl:     DefaultTTL,
+	}
+}
+
+func (opts *options) GetMaxSize() int64 {
+	return opts.maxSize
+}
+
+func (opts *options) GetTTL() time.Duration {
+	return opts.ttl
+}
+
+func (opts *options) GetHook() Hook {
+	return opts.hook
+}
+
+func (opts *options) Build() Options {
+	return opts
+}
+
+func (opts *options) SetMaxSize(size int64) OptionsBuilder {
+	opts.maxSize = size
+	return opts
+}
+
+func (opts *options) SetTTL(ttl time.Duration) OptionsBuilder {
+	opts.ttl = ttl
+	return opts
+}
+
+func (opts *options) SetHook(hook Hook) OptionsBuilder {
+	opts.hook = hook
+	return opts
+}
@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Stater and fsdb.RangeReader interfaces.
+var (
+	_ fsdb.Stater      = (*impl)(nil)
+	_ fsdb.RangeReader = (*impl)(nil)
+)
+
+func (db *impl) Stat(ctx context.Context, key fsdb.Key) (*fsdb.EntryInfo, error) {
+	e, err := db.get(ctx, OpStat, key)
+	if err != nil {
+		return nil, err
+	}
+	return &fsdb.EntryInfo{
+		Key:        key,
+		Size:       int64(len(e.data)),
+		StoredSize: int64(len(e.data)),
+		Codec:      fsdb.CodecPlain,
+		Generation: e.generation,
+		Expires:    e.expires,
+		ModTime:    e.modTime,
+		Local:      true,
+	}, nil
+}
+
+func (db *impl) ReadRange(
+	ctx context.Context,
+	key fsdb.Key,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fsdb.ErrNegativeOffset
+	}
+	e, err := db.get(ctx, OpRead, key)
+	if err != nil {
+		return nil, err
+	}
+	data := e.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return &entryReader{
+		Reader:     bytes.NewReader(data),
+		generation: e.generation,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Expirer and fsdb.OptionsWriter interfaces.
+var (
+	_ fsdb.Expirer       = (*impl)(nil)
+	_ fsdb.OptionsWriter = (*impl)(nil)
+)
+
+func (db *impl) WriteWithTTL(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	ttl time.Duration,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		ttl: ttl,
+	})
+}
+
+func (db *impl) WriteWithOptions(
+	ctx context.Context,
+	key fsdb.Key,
+	data io.Reader,
+	opts fsdb.WriteOptions,
+) error {
+	return db.write(ctx, key, data, &writeOptions{
+		metadata:   opts.Metadata,
+		ttl:        opts.TTL,
+		generation: opts.Generation,
+	})
+}
+
+// Reap removes all the expired entries.
+func (db *impl) Reap(ctx context.Context, keyFunc fsdb.KeyFunc) error {
+	db.lock.RLock()
+	var expired []*entry
+	for _, e := range db.entries {
+		if e.expired() {
+			expired = append(expired, e)
+		}
+	}
+	db.lock.RUnlock()
+
+	for _, e := range expired {
+		select {
+		default:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		db.lock.Lock()
+		// Only remove it if it's not overwritten since the snapshot.
+		reaped := db.entries[string(e.key)] == e
+		if reaped {
+			delete(db.entries, string(e.key))
+			db.size -= int64(len(e.data))
+		}
+		db.lock.Unlock()
+
+		if reaped {
+			db.emit(fsdb.EventDelete, e.key)
+			if keyFunc != nil && !keyFunc(e.key) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
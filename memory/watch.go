@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies fsdb.Watcher interface.
+var _ fsdb.Watcher = (*impl)(nil)
+
+// watchers are all the watchers of an FSDB.
+type watchers struct {
+	lock sync.RWMutex
+	all  map[*watcher]bool
+}
+
+func (db *impl) Watch(ctx context.Context) <-chan fsdb.Event {
+	w := &watcher{
+		notify: make(chan struct{}, 1),
+	}
+	ch := make(chan fsdb.Event)
+
+	db.watchers.lock.Lock()
+	if db.watchers.all == nil {
+		db.watchers.all = make(map[*watcher]bool)
+	}
+	db.watchers.all[w] = true
+	db.watchers.lock.Unlock()
+
+	go func() {
+		defer close(ch)
+		defer func() {
+			db.watchers.lock.Lock()
+			defer db.watchers.lock.Unlock()
+			delete(db.watchers.all, w)
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.notify:
+			}
+			for _, event := range w.drain() {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- event:
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// emit sends an event to all the watchers.
+func (db *impl) emit(t fsdb.EventType, key fsdb.Key) {
+	event := fsdb.Event{
+		Type: t,
+		Key:  key,
+	}
+	db.watchers.lock.RLock()
+	defer db.watchers.lock.RUnlock()
+	for w := range db.watchers.all {
+		w.push(event)
+	}
+}
+
+// watcher queues the events in memory,
+// so that emitting events never blocks.
+type watcher struct {
+	lock   sync.Mutex
+	queue  []fsdb.Event
+	notify chan struct{}
+}
+
+func (w *watcher) push(event fsdb.Event) {
+	w.lock.Lock()
+	w.queue = append(w.queue, event)
+	w.lock.Unlock()
+
+	select {
+	default:
+		// There's already a pending notification.
+	case w.notify <- struct{}{}:
+	}
+}
+
+func (w *watcher) drain() []fsdb.Event {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	events := w.queue
+	w.queue = nil
+	return events
+}
@@ -0,0 +1,32 @@
+package fsdb
+
+import (
+	"context"
+	"io"
+)
+
+// Metadata is the user metadata of an entry as string key-value pairs,
+// e.g. content type, origin, etc.
+type Metadata map[string]string
+
+// MetadataFSDB defines an optional interface for FSDB implementations that can
+// store user metadata along with the data.
+type MetadataFSDB interface {
+	// WriteWithMetadata writes an entry with metadata.
+	//
+	// Same as Write, if the key already exists, it will be overwritten,
+	// including its metadata.
+	// Write without metadata clears the metadata of the existing entry.
+	WriteWithMetadata(
+		ctx context.Context,
+		key Key,
+		data io.Reader,
+		metadata Metadata,
+	) error
+
+	// ReadMetadata returns the metadata of an entry.
+	//
+	// If the key does not exist, it should return a NoSuchKeyError.
+	// If the entry has no metadata, it returns nil map and nil error.
+	ReadMetadata(ctx context.Context, key Key) (Metadata, error)
+}
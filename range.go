@@ -0,0 +1,84 @@
+package fsdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrNegativeOffset is the error returned by ReadRange when the offset is
+// negative.
+var ErrNegativeOffset = errors.New("fsdb: negative offset")
+
+// RangeReader defines an optional interface for FSDB implementations that can
+// read a byte window of an entry.
+type RangeReader interface {
+	// ReadRange opens an entry and returns a ReadCloser reading at most length
+	// bytes starting from offset.
+	//
+	// If length is negative, it reads until the end of the entry.
+	// If offset is beyond the end of the entry, the reader returned is empty.
+	// If offset is negative, it should return ErrNegativeOffset.
+	//
+	// If the key does not exist, it should return a NoSuchKeyError.
+	//
+	// It should never return both nil reader and nil err.
+	//
+	// It's the caller's responsibility to close the ReadCloser returned.
+	ReadRange(
+		ctx context.Context,
+		key Key,
+		offset, length int64,
+	) (reader io.ReadCloser, err error)
+}
+
+// ReadRange reads a byte window of an entry from db.
+//
+// If db implements RangeReader, its ReadRange function will be used.
+// Otherwise it falls back to Read and discards the data before offset.
+func ReadRange(
+	ctx context.Context,
+	db FSDB,
+	key Key,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	if rr, ok := db.(RangeReader); ok {
+		return rr.ReadRange(ctx, key, offset, length)
+	}
+	if offset < 0 {
+		return nil, ErrNegativeOffset
+	}
+	reader, err := db.Read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return LimitRange(reader, offset, length)
+}
+
+// LimitRange discards the first offset bytes from reader, and limits it to at
+// most length bytes (or unlimited if length is negative).
+//
+// The returned ReadCloser closes the original reader.
+// If it returns an error, the original reader is closed.
+func LimitRange(
+	reader io.ReadCloser,
+	offset, length int64,
+) (io.ReadCloser, error) {
+	if _, err := io.CopyN(ioutil.Discard, reader, offset); err != nil && err != io.EOF {
+		reader.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return reader, nil
+	}
+	return &limitedReadCloser{
+		Reader: io.LimitReader(reader, length),
+		Closer: reader,
+	}, nil
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
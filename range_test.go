@@ -0,0 +1,42 @@
+package fsdb_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fishy/fsdb"
+)
+
+func TestLimitRange(t *testing.T) {
+	content := "Hello, world!"
+	cases := []struct {
+		label          string
+		offset, length int64
+		expect         string
+	}{
+		{"all", 0, -1, content},
+		{"head", 0, 5, "Hello"},
+		{"middle", 7, 5, "world"},
+		{"tail", 7, -1, "world!"},
+		{"over-offset", 100, -1, ""},
+	}
+	for _, c := range cases {
+		reader, err := fsdb.LimitRange(
+			ioutil.NopCloser(strings.NewReader(content)),
+			c.offset,
+			c.length,
+		)
+		if err != nil {
+			t.Fatalf("LimitRange %s failed: %v", c.label, err)
+		}
+		actual, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("LimitRange %s read failed: %v", c.label, err)
+		}
+		if string(actual) != c.expect {
+			t.Errorf("LimitRange %s expected %q, got %q", c.label, c.expect, actual)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package replicated provides an FSDB implementation mirroring every entry to
+// multiple local FSDB instances, e.g. on different disks or NFS mounts.
+//
+// Quorum
+//
+// Writes and deletes go to all the replicas concurrently,
+// and succeed when they succeed on at least the write quorum of replicas.
+// Reads read from all the replicas concurrently,
+// and succeed when at least the read quorum of replicas responded
+// (a replica not having the key, or having a corrupted copy, still counts as
+// responded).
+// Both quorums default to a majority of the replicas.
+//
+// Repair
+//
+// On every read, the copies on the replicas are compared by the SHA-256
+// checksums and generations reported by fsdb.Stater,
+// without reading their data.
+// If they disagree, the copy with the highest generation
+// (or the most common copy when the replicas don't implement
+// fsdb.ConditionalWriter) wins,
+// and is copied to the replicas that are missing the key or have a different
+// copy (read-repair).
+// Replicas not reporting checksums (e.g. memory FSDB) only have their missing
+// copies repaired on reads.
+//
+// The background anti-entropy pass built on ScanKeys reads all the copies of
+// all the keys fully to compare them,
+// so it also heals the divergent copies on replicas not reporting checksums,
+// and the keys that are never read.
+//
+// Deletes
+//
+// There are no tombstones.
+// Instead, a key is considered deleted when at least the delete quorum of
+// replicas don't have it:
+// reads return fsdb.NoSuchKeyError and repair doesn't copy it back,
+// so a delete failed only on some of the replicas won't be undone.
+// The delete quorum is the write quorum,
+// raised to more than the number of replicas a successful write could miss
+// when the write quorum is not a majority.
+// Delete returns a QuorumError when it succeeded on fewer replicas than that,
+// so the caller can retry.
+//
+// The flip side is that a key written to fewer replicas than the delete quorum
+// (e.g. by a failed write, or directly to the replicas) is also considered
+// deleted, and won't be healed by repair.
+// The leftover copies are removed by the next Delete of the key.
+//
+// As every read stats the key on all the replicas,
+// it's recommended to put a cache (see package cache) in front of replicated
+// FSDB for read heavy workloads.
+package replicated
@@ -0,0 +1,89 @@
+package replicated
+
+import (
+	"fmt"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *QuorumError and *ReplicaError satisfy error interface.
+var (
+	_ error = (*QuorumError)(nil)
+	_ error = (*ReplicaError)(nil)
+)
+
+// QuorumError is an error returned when an operation failed on too many
+// replicas to reach the quorum.
+//
+// Writes succeeded on some of the replicas are not rolled back,
+// they will be propagated to the other replicas by read-repair and
+// anti-entropy.
+type QuorumError struct {
+	Key fsdb.Key
+
+	// Succeeded is the number of replicas the operation succeeded on.
+	Succeeded int
+
+	// Quorum is the number of replicas required.
+	Quorum int
+
+	// Err is the combined errors of the failed replicas,
+	// as ReplicaErrors.
+	Err error
+}
+
+func (err *QuorumError) Error() string {
+	return fmt.Sprintf(
+		"quorum not reached on key %q: %d of %d required replicas succeeded: %v",
+		err.Key,
+		err.Succeeded,
+		err.Quorum,
+		err.Err,
+	)
+}
+
+// Unwrap returns the underlying error.
+func (err *QuorumError) Unwrap() error {
+	return err.Err
+}
+
+// ReplicaError is an error returned by a single replica.
+type ReplicaError struct {
+	// Replica is the index of the replica in the replicas passed to Open.
+	Replica int
+	Err     error
+}
+
+func (err *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d: %v", err.Replica, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *ReplicaError) Unwrap() error {
+	return err.Err
+}
+
+// quorumError returns a QuorumError if succeeded < quorum,
+// or nil otherwise.
+//
+// errs are the errors of every replica, nil for the succeeded ones.
+func quorumError(key fsdb.Key, succeeded, quorum int, errs []error) error {
+	if succeeded >= quorum {
+		return nil
+	}
+	wrapped := make([]error, len(errs))
+	for i, err := range errs {
+		if err != nil {
+			wrapped[i] = &ReplicaError{
+				Replica: i,
+				Err:     err,
+			}
+		}
+	}
+	return &QuorumError{
+		Key:       key,
+		Succeeded: succeeded,
+		Quorum:    quorum,
+		Err:       fsdb.CombineErrors(wrapped...),
+	}
+}
@@ -0,0 +1,119 @@
+package replicated
+
+import (
+	"log"
+	"time"
+)
+
+// Default options values.
+const (
+	// DefaultQuorum means a majority of the replicas.
+	DefaultQuorum = 0
+
+	DefaultRepairDelay time.Duration = time.Hour
+)
+
+// Options defines a read-only view of options used in replicated FSDB.
+type Options interface {
+	// GetWriteQuorum returns the number of replicas a write (or delete) must
+	// succeed on.
+	//
+	// 0 means a majority of the replicas.
+	// Deletes could require more replicas when it's not a majority,
+	// see the Deletes section of the package doc.
+	GetWriteQuorum() int
+
+	// GetReadQuorum returns the number of replicas a read must get responses
+	// from.
+	//
+	// 0 means a majority of the replicas.
+	GetReadQuorum() int
+
+	// GetRepairDelay returns the delay between two background anti-entropy
+	// passes.
+	//
+	// 0 disables the background anti-entropy passes.
+	GetRepairDelay() time.Duration
+
+	// GetLogger returns the logger to be used in replicated FSDB.
+	//
+	// If it returns nil, nothing will be logged.
+	GetLogger() *log.Logger
+}
+
+// OptionsBuilder defines a read write view of options used in replicated FSDB.
+type OptionsBuilder interface {
+	Options
+
+	// Build builds the read-only view of the options.
+	Build() Options
+
+	// SetWriteQuorum sets the write quorum.
+	SetWriteQuorum(quorum int) OptionsBuilder
+
+	// SetReadQuorum sets the read quorum.
+	SetReadQuorum(quorum int) OptionsBuilder
+
+	// SetRepairDelay sets the delay between two background anti-entropy passes.
+	SetRepairDelay(delay time.Duration) OptionsBuilder
+
+	// SetLogger sets the logger used in replicated FSDB.
+	SetLogger(logger *log.Logger) OptionsBuilder
+}
+
+type options struct {
+	writeQuorum int
+	readQuorum  int
+	delay       time.Duration
+	logger      *log.Logger
+}
+
+// NewDefaultOptions creates the default options.
+func NewDefaultOptions() OptionsBuilder {
+	return &options{
+		writeQuorum: DefaultQuorum,
+		readQuorum:  DefaultQuorum,
+		delay:       DefaultRepairDelay,
+		logger:      nil,
+	}
+}
+
+func (opt *options) GetWriteQuorum() int {
+	return opt.writeQuorum
+}
+
+func (opt *options) GetReadQuorum() int {
+	return opt.readQuorum
+}
+
+func (opt *options) GetRepairDelay() time.Duration {
+	return opt.delay
+}
+
+func (opt *options) GetLogger() *log.Logger {
+	return opt.logger
+}
+
+func (opt *options) Build() Options {
+	return opt
+}
+
+func (opt *options) SetWriteQuorum(quorum int) OptionsBuilder {
+	opt.writeQuorum = quorum
+	return opt
+}
+
+func (opt *options) SetReadQuorum(quorum int) OptionsBuilder {
+	opt.readQuorum = quorum
+	return opt
+}
+
+func (opt *options) SetRepairDelay(delay time.Duration) OptionsBuilder {
+	opt.delay = delay
+	return opt
+}
+
+func (opt *options) SetLogger(logger *log.Logger) OptionsBuilder {
+	opt.logger = logger
+	return opt
+}
@@ -0,0 +1,302 @@
+package replicated
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+// replicaCopy is the copy of an entry on a replica.
+type replicaCopy struct {
+	// err is nil if the copy was read successfully.
+	err error
+
+	// checksum is the hex encoded SHA-256 checksum of the data,
+	// or empty if it's unknown.
+	checksum   string
+	generation int64
+	versioned  bool
+}
+
+// copyReader reads the copy of key on replica.
+type copyReader func(ctx context.Context, replica fsdb.Local, key fsdb.Key) replicaCopy
+
+// responded returns true if the replica responded,
+// including the cases it doesn't have the key or its copy is corrupted.
+func (c replicaCopy) responded() bool {
+	return c.err == nil ||
+		fsdb.IsNoSuchKeyError(c.err) ||
+		fsdb.IsCorruptEntryError(c.err)
+}
+
+// same returns true if the two copies are the same.
+//
+// Copies with unknown checksums can't be told apart,
+// so they are considered the same.
+func (c replicaCopy) same(other replicaCopy) bool {
+	if c.err == nil || other.err == nil {
+		if c.err != nil || other.err != nil {
+			return false
+		}
+		if c.checksum == "" || other.checksum == "" {
+			return true
+		}
+		return c.checksum == other.checksum
+	}
+	return fsdb.IsNoSuchKeyError(c.err) == fsdb.IsNoSuchKeyError(other.err) &&
+		fsdb.IsCorruptEntryError(c.err) == fsdb.IsCorruptEntryError(other.err)
+}
+
+// readCopy reads the copy of key on replica fully to calculate its checksum.
+//
+// It's used by the anti-entropy Repair.
+func readCopy(ctx context.Context, replica fsdb.Local, key fsdb.Key) replicaCopy {
+	reader, err := replica.Read(ctx, key)
+	if err != nil {
+		return replicaCopy{err: err}
+	}
+	defer reader.Close()
+
+	var c replicaCopy
+	if v, ok := reader.(fsdb.Versioned); ok {
+		c.generation = v.Generation()
+		c.versioned = true
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return replicaCopy{err: err}
+	}
+	c.checksum = hex.EncodeToString(h.Sum(nil))
+	return c
+}
+
+// statCopy gets the checksum and generation of the copy of key on replica
+// without reading the data.
+//
+// It's used by Read.
+// The checksum is only known if the replica implements fsdb.Stater and
+// stores checksums.
+func statCopy(ctx context.Context, replica fsdb.Local, key fsdb.Key) replicaCopy {
+	if stater, ok := replica.(fsdb.Stater); ok {
+		info, err := stater.Stat(ctx, key)
+		if err != nil {
+			return replicaCopy{err: err}
+		}
+		return replicaCopy{
+			checksum:   info.Checksum,
+			generation: info.Generation,
+			versioned:  info.Generation != 0,
+		}
+	}
+
+	reader, err := replica.Read(ctx, key)
+	if err != nil {
+		return replicaCopy{err: err}
+	}
+	defer reader.Close()
+	var c replicaCopy
+	if v, ok := reader.(fsdb.Versioned); ok {
+		c.generation = v.Generation()
+		c.versioned = true
+	}
+	return c
+}
+
+// readCopies reads the copies of key on all the replicas concurrently.
+func (db *impl) readCopies(
+	ctx context.Context,
+	key fsdb.Key,
+	read copyReader,
+) []replicaCopy {
+	copies := make([]replicaCopy, len(db.replicas))
+	errs := fsdb.BatchDo(ctx, len(db.replicas), len(db.replicas), func(i int) error {
+		copies[i] = read(ctx, db.replicas[i], key)
+		return nil
+	})
+	for i, err := range errs {
+		if err != nil {
+			copies[i].err = err
+		}
+	}
+	return copies
+}
+
+// resolve returns the index of the replica having the winning copy,
+// or -1 if the key is considered deleted.
+//
+// The key is considered deleted when at least the delete quorum of replicas
+// don't have it, so a delete failed only on some of the replicas won't be
+// undone by repair.
+//
+// It returns a QuorumError if fewer replicas than quorum responded.
+func (db *impl) resolve(
+	key fsdb.Key,
+	copies []replicaCopy,
+	quorum int,
+) (int, error) {
+	responded := 0
+	missing := 0
+	allVersioned := true
+	errs := make([]error, len(copies))
+	counts := make(map[string]int)
+	for i, c := range copies {
+		if c.responded() {
+			responded++
+		} else {
+			errs[i] = c.err
+		}
+		if fsdb.IsNoSuchKeyError(c.err) {
+			missing++
+		}
+		if c.err == nil {
+			counts[c.checksum]++
+			allVersioned = allVersioned && c.versioned
+		}
+	}
+	if err := quorumError(key, responded, quorum, errs); err != nil {
+		return -1, err
+	}
+	if missing >= db.deleteQuorum() {
+		return -1, nil
+	}
+
+	winner := -1
+	for i, c := range copies {
+		if c.err != nil {
+			continue
+		}
+		if winner < 0 {
+			winner = i
+			continue
+		}
+		w := copies[winner]
+		if allVersioned {
+			if c.generation > w.generation {
+				winner = i
+			}
+		} else if counts[c.checksum] > counts[w.checksum] {
+			winner = i
+		}
+	}
+	return winner, nil
+}
+
+// repair copies the entry from the winner replica to all the other responded
+// replicas having different copies.
+//
+// read is used to check the copies again before repairing them,
+// it should be the same one used to read copies.
+//
+// It returns the number of replicas repaired.
+func (db *impl) repair(
+	ctx context.Context,
+	key fsdb.Key,
+	copies []replicaCopy,
+	winner int,
+	read copyReader,
+) (int, error) {
+	repaired := 0
+	var firstErr error
+	for i, c := range copies {
+		if i == winner || !c.responded() || c.same(copies[winner]) {
+			continue
+		}
+		if err := db.repairReplica(ctx, key, i, c, winner, read); err != nil {
+			if logger := db.opts.GetLogger(); logger != nil {
+				logger.Printf("failed to repair %v on replica %d: %v", key, i, err)
+			}
+			if firstErr == nil {
+				firstErr = &ReplicaError{
+					Replica: i,
+					Err:     err,
+				}
+			}
+			continue
+		}
+		repaired++
+	}
+	return repaired, firstErr
+}
+
+// repairReplica copies the entry from the winner replica to replica i,
+// if the copy on replica i is still the same as c.
+func (db *impl) repairReplica(
+	ctx context.Context,
+	key fsdb.Key,
+	i int,
+	c replicaCopy,
+	winner int,
+	read copyReader,
+) error {
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	if !read(ctx, db.replicas[i], key).same(c) {
+		// Changed by a write after we read it.
+		return nil
+	}
+	return fsdb.CopyEntry(ctx, db.replicas[winner], db.replicas[i], key)
+}
+
+func (db *impl) Repair(ctx context.Context) error {
+	started := time.Now()
+	scanned := 0
+	repaired := 0
+	var firstErr error
+	if err := db.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			scanned++
+			copies := db.readCopies(ctx, key, readCopy)
+			winner, err := db.resolve(key, copies, 1)
+			if err == nil && winner >= 0 {
+				var n int
+				n, err = db.repair(ctx, key, copies, winner, readCopy)
+				repaired += n
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return true
+		},
+		func(path string, err error) bool {
+			if logger := db.opts.GetLogger(); logger != nil {
+				logger.Printf("ScanKeys reported error on %s: %v", path, err)
+			}
+			return true
+		},
+	); err != nil {
+		return err
+	}
+
+	if logger := db.opts.GetLogger(); logger != nil {
+		logger.Printf(
+			"repair took %v, scanned %d, repaired %d",
+			time.Now().Sub(started),
+			scanned,
+			repaired,
+		)
+	}
+	return firstErr
+}
+
+func (db *impl) startRepairLoop(ctx context.Context) {
+	ticker := time.NewTicker(db.opts.GetRepairDelay())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := db.Repair(ctx); err != nil {
+				if logger := db.opts.GetLogger(); logger != nil {
+					logger.Printf("repair failed: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package replicated
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/fishy/rowlock"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies FSDB interface.
+var _ FSDB = (*impl)(nil)
+
+// ErrNoReplicas is the error returned by Open when no replicas are given.
+var ErrNoReplicas = errors.New("replicated: no replicas")
+
+// errReplicaDone is used to unblock the writes to a replica that already
+// returned.
+var errReplicaDone = errors.New("replicated: replica write returned")
+
+// FSDB is the fsdb.Local returned by Open.
+type FSDB interface {
+	fsdb.Local
+
+	// Repair runs an anti-entropy pass,
+	// healing the missing and divergent copies of all the keys.
+	//
+	// It's called by the background anti-entropy loop,
+	// and it's safe to call it manually.
+	// Errors on individual keys don't stop the pass,
+	// the first one is returned after the pass finishes.
+	Repair(ctx context.Context) error
+}
+
+type impl struct {
+	replicas []fsdb.Local
+	opts     Options
+	locks    *rowlock.RowLock
+}
+
+// Open creates a replicated FSDB mirroring every entry to all the replicas.
+//
+// The context passed in will be used to control the background anti-entropy
+// loop.
+func Open(ctx context.Context, replicas []fsdb.Local, opts Options) (FSDB, error) {
+	if len(replicas) == 0 {
+		return nil, ErrNoReplicas
+	}
+	db := &impl{
+		replicas: replicas,
+		opts:     opts,
+		locks:    rowlock.NewRowLock(rowlock.MutexNewLocker),
+	}
+	if opts.GetRepairDelay() > 0 {
+		go db.startRepairLoop(ctx)
+	}
+	return db, nil
+}
+
+// quorum returns the actual quorum from the quorum in options.
+func (db *impl) quorum(q int) int {
+	n := len(db.replicas)
+	if q <= 0 {
+		return n/2 + 1
+	}
+	if q > n {
+		return n
+	}
+	return q
+}
+
+// deleteQuorum returns the number of replicas a delete must succeed on.
+//
+// It's the write quorum,
+// raised when necessary so that a key deleted from that many replicas can't
+// also be a key successfully written to the write quorum of replicas.
+func (db *impl) deleteQuorum() int {
+	w := db.quorum(db.opts.GetWriteQuorum())
+	if q := len(db.replicas) - w + 1; q > w {
+		return q
+	}
+	return w
+}
+
+// Read stats the key on all the replicas,
+// and fails if fewer replicas than the read quorum responded.
+// Only the data from the winning replica is read.
+//
+// If the replicas disagree,
+// the copy with the highest generation wins
+// (or the most common copy when the replicas don't report generations),
+// and the other replicas are repaired before returning.
+func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if len(db.replicas) == 1 {
+		return db.replicas[0].Read(ctx, key)
+	}
+
+	copies := db.readCopies(ctx, key, statCopy)
+	winner, err := db.resolve(key, copies, db.quorum(db.opts.GetReadQuorum()))
+	if err != nil {
+		return nil, err
+	}
+	if winner < 0 {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	// Read-repair errors are logged and don't fail the read.
+	db.repair(ctx, key, copies, winner, statCopy)
+	return db.replicas[winner].Read(ctx, key)
+}
+
+// Write writes the data to all the replicas concurrently,
+// and fails if it failed on more replicas than the write quorum allows.
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	n := len(db.replicas)
+	out := &fanout{
+		writers: make([]*io.PipeWriter, n),
+		failed:  make([]bool, n),
+	}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i, replica := range db.replicas {
+		reader, writer := io.Pipe()
+		out.writers[i] = writer
+		go func(i int, replica fsdb.Local) {
+			defer wg.Done()
+			errs[i] = replica.Write(ctx, key, reader)
+			reader.CloseWithError(errReplicaDone)
+		}(i, replica)
+	}
+
+	_, err := io.Copy(out, data)
+	if err == errReplicaDone {
+		// All the replicas returned early, their errors are reported below.
+		err = nil
+	}
+	for _, writer := range out.writers {
+		if err != nil {
+			// Reading from data failed, abort all the replicas.
+			writer.CloseWithError(err)
+		} else {
+			writer.Close()
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+	return quorumError(key, succeeded, db.quorum(db.opts.GetWriteQuorum()), errs)
+}
+
+// Delete deletes the key from all the replicas concurrently,
+// and fails if it failed on more replicas than the delete quorum allows.
+//
+// Replicas not having the key count as succeeded,
+// and it only returns a NoSuchKeyError when none of the replicas has the key.
+func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	errs := fsdb.BatchDo(ctx, len(db.replicas), len(db.replicas), func(i int) error {
+		return db.replicas[i].Delete(ctx, key)
+	})
+	succeeded := 0
+	existNone := true
+	for i, err := range errs {
+		if err == nil || fsdb.IsNoSuchKeyError(err) {
+			if err == nil {
+				existNone = false
+			}
+			succeeded++
+			errs[i] = nil
+		}
+	}
+	if existNone && succeeded == len(db.replicas) {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	return quorumError(key, succeeded, db.deleteQuorum(), errs)
+}
+
+// ScanKeys scans the keys on all the replicas in order.
+//
+// A key found on a replica is skipped if any of the replicas before it also
+// has it.
+// Replicas failed to scan are skipped if errFunc returns true on the error
+// (the path is empty in that case).
+func (db *impl) ScanKeys(
+	ctx context.Context,
+	keyFunc fsdb.KeyFunc,
+	errFunc fsdb.ErrFunc,
+) error {
+	aborted := false
+	for i, replica := range db.replicas {
+		if err := replica.ScanKeys(
+			ctx,
+			func(key fsdb.Key) bool {
+				for j := 0; j < i; j++ {
+					if exists(ctx, db.replicas[j], key) {
+						return true
+					}
+				}
+				if !keyFunc(key) {
+					aborted = true
+					return false
+				}
+				return true
+			},
+			errFunc,
+		); err != nil {
+			if !fsdb.IsCanceledError(err) && errFunc("", &ReplicaError{
+				Replica: i,
+				Err:     err,
+			}) {
+				continue
+			}
+			return err
+		}
+		if aborted {
+			return nil
+		}
+	}
+	return nil
+}
+
+// exists checks whether key exists on replica.
+//
+// Errors other than NoSuchKeyError are treated as existing,
+// to err on the side of not reporting a key twice.
+func exists(ctx context.Context, replica fsdb.Local, key fsdb.Key) bool {
+	if stater, ok := replica.(fsdb.Stater); ok {
+		_, err := stater.Stat(ctx, key)
+		return !fsdb.IsNoSuchKeyError(err)
+	}
+	reader, err := replica.Read(ctx, key)
+	if err == nil {
+		reader.Close()
+	}
+	return !fsdb.IsNoSuchKeyError(err)
+}
+
+// fanout writes to all the pipes not failed yet.
+type fanout struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	for i, writer := range f.writers {
+		if f.failed[i] {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			// The replica returned early, stop feeding it.
+			f.failed[i] = true
+		}
+	}
+	if f.allFailed() {
+		return 0, errReplicaDone
+	}
+	return len(p), nil
+}
+
+func (f *fanout) allFailed() bool {
+	for _, failed := range f.failed {
+		if !failed {
+			return false
+		}
+	}
+	return true
+}
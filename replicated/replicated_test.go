@@ -0,0 +1,377 @@
+package replicated_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/local"
+	"github.com/fishy/fsdb/memory"
+	"github.com/fishy/fsdb/replicated"
+)
+
+var errInjected = errors.New("injected")
+
+func TestConformance(t *testing.T) {
+	fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+		db, _ := openReplicated(t, 3, replicated.NewDefaultOptions())
+		return db
+	})
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := replicated.Open(
+		context.Background(),
+		nil,
+		replicated.NewDefaultOptions(),
+	); err != replicated.ErrNoReplicas {
+		t.Errorf("Open without replicas expected ErrNoReplicas, got %v", err)
+	}
+}
+
+func TestWriteQuorum(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+	failing := failingReplica(memory.OpWrite)
+
+	replicas := []fsdb.Local{
+		memory.Open(memory.NewDefaultOptions()),
+		memory.Open(memory.NewDefaultOptions()),
+		failing,
+	}
+	db, err := replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Errorf("Write with 2 of 3 replicas succeeded should not fail: %v", err)
+	}
+
+	replicas[1] = failingReplica(memory.OpWrite)
+	db, err = replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	err = db.Write(ctx, key, strings.NewReader("bar"))
+	var qe *replicated.QuorumError
+	if !errors.As(err, &qe) {
+		t.Fatalf("Write with 1 of 3 replicas succeeded expected QuorumError, got %v", err)
+	}
+	if qe.Succeeded != 1 || qe.Quorum != 2 {
+		t.Errorf("Expected 1 of 2 replicas succeeded, got %+v", qe)
+	}
+	if !errors.Is(err, errInjected) {
+		t.Errorf("QuorumError expected to wrap the replica errors, got %v", err)
+	}
+	var re *replicated.ReplicaError
+	if !errors.As(err, &re) || re.Replica != 1 {
+		t.Errorf("QuorumError expected to contain ReplicaError of replica 1, got %v", err)
+	}
+	checkRead(t, replicas[0], key, "bar")
+}
+
+func TestReadRepair(t *testing.T) {
+	ctx := context.Background()
+	// Read-repair relies on the checksums stored by local FSDB.
+	replicas := openLocalReplicas(t, 3)
+	db, err := replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := fsdb.Key("foo")
+
+	if err := db.Write(ctx, key, strings.NewReader("old")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// Newer copy on replica 1 only, missing on replica 2.
+	if err := replicas[1].Write(ctx, key, strings.NewReader("new")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := replicas[2].Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	checkRead(t, db, key, "new")
+	for i, replica := range replicas {
+		reader, err := replica.Read(ctx, key)
+		if err != nil {
+			t.Fatalf("Read on replica %d failed: %v", i, err)
+		}
+		data, _ := ioutil.ReadAll(reader)
+		reader.Close()
+		if string(data) != "new" {
+			t.Errorf("Replica %d not repaired, got %q", i, data)
+		}
+	}
+}
+
+func TestReadOnlyWinner(t *testing.T) {
+	ctx := context.Background()
+	var reads int64
+	replicas := openLocalReplicas(t, 3)
+	for i := range replicas {
+		replicas[i] = &countingReplica{Local: replicas[i], reads: &reads}
+	}
+	db, err := replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	key := fsdb.Key("foo")
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	atomic.StoreInt64(&reads, 0)
+	checkRead(t, db, key, "foo")
+	if n := atomic.LoadInt64(&reads); n != 1 {
+		t.Errorf("Read expected to read from 1 replica, read from %d", n)
+	}
+}
+
+func TestReadQuorum(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+	// Read stats all the replicas and only reads from one of them.
+	replicas := []fsdb.Local{
+		memory.Open(memory.NewDefaultOptions()),
+		failingReplica(memory.OpStat),
+		failingReplica(memory.OpStat),
+	}
+	if err := replicas[0].Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	db, err := replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var qe *replicated.QuorumError
+	if _, err := db.Read(ctx, key); !errors.As(err, &qe) {
+		t.Errorf("Read with 1 of 3 replicas responded expected QuorumError, got %v", err)
+	}
+
+	db, err = replicated.Open(
+		ctx,
+		replicas,
+		replicated.NewDefaultOptions().SetRepairDelay(0).SetReadQuorum(1),
+	)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkRead(t, db, key, "foo")
+}
+
+func TestRepair(t *testing.T) {
+	const n = 50
+	ctx := context.Background()
+	db, replicas := openReplicated(t, 3, replicated.NewDefaultOptions())
+
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		// Spread the keys unevenly on the replicas,
+		// each key missing on one of them.
+		for _, j := range []int{i % 3, (i + 1) % 3} {
+			if err := replicas[j].Write(ctx, key, strings.NewReader(key.String())); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+	}
+	if err := db.Repair(ctx); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	for i, replica := range replicas {
+		count := 0
+		if err := replica.ScanKeys(
+			ctx,
+			func(key fsdb.Key) bool {
+				count++
+				return true
+			},
+			fsdb.StopAll,
+		); err != nil {
+			t.Fatalf("ScanKeys failed: %v", err)
+		}
+		if count != n {
+			t.Errorf("Replica %d expected %d keys after repair, got %d", i, n, count)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	db, replicas := openReplicated(t, 3, replicated.NewDefaultOptions())
+	key := fsdb.Key("foo")
+
+	// Only on one replica.
+	if err := replicas[2].Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := db.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := db.Delete(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Delete again expected NoSuchKeyError, got %v", err)
+	}
+}
+
+func TestFailedDelete(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+	replicas := []fsdb.Local{
+		memory.Open(memory.NewDefaultOptions()),
+		memory.Open(memory.NewDefaultOptions()),
+		failingReplica(memory.OpDelete),
+	}
+	db, err := replicated.Open(ctx, replicas, replicated.NewDefaultOptions().SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := db.Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := db.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete with 2 of 3 replicas succeeded should not fail: %v", err)
+	}
+
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read after Delete expected NoSuchKeyError, got %v", err)
+	}
+	if err := db.Repair(ctx); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	for i, replica := range replicas[:2] {
+		if _, err := replica.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+			t.Errorf("Deleted key repaired back to replica %d: %v", i, err)
+		}
+	}
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Read after Repair expected NoSuchKeyError, got %v", err)
+	}
+}
+
+func TestDeleteQuorum(t *testing.T) {
+	ctx := context.Background()
+	key := fsdb.Key("foo")
+	replicas := []fsdb.Local{
+		memory.Open(memory.NewDefaultOptions()),
+		failingReplica(memory.OpDelete),
+		failingReplica(memory.OpDelete),
+	}
+	// With write quorum 1, a successful write could miss 2 of the replicas,
+	// so a delete must succeed on all 3 of them.
+	db, err := replicated.Open(
+		ctx,
+		replicas,
+		replicated.NewDefaultOptions().SetRepairDelay(0).SetWriteQuorum(1),
+	)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := replicas[0].Write(ctx, key, strings.NewReader("foo")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	checkRead(t, db, key, "foo")
+
+	if err := db.Write(ctx, key, strings.NewReader("bar")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	err = db.Delete(ctx, key)
+	var qe *replicated.QuorumError
+	if !errors.As(err, &qe) {
+		t.Fatalf("Delete with 1 of 3 replicas succeeded expected QuorumError, got %v", err)
+	}
+	if qe.Succeeded != 1 || qe.Quorum != 3 {
+		t.Errorf("Expected 1 of 3 replicas succeeded, got %+v", qe)
+	}
+}
+
+func openReplicated(
+	t *testing.T,
+	n int,
+	opts replicated.OptionsBuilder,
+) (replicated.FSDB, []fsdb.Local) {
+	t.Helper()
+
+	replicas := make([]fsdb.Local, n)
+	for i := range replicas {
+		replicas[i] = memory.Open(memory.NewDefaultOptions())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	db, err := replicated.Open(ctx, replicas, opts.SetRepairDelay(0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return db, replicas
+}
+
+// openLocalReplicas opens n local FSDB replicas under temporary directories.
+func openLocalReplicas(t *testing.T, n int) []fsdb.Local {
+	t.Helper()
+
+	replicas := make([]fsdb.Local, n)
+	for i := range replicas {
+		root, err := ioutil.TempDir("", "fsdb_")
+		if err != nil {
+			t.Fatalf("failed to get tmp dir: %v", err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(root)
+		})
+		replicas[i] = local.Open(local.NewDefaultOptions(root))
+	}
+	return replicas
+}
+
+// failingReplica returns a replica failing all the op operations.
+func failingReplica(op memory.Op) fsdb.Local {
+	return memory.Open(memory.NewDefaultOptions().SetHook(
+		func(ctx context.Context, o memory.Op, key fsdb.Key) error {
+			if o == op {
+				return errInjected
+			}
+			return nil
+		},
+	))
+}
+
+func checkRead(t *testing.T, db fsdb.FSDB, key fsdb.Key, expected string) {
+	t.Helper()
+
+	reader, err := db.Read(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Read %v failed: %v", key, err)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read %v content failed: %v", key, err)
+	}
+	if string(actual) != expected {
+		t.Errorf("Read %v expected %q, got %q", key, expected, actual)
+	}
+}
+
+// countingReplica counts the Read calls on a replica.
+type countingReplica struct {
+	fsdb.Local
+
+	reads *int64
+}
+
+func (r *countingReplica) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	atomic.AddInt64(r.reads, 1)
+	return r.Local.Read(ctx, key)
+}
+
+func (r *countingReplica) Stat(ctx context.Context, key fsdb.Key) (*fsdb.EntryInfo, error) {
+	return r.Local.(fsdb.Stater).Stat(ctx, key)
+}
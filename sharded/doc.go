@@ -0,0 +1,27 @@
+// Package sharded provides an FSDB implementation spreading keys over
+// multiple local FSDB instances, e.g. one per disk.
+//
+// Keys are routed to shards by consistent hashing on the shard names,
+// so adding a shard only moves the keys it now owns (roughly 1/N of all the
+// keys) from the other shards.
+//
+// Rebalance
+//
+// AddShard moves the affected keys online:
+// while the keys are being moved,
+// reads fall back to the previous shard of the key,
+// writes go to the new shard and remove the stale copy from the previous one,
+// and deletes remove the key from both.
+// Metadata and expiration are moved along with the data when the shards
+// support them.
+//
+// The move only happens within the process calling AddShard,
+// and the previous shards are only kept in memory.
+// If the process exits before the move finishes,
+// open the sharded FSDB with all the shards,
+// set the names of the previous shards by OptionsBuilder.SetPreviousShards,
+// and call Rebalance.
+// Keys not moved yet are still readable in the meantime.
+// Once Rebalance returns nil,
+// the previous shards are no longer needed in the options.
+package sharded
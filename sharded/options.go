@@ -0,0 +1,125 @@
+package sharded
+
+import (
+	"hash"
+	"hash/fnv"
+	"log"
+)
+
+// Default options values.
+const (
+	DefaultVirtualNodes = 128
+)
+
+// DefaultHashFunc is the default hash function used by the consistent hashing
+// ring, which is 64-bit FNV-1a.
+var DefaultHashFunc = fnv.New64a
+
+// Options defines a read-only view of options used in sharded FSDB.
+type Options interface {
+	// GetVirtualNodes returns the number of points every shard has on the
+	// consistent hashing ring.
+	//
+	// The more points, the more evenly the keys are distributed.
+	GetVirtualNodes() int
+
+	// GetHashFunc returns the hash function used by the consistent hashing
+	// ring.
+	GetHashFunc() func() hash.Hash64
+
+	// GetPreviousShards returns the names of the shards before an unfinished
+	// AddShard, e.g. one interrupted by a process exit.
+	//
+	// If it's not empty,
+	// Open routes the keys the same way as during the move of AddShard:
+	// reads fall back to the previous shard of the key,
+	// until Rebalance finishes the move.
+	GetPreviousShards() []string
+
+	// GetLogger returns the logger to be used in sharded FSDB.
+	//
+	// If it returns nil, nothing will be logged.
+	GetLogger() *log.Logger
+}
+
+// OptionsBuilder defines a read write view of options used in sharded FSDB.
+//
+// Changing virtual nodes or hash function on an existing sharded FSDB will
+// route keys to wrong shards.
+type OptionsBuilder interface {
+	Options
+
+	// Build builds the read-only view of the options.
+	Build() Options
+
+	// SetVirtualNodes sets the number of points every shard has on the ring.
+	SetVirtualNodes(n int) OptionsBuilder
+
+	// SetHashFunc sets the hash function used by the ring.
+	SetHashFunc(f func() hash.Hash64) OptionsBuilder
+
+	// SetPreviousShards sets the names of the shards before an unfinished
+	// AddShard.
+	//
+	// All of them must still be in the shards passed to Open.
+	SetPreviousShards(names ...string) OptionsBuilder
+
+	// SetLogger sets the logger used in sharded FSDB.
+	SetLogger(logger *log.Logger) OptionsBuilder
+}
+
+type options struct {
+	vnodes   int
+	hashFunc func() hash.Hash64
+	prev     []string
+	logger   *log.Logger
+}
+
+// NewDefaultOptions creates the default options.
+func NewDefaultOptions() OptionsBuilder {
+	return &options{
+		vnodes:   DefaultVirtualNodes,
+		hashFunc: DefaultHashFunc,
+		logger:   nil,
+	}
+}
+
+func (opt *options) GetVirtualNodes() int {
+	return opt.vnodes
+}
+
+func (opt *options) GetHashFunc() func() hash.Hash64 {
+	return opt.hashFunc
+}
+
+func (opt *options) GetPreviousShards() []string {
+	return opt.prev
+}
+
+func (opt *options) GetLogger() *log.Logger {
+	return opt.logger
+}
+
+func (opt *options) Build() Options {
+	return opt
+}
+
+func (opt *options) SetVirtualNodes(n int) OptionsBuilder {
+	opt.vnodes = n
+	return opt
+}
+
+func (opt *options) SetHashFunc(f func() hash.Hash64) OptionsBuilder {
+	opt.hashFunc = f
+	return opt
+}
+
+func (opt *options) SetPreviousShards(names ...string) OptionsBuilder {
+	opt.prev = names
+	return opt
+}
+
+func (opt *options) SetLogger(logger *log.Logger) OptionsBuilder {
+	opt.logger = logger
+	return opt
+}
@@ -0,0 +1,129 @@
+package sharded
+
+import (
+	"context"
+	"time"
+
+	"github.com/fishy/fsdb"
+)
+
+func (db *impl) AddShard(ctx context.Context, shard Shard) error {
+	db.rebalance.Lock()
+	defer db.rebalance.Unlock()
+
+	// Finish the previous move first,
+	// as we can only fall back to one previous ring.
+	if err := db.move(ctx); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	shards := append(append([]Shard(nil), db.shards...), shard)
+	if err := checkNames(shards); err != nil {
+		db.lock.Unlock()
+		return err
+	}
+	db.shards = shards
+	db.prev = db.ring
+	db.ring = newRing(db.opts, db.names())
+	db.lock.Unlock()
+
+	return db.move(ctx)
+}
+
+func (db *impl) Rebalance(ctx context.Context) error {
+	db.rebalance.Lock()
+	defer db.rebalance.Unlock()
+
+	return db.move(ctx)
+}
+
+// move moves the keys not owned by their shards anymore after the last
+// AddShard to their new shards.
+//
+// It must be called with the rebalance lock held.
+func (db *impl) move(ctx context.Context) error {
+	db.lock.RLock()
+	shards := db.shards
+	current, prev := db.ring, db.prev
+	db.lock.RUnlock()
+	if prev == nil {
+		return nil
+	}
+
+	started := time.Now()
+	moved := 0
+	var moveErr error
+	for i := range shards {
+		if err := shards[i].DB.ScanKeys(
+			ctx,
+			func(key fsdb.Key) bool {
+				if prev.owner(key) != i {
+					return true
+				}
+				j := current.owner(key)
+				if j == i {
+					return true
+				}
+				if err := db.moveKey(ctx, key, shards[i].DB, shards[j].DB); err != nil {
+					moveErr = err
+					return false
+				}
+				moved++
+				return true
+			},
+			fsdb.StopAll,
+		); err != nil {
+			return err
+		}
+		if moveErr != nil {
+			return moveErr
+		}
+	}
+
+	db.lock.Lock()
+	db.prev = nil
+	db.lock.Unlock()
+
+	if logger := db.opts.GetLogger(); logger != nil {
+		logger.Printf(
+			"moved %d keys to their new shards, took %v",
+			moved,
+			time.Now().Sub(started),
+		)
+	}
+	return nil
+}
+
+// moveKey moves key from shard src to shard dst.
+func (db *impl) moveKey(
+	ctx context.Context,
+	key fsdb.Key,
+	src, dst fsdb.Local,
+) error {
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	reader, err := dst.Read(ctx, key)
+	if err == nil {
+		// Written after AddShard, the copy on src is stale.
+		reader.Close()
+		return ignoreNoSuchKey(src.Delete(ctx, key))
+	}
+	if !fsdb.IsNoSuchKeyError(err) {
+		return err
+	}
+
+	if err := fsdb.CopyEntry(ctx, src, dst, key); err != nil {
+		// Deleted or expired after the scan.
+		return ignoreNoSuchKey(err)
+	}
+	return ignoreNoSuchKey(src.Delete(ctx, key))
+}
+
+func ignoreNoSuchKey(err error) error {
+	if fsdb.IsNoSuchKeyError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package sharded
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/fishy/fsdb"
+)
+
+// ring is an immutable consistent hashing ring.
+type ring struct {
+	opts   Options
+	points []point
+}
+
+// point is a virtual node of a shard on the ring.
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// newRing creates a ring of shards named names.
+//
+// The shard returned by owner is the index into names.
+func newRing(opts Options, names []string) *ring {
+	r := &ring{
+		opts:   opts,
+		points: make([]point, 0, len(names)*opts.GetVirtualNodes()),
+	}
+	for i, name := range names {
+		for v := 0; v < opts.GetVirtualNodes(); v++ {
+			r.points = append(r.points, point{
+				hash:  r.hash([]byte(name + "#" + strconv.Itoa(v))),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			// Make it deterministic on hash collisions.
+			return r.points[i].shard < r.points[j].shard
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// remap returns a copy of r with the shard indexes mapped by indexes,
+// i.e. shard i on r is shard indexes[i] on the returned ring.
+func (r *ring) remap(indexes []int) *ring {
+	remapped := &ring{
+		opts:   r.opts,
+		points: make([]point, len(r.points)),
+	}
+	for i, p := range r.points {
+		p.shard = indexes[p.shard]
+		remapped.points[i] = p
+	}
+	return remapped
+}
+
+// hash hashes data with the hash function from the options.
+//
+// The result is mixed with the finalizer of MurmurHash3,
+// as the hash functions like FNV don't distribute similar short inputs well
+// in the high bits.
+func (r *ring) hash(data []byte) uint64 {
+	h := r.opts.GetHashFunc()()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// owner returns the index of the shard owning key.
+func (r *ring) owner(key fsdb.Key) int {
+	h := r.hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
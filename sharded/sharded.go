@@ -0,0 +1,293 @@
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fishy/rowlock"
+
+	"github.com/fishy/fsdb"
+)
+
+// Make sure *impl satisfies FSDB interface.
+var _ FSDB = (*impl)(nil)
+
+// ErrNoShards is the error returned by Open when no shards are given.
+var ErrNoShards = errors.New("sharded: no shards")
+
+// Shard is a single shard of a sharded FSDB.
+type Shard struct {
+	// Name identifies the shard on the consistent hashing ring.
+	//
+	// It must be unique and stable:
+	// renaming a shard routes its keys to other shards.
+	// The position of the shard in the list passed to Open doesn't matter.
+	Name string
+
+	DB fsdb.Local
+}
+
+// FSDB is the fsdb.Local returned by Open.
+type FSDB interface {
+	fsdb.Local
+
+	// AddShard adds a new shard,
+	// and moves the keys now owned by it from the other shards.
+	//
+	// The FSDB stays fully usable during the move.
+	// If the move fails, the new shard is still added,
+	// and the move can be resumed by Rebalance.
+	AddShard(ctx context.Context, shard Shard) error
+
+	// Rebalance resumes the move of a failed AddShard,
+	// or the one set by OptionsBuilder.SetPreviousShards.
+	//
+	// It's a no-op if there's no unfinished move.
+	Rebalance(ctx context.Context) error
+}
+
+type impl struct {
+	opts  Options
+	locks *rowlock.RowLock
+
+	// rebalance is held by AddShard and Rebalance.
+	rebalance sync.Mutex
+
+	lock   sync.RWMutex
+	shards []Shard
+	ring   *ring
+	// prev is the ring before the last AddShard,
+	// it's only non-nil while the keys are being moved.
+	// The shard indexes on it are the indexes into shards.
+	prev *ring
+}
+
+// Open creates a sharded FSDB over shards.
+//
+// Keys are routed to shards by consistent hashing on the shard names.
+//
+// If the previous shards are set in opts,
+// call Rebalance to finish the move.
+func Open(shards []Shard, opts Options) (FSDB, error) {
+	if len(shards) == 0 {
+		return nil, ErrNoShards
+	}
+	if err := checkNames(shards); err != nil {
+		return nil, err
+	}
+	db := &impl{
+		opts:   opts,
+		locks:  rowlock.NewRowLock(rowlock.MutexNewLocker),
+		shards: append([]Shard(nil), shards...),
+	}
+	db.ring = newRing(opts, db.names())
+	if names := opts.GetPreviousShards(); len(names) > 0 {
+		prev, err := db.prevRing(names)
+		if err != nil {
+			return nil, err
+		}
+		db.prev = prev
+	}
+	return db, nil
+}
+
+// prevRing creates the ring of the previous shards named names.
+func (db *impl) prevRing(names []string) (*ring, error) {
+	index := make(map[string]int, len(db.shards))
+	for i, shard := range db.shards {
+		index[shard.Name] = i
+	}
+	indexes := make([]int, len(names))
+	for i, name := range names {
+		j, ok := index[name]
+		if !ok {
+			return nil, fmt.Errorf("sharded: previous shard %q not in shards", name)
+		}
+		indexes[i] = j
+	}
+	return newRing(db.opts, names).remap(indexes), nil
+}
+
+// checkNames checks that the shard names are non-empty and unique.
+func checkNames(shards []Shard) error {
+	seen := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		if shard.Name == "" {
+			return errors.New("sharded: empty shard name")
+		}
+		if seen[shard.Name] {
+			return fmt.Errorf("sharded: duplicate shard name %q", shard.Name)
+		}
+		seen[shard.Name] = true
+	}
+	return nil
+}
+
+// names returns the names of all the shards.
+//
+// It must be called with the lock held.
+func (db *impl) names() []string {
+	names := make([]string, len(db.shards))
+	for i, shard := range db.shards {
+		names[i] = shard.Name
+	}
+	return names
+}
+
+// route returns the shard owning key,
+// and the shard owned it before the last AddShard if it's different and the
+// keys are still being moved, or nil otherwise.
+func (db *impl) route(key fsdb.Key) (current, prev fsdb.Local) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	i := db.ring.owner(key)
+	current = db.shards[i].DB
+	if db.prev != nil {
+		if j := db.prev.owner(key); j != i {
+			prev = db.shards[j].DB
+		}
+	}
+	return current, prev
+}
+
+func (db *impl) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	current, prev := db.route(key)
+	reader, err := current.Read(ctx, key)
+	if prev == nil || !fsdb.IsNoSuchKeyError(err) {
+		return reader, err
+	}
+	reader, err = prev.Read(ctx, key)
+	if !fsdb.IsNoSuchKeyError(err) {
+		return reader, err
+	}
+	// It could be moved between the two reads.
+	return current.Read(ctx, key)
+}
+
+func (db *impl) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	current, prev := db.route(key)
+	if err := current.Write(ctx, key, data); err != nil {
+		return err
+	}
+	if prev != nil {
+		// Remove the stale copy so it won't be moved over the new data.
+		if err := prev.Delete(ctx, key); err != nil && !fsdb.IsNoSuchKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *impl) Delete(ctx context.Context, key fsdb.Key) error {
+	select {
+	default:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	db.locks.Lock(string(key))
+	defer db.locks.Unlock(string(key))
+
+	current, prev := db.route(key)
+	err := current.Delete(ctx, key)
+	if prev == nil {
+		return err
+	}
+	prevErr := prev.Delete(ctx, key)
+	if fsdb.IsNoSuchKeyError(err) && fsdb.IsNoSuchKeyError(prevErr) {
+		return err
+	}
+	if fsdb.IsNoSuchKeyError(err) {
+		err = nil
+	}
+	if fsdb.IsNoSuchKeyError(prevErr) {
+		prevErr = nil
+	}
+	return fsdb.CombineErrors(err, prevErr)
+}
+
+// ScanKeys scans all the shards concurrently.
+//
+// keyFunc and errFunc are never called concurrently.
+// Keys found on a shard not owning them (e.g. left by an interrupted process)
+// are skipped.
+func (db *impl) ScanKeys(
+	ctx context.Context,
+	keyFunc fsdb.KeyFunc,
+	errFunc fsdb.ErrFunc,
+) error {
+	db.lock.RLock()
+	shards := db.shards
+	current, prev := db.ring, db.prev
+	db.lock.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	aborted := false
+	// seen is only used while keys are being moved,
+	// as the same key could be on two shards.
+	var seen map[string]bool
+	if prev != nil {
+		seen = make(map[string]bool)
+	}
+
+	errs := fsdb.BatchDo(ctx, len(shards), len(shards), func(i int) error {
+		return shards[i].DB.ScanKeys(
+			ctx,
+			func(key fsdb.Key) bool {
+				if current.owner(key) != i && (prev == nil || prev.owner(key) != i) {
+					return true
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				if aborted {
+					return false
+				}
+				if seen != nil {
+					if seen[string(key)] {
+						return true
+					}
+					seen[string(key)] = true
+				}
+				if !keyFunc(key) {
+					aborted = true
+					cancel()
+					return false
+				}
+				return true
+			},
+			func(path string, err error) bool {
+				lock.Lock()
+				defer lock.Unlock()
+				return errFunc(path, err)
+			},
+		)
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if aborted {
+		return nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
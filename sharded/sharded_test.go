@@ -0,0 +1,394 @@
+package sharded_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fishy/fsdb"
+	"github.com/fishy/fsdb/fsdbtest"
+	"github.com/fishy/fsdb/memory"
+	"github.com/fishy/fsdb/sharded"
+)
+
+func TestConformance(t *testing.T) {
+	fsdbtest.TestLocal(t, func(t *testing.T) fsdb.Local {
+		db, _ := openSharded(t, 4)
+		return db
+	})
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := sharded.Open(nil, sharded.NewDefaultOptions()); err != sharded.ErrNoShards {
+		t.Errorf("Open without shards expected ErrNoShards, got %v", err)
+	}
+	if _, err := sharded.Open(
+		[]sharded.Shard{
+			{Name: "foo", DB: memory.Open(memory.NewDefaultOptions())},
+			{Name: "foo", DB: memory.Open(memory.NewDefaultOptions())},
+		},
+		sharded.NewDefaultOptions(),
+	); err == nil {
+		t.Error("Open with duplicate shard names should fail")
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	const n = 1000
+	ctx := context.Background()
+	db, shards := openSharded(t, 4)
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Write(ctx, key, strings.NewReader(key.String())); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	total := 0
+	for i, shard := range shards {
+		count := countKeys(t, shard.DB)
+		total += count
+		// Expect 250 per shard, allow some skew.
+		if count < n/8 || count > n/2 {
+			t.Errorf("Shard %d has %d keys, expected around %d", i, count, n/4)
+		}
+	}
+	if total != n {
+		t.Errorf("Expected %d keys in total, got %d", n, total)
+	}
+}
+
+func TestAddShard(t *testing.T) {
+	const n = 1000
+	ctx := context.Background()
+	db, shards := openSharded(t, 3)
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Write(ctx, key, strings.NewReader(key.String())); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	before := make([]int, len(shards))
+	for i, shard := range shards {
+		before[i] = countKeys(t, shard.DB)
+		// Add metadata and TTL directly on the shards.
+		var keys []fsdb.Key
+		if err := shard.DB.ScanKeys(
+			ctx,
+			func(key fsdb.Key) bool {
+				keys = append(keys, key)
+				return true
+			},
+			fsdb.StopAll,
+		); err != nil {
+			t.Fatalf("ScanKeys failed: %v", err)
+		}
+		for _, key := range keys {
+			if err := shard.DB.(fsdb.OptionsWriter).WriteWithOptions(
+				ctx,
+				key,
+				strings.NewReader(key.String()),
+				fsdb.WriteOptions{
+					Metadata: fsdb.Metadata{"key": key.String()},
+					TTL:      time.Hour,
+				},
+			); err != nil {
+				t.Fatalf("WriteWithOptions failed: %v", err)
+			}
+		}
+	}
+
+	newShard := sharded.Shard{
+		Name: "shard-new",
+		DB:   memory.Open(memory.NewDefaultOptions()),
+	}
+	if err := db.AddShard(ctx, newShard); err != nil {
+		t.Fatalf("AddShard failed: %v", err)
+	}
+
+	moved := countKeys(t, newShard.DB)
+	if moved < n/8 || moved > n/2 {
+		t.Errorf("Expected around %d keys moved, got %d", n/4, moved)
+	}
+	total := moved
+	for i, shard := range shards {
+		count := countKeys(t, shard.DB)
+		total += count
+		if count > before[i] {
+			t.Errorf("Shard %d got keys during rebalance: %d -> %d", i, before[i], count)
+		}
+	}
+	if total != n {
+		t.Errorf("Expected %d keys in total after rebalance, got %d", n, total)
+	}
+	if count := countKeys(t, db); count != n {
+		t.Errorf("ScanKeys expected %d keys, got %d", n, count)
+	}
+
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		reader, err := db.Read(ctx, key)
+		if err != nil {
+			t.Fatalf("Read %v failed: %v", key, err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Read %v content failed: %v", key, err)
+		}
+		if string(data) != key.String() {
+			t.Errorf("Read %v expected %q, got %q", key, key, data)
+		}
+	}
+
+	// Check metadata and TTL moved.
+	if err := newShard.DB.ScanKeys(
+		ctx,
+		func(key fsdb.Key) bool {
+			metadata, err := newShard.DB.(fsdb.MetadataFSDB).ReadMetadata(ctx, key)
+			if err != nil {
+				t.Fatalf("ReadMetadata %v failed: %v", key, err)
+			}
+			if metadata["key"] != key.String() {
+				t.Errorf("Metadata of %v expected %q, got %v", key, key, metadata)
+			}
+			info, err := newShard.DB.(fsdb.Stater).Stat(ctx, key)
+			if err != nil {
+				t.Fatalf("Stat %v failed: %v", key, err)
+			}
+			if info.Expires.IsZero() {
+				t.Errorf("Expiration of %v not moved", key)
+			}
+			return false
+		},
+		fsdb.StopAll,
+	); err != nil {
+		t.Fatalf("ScanKeys failed: %v", err)
+	}
+
+	if err := db.AddShard(ctx, newShard); err == nil {
+		t.Error("AddShard with duplicate shard name should fail")
+	}
+}
+
+func TestAddShardFailed(t *testing.T) {
+	const n = 100
+	ctx := context.Background()
+	db, _ := openSharded(t, 2)
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Write(ctx, key, strings.NewReader("old")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	fail := true
+	newShard := sharded.Shard{
+		Name: "shard-new",
+		DB: memory.Open(memory.NewDefaultOptions().SetHook(
+			func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+				if fail && op == memory.OpWrite {
+					return fmt.Errorf("injected")
+				}
+				return nil
+			},
+		)),
+	}
+	if err := db.AddShard(ctx, newShard); err == nil {
+		t.Fatal("AddShard expected to fail")
+	}
+
+	// Still readable during the unfinished move.
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		reader, err := db.Read(ctx, key)
+		if err != nil {
+			t.Fatalf("Read %v failed: %v", key, err)
+		}
+		reader.Close()
+	}
+	if count := countKeys(t, db); count != n {
+		t.Errorf("ScanKeys expected %d keys, got %d", n, count)
+	}
+
+	fail = false
+	// Overwrite some keys and delete some keys before resuming.
+	for i := 0; i < n; i += 2 {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Write(ctx, key, strings.NewReader("new")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	for i := 1; i < n; i += 4 {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if err := db.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance failed: %v", err)
+	}
+
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		reader, err := db.Read(ctx, key)
+		if i%4 == 1 {
+			if !fsdb.IsNoSuchKeyError(err) {
+				t.Errorf("Read deleted %v expected NoSuchKeyError, got %v", key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read %v failed: %v", key, err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Read %v content failed: %v", key, err)
+		}
+		expected := "old"
+		if i%2 == 0 {
+			expected = "new"
+		}
+		if string(data) != expected {
+			t.Errorf("Read %v expected %q, got %q", key, expected, data)
+		}
+	}
+	if count := countKeys(t, db); count != n*3/4 {
+		t.Errorf("ScanKeys expected %d keys, got %d", n*3/4, count)
+	}
+}
+
+func TestPreviousShards(t *testing.T) {
+	const n = 200
+	ctx := context.Background()
+	db, shards := openSharded(t, 3)
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		if err := db.Write(ctx, key, strings.NewReader(key.String())); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	// The process exits after moving some of the keys to the new shard,
+	// simulated by failing the writes after that.
+	const moved = 10
+	writes := 0
+	exited := false
+	newShard := sharded.Shard{
+		Name: "shard-new",
+		DB: memory.Open(memory.NewDefaultOptions().SetHook(
+			func(ctx context.Context, op memory.Op, key fsdb.Key) error {
+				if op == memory.OpWrite {
+					writes++
+					if writes > moved && !exited {
+						return fmt.Errorf("injected")
+					}
+				}
+				return nil
+			},
+		)),
+	}
+	if err := db.AddShard(ctx, newShard); err == nil {
+		t.Fatal("AddShard expected to fail")
+	}
+	if count := countKeys(t, newShard.DB); count != moved {
+		t.Fatalf("Expected %d keys moved before exiting, got %d", moved, count)
+	}
+	exited = true
+
+	if _, err := sharded.Open(
+		shards,
+		sharded.NewDefaultOptions().SetPreviousShards("shard-0", "shard-other"),
+	); err == nil {
+		t.Error("Open with unknown previous shard should fail")
+	}
+	// The order of the shards doesn't matter.
+	all := []sharded.Shard{newShard, shards[2], shards[0], shards[1]}
+	db, err := sharded.Open(
+		all,
+		sharded.NewDefaultOptions().SetPreviousShards("shard-2", "shard-1", "shard-0"),
+	)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkKeys(t, db, n)
+	if count := countKeys(t, db); count != n {
+		t.Errorf("ScanKeys expected %d keys, got %d", n, count)
+	}
+
+	if err := db.Rebalance(ctx); err != nil {
+		t.Fatalf("Rebalance failed: %v", err)
+	}
+	db, err = sharded.Open(all, sharded.NewDefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkKeys(t, db, n)
+	total := 0
+	for _, shard := range all {
+		total += countKeys(t, shard.DB)
+	}
+	if total != n {
+		t.Errorf("Expected %d keys in total on the shards, got %d", n, total)
+	}
+}
+
+func openSharded(t *testing.T, n int) (sharded.FSDB, []sharded.Shard) {
+	t.Helper()
+
+	shards := make([]sharded.Shard, n)
+	for i := range shards {
+		shards[i] = sharded.Shard{
+			Name: fmt.Sprintf("shard-%d", i),
+			DB:   memory.Open(memory.NewDefaultOptions()),
+		}
+	}
+	db, err := sharded.Open(shards, sharded.NewDefaultOptions())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return db, shards
+}
+
+// checkKeys checks that key-0 to key-(n-1) are all readable with their names
+// as the content.
+func checkKeys(t *testing.T, db fsdb.FSDB, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		key := fsdb.Key(fmt.Sprintf("key-%d", i))
+		reader, err := db.Read(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Read %v failed: %v", key, err)
+		}
+		data, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Read %v content failed: %v", key, err)
+		}
+		if string(data) != key.String() {
+			t.Errorf("Read %v expected %q, got %q", key, key.String(), data)
+		}
+	}
+}
+
+func countKeys(t *testing.T, db fsdb.Local) int {
+	t.Helper()
+
+	count := 0
+	if err := db.ScanKeys(
+		context.Background(),
+		func(key fsdb.Key) bool {
+			count++
+			return true
+		},
+		fsdb.StopAll,
+	); err != nil {
+		t.Fatalf("ScanKeys failed: %v", err)
+	}
+	return count
+}
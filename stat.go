@@ -0,0 +1,80 @@
+package fsdb
+
+import (
+	"context"
+	"time"
+)
+
+// Codec names used in EntryInfo.
+const (
+	CodecPlain = "plain"
+	CodecGzip  = "gzip"
+	CodecZlib  = "zlib"
+	CodecFlate = "flate"
+)
+
+// EntryInfo describes an entry without opening its data.
+type EntryInfo struct {
+	// Key is the key of the entry.
+	Key Key
+
+	// Size is the size of the data as returned by Read.
+	//
+	// It's -1 when the implementation can't tell without reading the data.
+	Size int64
+
+	// StoredSize is the size of the data on the storage,
+	// which could be smaller than Size when the data is compressed.
+	//
+	// It's -1 when the implementation can't tell without reading the data.
+	StoredSize int64
+
+	// Codec is the name of the compression used on the storage,
+	// e.g. CodecPlain or CodecGzip.
+	//
+	// It could be empty when the implementation can't tell.
+	Codec string
+
+	// Encrypted is true when the data is encrypted on the storage.
+	Encrypted bool
+
+	// Checksum is the hex encoded SHA-256 checksum of the data as returned by
+	// Read.
+	//
+	// It's empty when the implementation doesn't store checksums.
+	Checksum string
+
+	// Generation changes every time the entry is written.
+	//
+	// It's 0 when the implementation does not support ConditionalWriter.
+	Generation int64
+
+	// Expires is the time the entry expires.
+	//
+	// It's zero when the entry never expires.
+	Expires time.Time
+
+	// ModTime is the time the entry was last written.
+	//
+	// It could be zero when the implementation can't tell.
+	ModTime time.Time
+
+	// Local is true when the entry exists on the local storage.
+	Local bool
+
+	// Remote is true when the entry exists on the remote storage.
+	//
+	// It's always false for local implementations.
+	Remote bool
+}
+
+// Stater defines an optional interface for FSDB implementations that can
+// return the metadata of an entry without reading its data.
+type Stater interface {
+	// Stat returns the metadata of an entry.
+	//
+	// If the key does not exist, it should return a NoSuchKeyError.
+	//
+	// It should never return both nil info and nil err.
+	Stat(ctx context.Context, key Key) (info *EntryInfo, err error)
+}
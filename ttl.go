@@ -0,0 +1,101 @@
+package fsdb
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// WriteOptions are the extra options used by OptionsWriter.
+type WriteOptions struct {
+	// Metadata is the user metadata to be stored with the entry.
+	Metadata Metadata
+
+	// TTL is the time-to-live of the entry.
+	//
+	// Zero means using the default TTL of the implementation, if any.
+	// Negative means the entry never expires.
+	TTL time.Duration
+
+	// Generation is the generation of the entry,
+	// for implementations supporting ConditionalWriter.
+	//
+	// Zero means a new generation.
+	// It's meant for copying an entry with its generation,
+	// e.g. hybrid downloading an entry from the remote bucket.
+	// The caller must make sure it's not older than the current generation of
+	// the entry, if any.
+	Generation int64
+}
+
+// OptionsWriter defines an optional interface for FSDB implementations that
+// can write an entry with all the extra options at once.
+type OptionsWriter interface {
+	// WriteWithOptions writes an entry with extra options.
+	//
+	// Same as Write, if the key already exists, it will be overwritten.
+	WriteWithOptions(
+		ctx context.Context,
+		key Key,
+		data io.Reader,
+		opts WriteOptions,
+	) error
+}
+
+// Expirer defines an optional interface for FSDB implementations supporting
+// time-to-live on entries.
+//
+// Expired entries behave as if they do not exist immediately,
+// but they are only physically removed by Reap.
+type Expirer interface {
+	// WriteWithTTL writes an entry that expires after ttl.
+	//
+	// Negative ttl means the entry never expires.
+	// Zero ttl means using the default TTL of the implementation, if any.
+	WriteWithTTL(
+		ctx context.Context,
+		key Key,
+		data io.Reader,
+		ttl time.Duration,
+	) error
+
+	// Reap physically removes all the expired entries.
+	//
+	// Implementations backed by remote storage could only find the expired
+	// entries they can list,
+	// e.g. hybrid FSDB can't find the entries only on the remote bucket when
+	// the bucket doesn't implement bucket.Lister.
+	// Such entries still behave as if they do not exist after expiration.
+	//
+	// keyFunc, if not nil, is called for every entry removed.
+	// It should return true to continue and false to abort.
+	//
+	// This function would be heavy on IO and takes a long time. Use with caution.
+	Reap(ctx context.Context, keyFunc KeyFunc) error
+}
+
+// StartReaper starts a background goroutine calling Reap on e every interval,
+// until ctx is canceled.
+//
+// errFunc, if not nil, is called with the errors returned by Reap.
+func StartReaper(
+	ctx context.Context,
+	e Expirer,
+	interval time.Duration,
+	errFunc func(err error),
+) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := e.Reap(ctx, nil); err != nil && errFunc != nil {
+					errFunc(err)
+				}
+			}
+		}
+	}()
+}
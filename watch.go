@@ -0,0 +1,51 @@
+package fsdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+// EventType values.
+const (
+	// EventWrite is emitted after an entry is written (created or overwritten).
+	EventWrite EventType = iota + 1
+
+	// EventDelete is emitted after an entry is deleted,
+	// including expired entries removed by Reap.
+	EventDelete
+)
+
+func (t EventType) String() string {
+	switch t {
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	case EventWrite:
+		return "write"
+	case EventDelete:
+		return "delete"
+	}
+}
+
+// Event describes a change to an entry.
+type Event struct {
+	Type EventType
+	Key  Key
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v %q", e.Type, e.Key)
+}
+
+// Watcher defines an optional interface for FSDB implementations that can
+// report changes to entries.
+type Watcher interface {
+	// Watch returns a channel of events happened after Watch is called.
+	//
+	// The channel is closed after ctx is canceled.
+	// Slow consumers never block the operations emitting the events,
+	// but the events will be queued in memory until consumed.
+	Watch(ctx context.Context) <-chan Event
+}
@@ -0,0 +1,118 @@
+package fsdb
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrWriterClosed is the error returned by EntryWriter when it's used after
+// Commit or Abort.
+var ErrWriterClosed = errors.New("fsdb: entry writer already closed")
+
+// errAborted is used to fail the underlying Write of the EntryWriter returned
+// by OpenWriter when it's aborted.
+var errAborted = errors.New("fsdb: entry writer aborted")
+
+// EntryWriter is an io.Writer writing the data of an entry.
+//
+// The data is only visible to readers after Commit returns nil error.
+//
+// It's not safe for concurrent use.
+type EntryWriter interface {
+	io.Writer
+
+	// Commit finishes the write and makes the entry visible.
+	//
+	// If the key already exists, it will be overwritten.
+	//
+	// If any Write failed, the data written is incomplete,
+	// so Commit discards it and returns an error instead.
+	//
+	// Commit after Commit or Abort returns ErrWriterClosed.
+	Commit() error
+
+	// Abort discards the data written and leaves the entry untouched.
+	//
+	// Abort after Commit or Abort is a no-op,
+	// so it's safe to defer Abort right after OpenWriter.
+	Abort() error
+}
+
+// StreamWriter defines an optional interface for FSDB implementations that
+// accept data pushed through an io.Writer.
+type StreamWriter interface {
+	// OpenWriter opens an EntryWriter for the key.
+	//
+	// ctx is used for the whole lifetime of the EntryWriter.
+	//
+	// It's the caller's responsibility to call either Commit or Abort on the
+	// EntryWriter returned.
+	OpenWriter(ctx context.Context, key Key) (writer EntryWriter, err error)
+}
+
+// OpenWriter opens an EntryWriter for the key on db.
+//
+// If db implements StreamWriter, its OpenWriter function will be used.
+// Otherwise it falls back to a Write running in a separate goroutine,
+// fed by an io.Pipe.
+// In that case Abort relies on db not committing the write when the data
+// reader returns an error.
+func OpenWriter(ctx context.Context, db FSDB, key Key) (EntryWriter, error) {
+	if sw, ok := db.(StreamWriter); ok {
+		return sw.OpenWriter(ctx, key)
+	}
+
+	select {
+	default:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	reader, writer := io.Pipe()
+	w := &pipeEntryWriter{
+		writer: writer,
+		result: make(chan error, 1),
+	}
+	go func() {
+		err := db.Write(ctx, key, reader)
+		// Unblock any pending Write calls if db.Write returns early.
+		reader.CloseWithError(ErrWriterClosed)
+		w.result <- err
+	}()
+	return w, nil
+}
+
+// pipeEntryWriter is the EntryWriter returned by OpenWriter for FSDB
+// implementations not implementing StreamWriter.
+type pipeEntryWriter struct {
+	writer *io.PipeWriter
+	result chan error
+	done   bool
+}
+
+func (w *pipeEntryWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, ErrWriterClosed
+	}
+	return w.writer.Write(p)
+}
+
+func (w *pipeEntryWriter) Commit() error {
+	if w.done {
+		return ErrWriterClosed
+	}
+	w.done = true
+	w.writer.Close()
+	return <-w.result
+}
+
+func (w *pipeEntryWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.writer.CloseWithError(errAborted)
+	<-w.result
+	return nil
+}
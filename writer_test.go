@@ -0,0 +1,102 @@
+package fsdb_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/fishy/fsdb"
+)
+
+// mapDB is a minimal FSDB implementation without StreamWriter.
+type mapDB struct {
+	lock sync.Mutex
+	data map[string][]byte
+}
+
+func (db *mapDB) Read(ctx context.Context, key fsdb.Key) (io.ReadCloser, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	data, ok := db.data[string(key)]
+	if !ok {
+		return nil, &fsdb.NoSuchKeyError{Key: key}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (db *mapDB) Write(ctx context.Context, key fsdb.Key, data io.Reader) error {
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.data[string(key)] = buf
+	return nil
+}
+
+func (db *mapDB) Delete(ctx context.Context, key fsdb.Key) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, ok := db.data[string(key)]; !ok {
+		return &fsdb.NoSuchKeyError{Key: key}
+	}
+	delete(db.data, string(key))
+	return nil
+}
+
+func TestOpenWriter(t *testing.T) {
+	ctx := context.Background()
+	db := &mapDB{data: make(map[string][]byte)}
+	key := fsdb.Key("foo")
+	content := "Hello, world!"
+
+	writer, err := fsdb.OpenWriter(ctx, db, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	if _, err := db.Read(ctx, key); !fsdb.IsNoSuchKeyError(err) {
+		t.Errorf("Expected NoSuchKeyError after Abort, got %v", err)
+	}
+	if err := writer.Commit(); err != fsdb.ErrWriterClosed {
+		t.Errorf("Commit after Abort expected ErrWriterClosed, got %v", err)
+	}
+
+	writer, err = fsdb.OpenWriter(ctx, db, key)
+	if err != nil {
+		t.Fatalf("OpenWriter failed: %v", err)
+	}
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := writer.Abort(); err != nil {
+		t.Errorf("Abort after Commit failed: %v", err)
+	}
+	if _, err := writer.Write([]byte(content)); err != fsdb.ErrWriterClosed {
+		t.Errorf("Write after Commit expected ErrWriterClosed, got %v", err)
+	}
+	reader, err := db.Read(ctx, key)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	defer reader.Close()
+	actual, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read content failed: %v", err)
+	}
+	if string(actual) != content {
+		t.Errorf("Expected %q, got %q", content, actual)
+	}
+}